@@ -0,0 +1,136 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+)
+
+// auditEvent is a single entry in the audit log
+type auditEvent struct {
+	// Timestamp is the time of the decision
+	Timestamp time.Time `json:"timestamp"`
+	// User is the username of the subject
+	User string `json:"user"`
+	// Groups are the groups of the subject
+	Groups []string `json:"groups,omitempty"`
+	// Verb is the verb of the request
+	Verb string `json:"verb,omitempty"`
+	// Namespace is the namespace of a resource request
+	Namespace string `json:"namespace,omitempty"`
+	// APIGroup is the api group of a resource request
+	APIGroup string `json:"apiGroup,omitempty"`
+	// Resource is the resource of a resource request
+	Resource string `json:"resource,omitempty"`
+	// Subresource is the subresource of a resource request
+	Subresource string `json:"subresource,omitempty"`
+	// Name is the name of the resource
+	Name string `json:"name,omitempty"`
+	// Path is the path of a non resource request
+	Path string `json:"path,omitempty"`
+	// Allowed indicates if the request was permitted
+	Allowed bool `json:"allowed"`
+	// Decision is the decision of the deciding source
+	Decision string `json:"decision"`
+	// Source is the name of the deciding source
+	Source string `json:"source,omitempty"`
+	// Reason is the reason given
+	Reason string `json:"reason,omitempty"`
+	// EvaluationError is any errors from the sources
+	EvaluationError string `json:"evaluationError,omitempty"`
+	// Explanation describes the rule which matched or the closest rules if none did
+	Explanation string `json:"explanation,omitempty"`
+	// BreakGlass indicates the request was allowed by a break-glass grant
+	BreakGlass bool `json:"breakGlass,omitempty"`
+}
+
+// auditor writes the audit events, or any other records, as json lines
+type auditor struct {
+	sync.Mutex
+	writer io.Writer
+}
+
+// newAuditor creates an auditor writing to the file, a filename of - writes to stdout
+func newAuditor(filename string) (*auditor, error) {
+	if filename == "-" {
+		return &auditor{writer: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditor{writer: file}, nil
+}
+
+// newAuditEvent creates an audit event from the request and the result
+func newAuditEvent(a authorizer.Attributes, result *authorizationResult) *auditEvent {
+	event := &auditEvent{
+		Timestamp:       time.Now().UTC(),
+		Verb:            a.GetVerb(),
+		Allowed:         result.allowed(),
+		Decision:        result.decision.String(),
+		Source:          result.source,
+		Reason:          result.reason,
+		EvaluationError: result.evaluationError,
+		Explanation:     result.explanation,
+		BreakGlass:      result.source == breakGlassSource,
+	}
+	if u := a.GetUser(); u != nil {
+		event.User = u.GetName()
+		event.Groups = u.GetGroups()
+	}
+	if a.IsResourceRequest() {
+		event.Namespace = a.GetNamespace()
+		event.APIGroup = a.GetAPIGroup()
+		event.Resource = a.GetResource()
+		event.Subresource = a.GetSubresource()
+		event.Name = a.GetName()
+	} else {
+		event.Path = a.GetPath()
+	}
+
+	return event
+}
+
+// record writes the event to the log
+func (a *auditor) record(event interface{}) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("unable to encode the audit event")
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, err := a.writer.Write(append(encoded, '\n')); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("unable to write the audit event")
+	}
+}
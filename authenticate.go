@@ -20,12 +20,46 @@ package main
 import (
 	"k8s.io/kubernetes/pkg/api/unversioned"
 	"k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/user"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
 )
 
+// unionAuthenticator tries each of the authenticators in order, the first to find the token wins
+type unionAuthenticator []authentication
+
+// AuthenticateToken checks each authenticator in turn; errors are only returned if no
+// authenticator was able to find the token
+func (u unionAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	var errs []error
+	for _, x := range u {
+		if x == nil {
+			continue
+		}
+		info, found, err := x.AuthenticateToken(token)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if found {
+			return info, true, nil
+		}
+	}
+
+	return nil, false, utilerrors.NewAggregate(errs)
+}
+
 // authentication is responsible for authenticating the user
 func (s *service) authentication(review *v1beta1.TokenReview) (v1beta1.TokenReview, error) {
+	// @note: we don't hold the lock across the call as the upstream may be slow
 	s.RLock()
-	defer s.RUnlock()
+	var tokens unionAuthenticator
+	if s.tokens != nil {
+		tokens = append(tokens, s.tokens)
+	}
+	// @note: the json web tokens are verified locally, so are checked before the upstream
+	tokens = append(tokens, s.jwt, s.upstream)
+	mapping := s.mapping
+	s.RUnlock()
 
 	var response = v1beta1.TokenReview{
 		TypeMeta: unversioned.TypeMeta{
@@ -34,7 +68,7 @@ func (s *service) authentication(review *v1beta1.TokenReview) (v1beta1.TokenRevi
 		},
 	}
 
-	user, found, err := s.tokens.AuthenticateToken(review.Spec.Token)
+	info, found, err := tokens.AuthenticateToken(review.Spec.Token)
 	if err != nil {
 		return response, err
 	}
@@ -42,15 +76,33 @@ func (s *service) authentication(review *v1beta1.TokenReview) (v1beta1.TokenRevi
 		response.Status = v1beta1.TokenReviewStatus{Authenticated: false, Error: "token not found"}
 		return response, nil
 	}
+	// step: apply any identity mapping rules
+	if mapping != nil {
+		info = mapping.apply(info)
+	}
 
 	response.Status = v1beta1.TokenReviewStatus{
 		Authenticated: true,
 		User: v1beta1.UserInfo{
-			UID:      user.GetUID(),
-			Username: user.GetName(),
-			Groups:   user.GetGroups(),
+			UID:      info.GetUID(),
+			Username: info.GetName(),
+			Groups:   info.GetGroups(),
+			Extra:    convertUserExtra(info.GetExtra()),
 		},
 	}
 
 	return response, nil
 }
+
+// convertUserExtra converts the user extra attributes into the review format
+func convertUserExtra(extra map[string][]string) map[string]v1beta1.ExtraValue {
+	if len(extra) == 0 {
+		return nil
+	}
+	converted := make(map[string]v1beta1.ExtraValue, len(extra))
+	for k, v := range extra {
+		converted[k] = v1beta1.ExtraValue(v)
+	}
+
+	return converted
+}
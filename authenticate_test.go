@@ -20,6 +20,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"os"
 	"testing"
 	"time"
 
@@ -146,6 +147,47 @@ func TestAuthenticationFileChange(t *testing.T) {
 	assert.Equal(t, expected, status)
 }
 
+func TestAuthenticationTokenFilesChange(t *testing.T) {
+	f, err := writeTestFile("token10,deployer,uuid10\n")
+	if err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		tokenFiles: []string{f.Name() + "?group=robots&prefix=robot:"},
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	authenticate := func(token string) v1beta1.TokenReviewStatus {
+		status, err := makeTestAuthRequest(s.URL(), v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: token}})
+		assert.NoError(t, err)
+		return status.Status
+	}
+	status := authenticate("token10")
+	assert.True(t, status.Authenticated)
+	assert.Equal(t, "robot:deployer", status.User.Username)
+	assert.Equal(t, []string{"robots"}, status.User.Groups)
+	assert.True(t, authenticate("token1").Authenticated)
+
+	// step: a new token in the robots file is picked up
+	updateTestFile(t, f.Name(), "token11,builder,uuid11\n")
+	time.Sleep(800 * time.Millisecond)
+	status = authenticate("token11")
+	assert.True(t, status.Authenticated)
+	assert.Equal(t, "robot:builder", status.User.Username)
+
+	// step: a token redefined by the later file is taken from it
+	updateTestFile(t, f.Name(), "token1,builder,uuid12\ntoken12,other,uuid12\n")
+	time.Sleep(800 * time.Millisecond)
+	assert.Equal(t, "robot:builder", authenticate("token1").User.Username)
+	assert.True(t, authenticate("token11").Authenticated)
+	assert.True(t, authenticate("token12").Authenticated)
+}
+
 func makeTestAuthRequest(url string, review v1beta1.TokenReview) (v1beta1.TokenReview, error) {
 	var result v1beta1.TokenReview
 	res, err := hc.R().
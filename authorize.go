@@ -22,16 +22,20 @@ import (
 	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
 	"k8s.io/kubernetes/pkg/auth/authorizer"
 	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/Sirupsen/logrus"
 )
 
 type attributeWrapper struct {
 	review *v1beta1.SubjectAccessReview
 }
 
-// authorize is responsible for authorizing a request via the abac file
+// authorize is responsible for authorizing a request against the authorization sources
 func (s *service) authorize(review *v1beta1.SubjectAccessReview) (v1beta1.SubjectAccessReview, error) {
+	// @note: we don't hold the lock across the call as the upstream may be slow
 	s.RLock()
-	defer s.RUnlock()
+	authz, audit, shadow, explain := s.authz, s.audit, s.shadow, s.cfg.explainDecisions
+	s.RUnlock()
 
 	var response = v1beta1.SubjectAccessReview{
 		TypeMeta: unversioned.TypeMeta{
@@ -40,10 +44,41 @@ func (s *service) authorize(review *v1beta1.SubjectAccessReview) (v1beta1.Subjec
 		},
 	}
 
+	request := newAttributesRecord(review)
+
+	result := authz.evaluate(request, explain)
+	if shadow != nil {
+		s.queueShadow(shadow, request, result)
+	}
+	if result.evaluationError != "" {
+		logrus.WithFields(logrus.Fields{
+			"user":  review.Spec.User,
+			"error": result.evaluationError,
+		}).Error("authorization sources failed to evaluate the request")
+	}
+	if audit != nil {
+		audit.record(newAuditEvent(request, result))
+	}
+
+	response.Status = v1beta1.SubjectAccessReviewStatus{
+		Allowed:         result.allowed(),
+		Reason:          result.reason,
+		EvaluationError: result.evaluationError,
+	}
+	if explain && result.explanation != "" {
+		response.Status.Reason = result.explanation
+	}
+
+	return response, nil
+}
+
+// newAttributesRecord converts the review into the authorizer attributes
+func newAttributesRecord(review *v1beta1.SubjectAccessReview) *authorizer.AttributesRecord {
 	request := &authorizer.AttributesRecord{
 		User: &user.DefaultInfo{
 			Name:   review.Spec.User,
 			Groups: review.Spec.Groups,
+			Extra:  convertReviewExtra(review.Spec.Extra),
 		},
 	}
 	if review.Spec.ResourceAttributes != nil {
@@ -59,23 +94,20 @@ func (s *service) authorize(review *v1beta1.SubjectAccessReview) (v1beta1.Subjec
 		request.Path = review.Spec.NonResourceAttributes.Path
 		request.Verb = review.Spec.NonResourceAttributes.Verb
 	}
-
 	request.ResourceRequest = review.Spec.ResourceAttributes != nil
 
-	allowed, reason, err := s.authz.Authorize(request)
-	if err != nil {
-		return response, err
-	}
-	if !allowed {
-		response.Status = v1beta1.SubjectAccessReviewStatus{
-			Allowed: false,
-			Reason:  reason,
-		}
+	return request
+}
 
-		return response, nil
+// convertReviewExtra converts the extra attributes of the review into the user format
+func convertReviewExtra(extra map[string]v1beta1.ExtraValue) map[string][]string {
+	if len(extra) == 0 {
+		return nil
+	}
+	converted := make(map[string][]string, len(extra))
+	for k, v := range extra {
+		converted[k] = []string(v)
 	}
 
-	response.Status = v1beta1.SubjectAccessReviewStatus{Allowed: true}
-
-	return response, nil
+	return converted
 }
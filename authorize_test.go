@@ -48,6 +48,7 @@ var (
 		},
 		Status: v1beta1.SubjectAccessReviewStatus{
 			Allowed: true,
+			Reason:  "policy: allowed",
 		},
 	}
 )
@@ -129,6 +130,43 @@ func TestAuthorization(t *testing.T) {
 			},
 			Expected: successAuthzResponse,
 		},
+		{
+			Review: v1beta1.SubjectAccessReview{
+				TypeMeta: unversioned.TypeMeta{
+					Kind:       "SubjectAccessReview",
+					APIVersion: "authorization.k8s.io/v1beta1",
+				},
+				Spec: v1beta1.SubjectAccessReviewSpec{
+					User:   "user2",
+					Groups: []string{},
+					Extra:  map[string]v1beta1.ExtraValue{"team": {"platform"}},
+					ResourceAttributes: &v1beta1.ResourceAttributes{
+						Resource:  "pods",
+						Namespace: "platform",
+						Verb:      "get",
+					},
+				},
+			},
+			Expected: successAuthzResponse,
+		},
+		{
+			Review: v1beta1.SubjectAccessReview{
+				TypeMeta: unversioned.TypeMeta{
+					Kind:       "SubjectAccessReview",
+					APIVersion: "authorization.k8s.io/v1beta1",
+				},
+				Spec: v1beta1.SubjectAccessReviewSpec{
+					User:   "user2",
+					Groups: []string{},
+					ResourceAttributes: &v1beta1.ResourceAttributes{
+						Resource:  "pods",
+						Namespace: "platform",
+						Verb:      "get",
+					},
+				},
+			},
+			Expected: failedAuthzRequest,
+		},
 	}
 	for _, x := range cs {
 		status, err := makeTestAuthzRequest(s.URL(), x.Review)
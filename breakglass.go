@@ -0,0 +1,353 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+)
+
+const (
+	// breakGlassSource is the name of the break-glass authorization source
+	breakGlassSource = "break-glass"
+	// breakGlassPath is the admin endpoint for the break-glass grants
+	breakGlassPath = "/admin/breakglass"
+)
+
+// breakGlassGrant is a temporary rule given to a user or group during an incident
+type breakGlassGrant struct {
+	// ID is the identifier of the grant
+	ID string `json:"id"`
+	// Rule is the access granted
+	Rule policySpec `json:"rule"`
+	// Justification is the reason the grant was given, i.e. the incident
+	Justification string `json:"justification"`
+	// GrantedBy is the authenticated user who gave the grant
+	GrantedBy string `json:"grantedBy"`
+	// Created is the time the grant was given
+	Created time.Time `json:"created"`
+	// Expires is the time the grant expires
+	Expires time.Time `json:"expires"`
+}
+
+// breakGlassRequest is a request for a grant on the admin endpoint
+type breakGlassRequest struct {
+	// Rule is the access required, if no resources or paths are given it's all access
+	Rule policySpec `json:"rule"`
+	// TTL is the lifetime of the grant i.e. 1h
+	TTL string `json:"ttl"`
+	// Justification is the reason for the grant
+	Justification string `json:"justification"`
+}
+
+// breakGlassEntry is a change in the journal
+type breakGlassEntry struct {
+	// Timestamp is the time of the change
+	Timestamp time.Time `json:"timestamp"`
+	// Action is either grant or revoke
+	Action string `json:"action"`
+	// Actor is the authenticated user who made the change
+	Actor string `json:"actor"`
+	// Grant is the grant given or revoked
+	Grant *breakGlassGrant `json:"grant"`
+}
+
+// breakGlassStore holds the active grants and implements the authorization interface; unlike
+// the other sources it's mutable, so it lives across reloads of the union
+type breakGlassStore struct {
+	sync.RWMutex
+	// grants are the active grants
+	grants []*breakGlassGrant
+	// journal is the file the changes are appended to, if any
+	journal string
+	// maxTTL is the longest lifetime of a grant
+	maxTTL time.Duration
+	// now returns the current time
+	now func() time.Time
+}
+
+// newBreakGlassStore creates the store, restoring the unexpired grants from the journal if given
+func newBreakGlassStore(journal string, maxTTL time.Duration, now func() time.Time) (*breakGlassStore, error) {
+	b := &breakGlassStore{journal: journal, maxTTL: maxTTL, now: now}
+	if journal == "" {
+		return b, nil
+	}
+
+	file, err := os.Open(journal)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := new(breakGlassEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil || entry.Grant == nil {
+			return nil, fmt.Errorf("invalid break-glass journal %s, line %d", journal, line)
+		}
+		switch entry.Action {
+		case "grant":
+			b.grants = append(b.grants, entry.Grant)
+		case "revoke":
+			b.remove(entry.Grant.ID)
+		default:
+			return nil, fmt.Errorf("invalid break-glass journal %s, line %d: unknown action %q", journal, line, entry.Action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	b.expire(b.now())
+
+	// step: rewrite the journal with only the active grants, else it grows without bound
+	if err := b.compact(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// add validates the request and gives the grant on behalf of the caller
+func (b *breakGlassStore) add(caller string, request *breakGlassRequest) (*breakGlassGrant, error) {
+	if request.Justification == "" {
+		return nil, errors.New("a justification must be given")
+	}
+	if request.TTL == "" {
+		return nil, errors.New("a ttl must be given")
+	}
+	ttl, err := time.ParseDuration(request.TTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl: %s", request.TTL)
+	}
+	if ttl > b.maxTTL {
+		return nil, fmt.Errorf("the ttl %s exceeds the maximum of %s", ttl, b.maxTTL)
+	}
+	rule := request.Rule
+	if rule.User == "" && rule.Group == "" {
+		return nil, errors.New("a user or group must be given")
+	}
+	if rule.User == "*" || rule.Group == "*" {
+		return nil, errors.New("a grant can not be given to all users or groups")
+	}
+	if len(rule.Schedule) > 0 || rule.NotBefore != nil || rule.NotAfter != nil {
+		return nil, errors.New("the lifetime of the rule is the ttl of the grant")
+	}
+	if rule.Namespace == "" && rule.Resource == "" && rule.NonResourcePath == "" {
+		rule.Namespace, rule.Resource, rule.APIGroup, rule.NonResourcePath = "*", "*", "*", "*"
+	}
+	if rule.Resource != "" && rule.APIGroup == "" {
+		rule.APIGroup = "*"
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := b.now().UTC()
+	grant := &breakGlassGrant{
+		ID:            hex.EncodeToString(id),
+		Rule:          rule,
+		Justification: request.Justification,
+		GrantedBy:     caller,
+		Created:       now,
+		Expires:       now.Add(ttl),
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if err := b.write("grant", caller, grant); err != nil {
+		return nil, err
+	}
+	b.grants = append(b.grants, grant)
+
+	logrus.WithFields(logrus.Fields{
+		"id":            grant.ID,
+		"granted_by":    caller,
+		"grant":         (&policy{Spec: grant.Rule}).describe(),
+		"justification": grant.Justification,
+		"expires":       grant.Expires.Format(time.RFC3339),
+	}).Warn("break-glass access granted")
+
+	return grant, nil
+}
+
+// revoke removes the grant before it expires on behalf of the caller
+func (b *breakGlassStore) revoke(caller, id string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	grant := b.find(id)
+	if grant == nil {
+		return fmt.Errorf("no active grant %s", id)
+	}
+	if err := b.write("revoke", caller, grant); err != nil {
+		return err
+	}
+	b.remove(id)
+
+	logrus.WithFields(logrus.Fields{
+		"id":         id,
+		"revoked_by": caller,
+	}).Warn("break-glass access revoked")
+
+	return nil
+}
+
+// list returns the active grants, the soonest to expire first
+func (b *breakGlassStore) list() []*breakGlassGrant {
+	b.Lock()
+	defer b.Unlock()
+	b.expire(b.now())
+
+	list := make(breakGlassGrants, len(b.grants))
+	copy(list, b.grants)
+	sort.Stable(list)
+
+	return list
+}
+
+// Authorize checks the request against the unexpired grants
+func (b *breakGlassStore) Authorize(a authorizer.Attributes) (bool, string, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	now := b.now()
+	for _, x := range b.grants {
+		if !now.Before(x.Expires) {
+			continue
+		}
+		if (&policy{Spec: x.Rule}).matches(a, now) {
+			return true, fmt.Sprintf("grant %s, justification: %s, expires: %s",
+				x.ID, x.Justification, x.Expires.Format(time.RFC3339)), nil
+		}
+	}
+
+	return false, "", nil
+}
+
+// expire drops the expired grants, the lock must be held
+func (b *breakGlassStore) expire(now time.Time) {
+	var active []*breakGlassGrant
+	for _, x := range b.grants {
+		if now.Before(x.Expires) {
+			active = append(active, x)
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"id":            x.ID,
+			"justification": x.Justification,
+		}).Info("break-glass access expired")
+	}
+	b.grants = active
+}
+
+// find returns the grant or nil, the lock must be held
+func (b *breakGlassStore) find(id string) *breakGlassGrant {
+	for _, x := range b.grants {
+		if x.ID == id {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// remove drops the grant, the lock must be held
+func (b *breakGlassStore) remove(id string) {
+	for i, x := range b.grants {
+		if x.ID == id {
+			b.grants = append(b.grants[:i], b.grants[i+1:]...)
+			return
+		}
+	}
+}
+
+// write appends the change to the journal if configured
+func (b *breakGlassStore) write(action, actor string, grant *breakGlassGrant) error {
+	if b.journal == "" {
+		return nil
+	}
+	encoded, err := json.Marshal(&breakGlassEntry{Timestamp: b.now().UTC(), Action: action, Actor: actor, Grant: grant})
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(b.journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// compact rewrites the journal with a grant entry for each of the active grants; the file is
+// replaced atomically so a failure leaves the original in place
+func (b *breakGlassStore) compact() error {
+	if b.journal == "" {
+		return nil
+	}
+	temp := b.journal + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, x := range b.grants {
+		encoded, err := json.Marshal(&breakGlassEntry{Timestamp: x.Created, Action: "grant", Actor: x.GrantedBy, Grant: x})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if _, err := file.Write(append(encoded, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, b.journal)
+}
+
+// breakGlassGrants sorts the grants by expiry
+type breakGlassGrants []*breakGlassGrant
+
+func (g breakGlassGrants) Len() int           { return len(g) }
+func (g breakGlassGrants) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g breakGlassGrants) Less(i, j int) bool { return g[i].Expires.Before(g[j].Expires) }
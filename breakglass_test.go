@@ -0,0 +1,226 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakGlassGrantValidation(t *testing.T) {
+	b, err := newBreakGlassStore("", time.Hour, time.Now)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cs := []struct {
+		Request breakGlassRequest
+		Error   string
+	}{
+		{Request: breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "30m"}, Error: "a justification must be given"},
+		{Request: breakGlassRequest{Rule: policySpec{User: "jane"}, Justification: "INC-1"}, Error: "a ttl must be given"},
+		{Request: breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "soon", Justification: "INC-1"}, Error: "invalid ttl"},
+		{Request: breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "2h", Justification: "INC-1"}, Error: "exceeds the maximum"},
+		{Request: breakGlassRequest{TTL: "30m", Justification: "INC-1"}, Error: "a user or group must be given"},
+		{Request: breakGlassRequest{Rule: policySpec{Group: "*"}, TTL: "30m", Justification: "INC-1"}, Error: "all users or groups"},
+		{Request: breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "30m", Justification: "INC-1"}},
+	}
+	for i, c := range cs {
+		grant, err := b.add("admin", &c.Request)
+		if c.Error == "" {
+			if assert.NoError(t, err, "case %d", i) {
+				assert.Equal(t, policySpec{User: "jane", Namespace: "*", Resource: "*", APIGroup: "*", NonResourcePath: "*"}, grant.Rule)
+			}
+			continue
+		}
+		if assert.Error(t, err, "case %d", i) {
+			assert.Contains(t, err.Error(), c.Error, "case %d", i)
+		}
+	}
+}
+
+func TestBreakGlassExpiryAndJournal(t *testing.T) {
+	now := time.Date(2017, 3, 6, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	journal, err := writeTestFile("")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(journal.Name())
+
+	b, err := newBreakGlassStore(journal.Name(), 4*time.Hour, clock)
+	if !assert.NoError(t, err) {
+		return
+	}
+	short, err := b.add("admin", &breakGlassRequest{Rule: policySpec{Group: "on-call", Namespace: "prod", Resource: "pods"}, TTL: "1h", Justification: "INC-1"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	long, err := b.add("admin", &breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "3h", Justification: "INC-2"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	revoked, err := b.add("admin", &breakGlassRequest{Rule: policySpec{User: "bob"}, TTL: "3h", Justification: "INC-3"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, b.revoke("admin", revoked.ID))
+	assert.Error(t, b.revoke("admin", revoked.ID))
+
+	attrs := &authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: "john", Groups: []string{"on-call"}},
+		Verb:            "delete",
+		Namespace:       "prod",
+		Resource:        "pods",
+		ResourceRequest: true,
+	}
+	allowed, reason, err := b.Authorize(attrs)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Contains(t, reason, "grant "+short.ID+", justification: INC-1")
+
+	// @check the grants survive a restart, and expire
+	now = now.Add(2 * time.Hour)
+	restored, err := newBreakGlassStore(journal.Name(), 4*time.Hour, clock)
+	if !assert.NoError(t, err) {
+		return
+	}
+	allowed, _, _ = restored.Authorize(attrs)
+	assert.False(t, allowed)
+	if grants := restored.list(); assert.Len(t, grants, 1) {
+		assert.Equal(t, long.ID, grants[0].ID)
+	}
+	attrs.User = &user.DefaultInfo{Name: "bob"}
+	allowed, _, _ = restored.Authorize(attrs)
+	assert.False(t, allowed)
+
+	// @check the journal is compacted to the active grants, recording who gave them
+	content, err := ioutil.ReadFile(journal.Name())
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 1) {
+		entry := new(breakGlassEntry)
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), entry))
+		assert.Equal(t, "admin", entry.Actor)
+		assert.Equal(t, long.ID, entry.Grant.ID)
+	}
+}
+
+func TestBreakGlassEndpoints(t *testing.T) {
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		adminListen:          "127.0.0.1:8081",
+		adminTLSCert:         "does_not_exist",
+		adminTLSKey:          "does_not_exist",
+		adminTLSCA:           "does_not_exist",
+		breakGlass:           true,
+		breakGlassMaxTTL:     time.Hour,
+		breakGlassAdminGroup: "group3",
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+	admin := httptest.NewServer(s.s.admin)
+	defer admin.Close()
+
+	review := v1beta1.SubjectAccessReview{
+		Spec: v1beta1.SubjectAccessReviewSpec{
+			User:               "jane",
+			ResourceAttributes: &v1beta1.ResourceAttributes{Verb: "delete", Namespace: "kube-system", Resource: "pods"},
+		},
+	}
+	status, err := makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+
+	// step: the caller must be authenticated
+	request := &breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "10m", Justification: "INC-1"}
+	res, err := hc.R().SetBody(request).Post(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode())
+	res, err = hc.R().SetAuthToken("bad").SetBody(request).Post(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode())
+
+	// step: the caller must be in the admin group
+	res, err = hc.R().SetAuthToken("token1").SetBody(request).Post(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode())
+
+	// step: give the grant
+	grant := new(breakGlassGrant)
+	res, err = hc.R().SetAuthToken("token3").SetBody(&breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "10m", Justification: "INC-1"}).
+		SetResult(grant).Post(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode())
+	assert.Equal(t, "user3", grant.GrantedBy)
+	res, err = hc.R().SetAuthToken("token3").SetBody(&breakGlassRequest{Rule: policySpec{User: "jane"}, TTL: "10m"}).Post(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode())
+
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+	assert.Contains(t, status.Status.Reason, "break-glass: grant "+grant.ID)
+
+	res, err = hc.R().SetAuthToken("token1").Get(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode())
+	var grants []*breakGlassGrant
+	_, err = hc.R().SetAuthToken("token3").SetResult(&grants).Get(admin.URL + breakGlassPath)
+	assert.NoError(t, err)
+	assert.Len(t, grants, 1)
+
+	// step: revoke the grant
+	res, err = hc.R().Delete(admin.URL + breakGlassPath + "/" + grant.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode())
+	res, err = hc.R().SetAuthToken("token2").Delete(admin.URL + breakGlassPath + "/" + grant.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode())
+	res, err = hc.R().SetAuthToken("token3").Delete(admin.URL + breakGlassPath + "/" + grant.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode())
+	res, err = hc.R().SetAuthToken("token3").Delete(admin.URL + breakGlassPath + "/" + grant.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode())
+
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+}
+
+func TestBreakGlassAuditEvent(t *testing.T) {
+	attrs := &authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "jane"}, Verb: "get", Path: "/api"}
+	event := newAuditEvent(attrs, &authorizationResult{decision: decisionAllow, source: breakGlassSource})
+	assert.True(t, event.BreakGlass)
+	event = newAuditEvent(attrs, &authorizationResult{decision: decisionAllow, source: "policy"})
+	assert.False(t, event.BreakGlass)
+}
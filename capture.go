@@ -0,0 +1,231 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	auth "k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+	authz "k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+)
+
+// capturedReview is a review request and the response captured for replay
+type capturedReview struct {
+	// Timestamp is the time of the review
+	Timestamp time.Time `json:"timestamp"`
+	// Kind is the kind of review, token or policy
+	Kind string `json:"kind"`
+	// Request is the review request, any token is replaced with a hash
+	Request json.RawMessage `json:"request"`
+	// Response is the review response
+	Response json.RawMessage `json:"response"`
+}
+
+// replayDifference is a decision which differs from the capture
+type replayDifference struct {
+	// Line is the line of the capture file
+	Line int
+	// Kind is the kind of review
+	Kind string
+	// Subject describes the review
+	Subject string
+	// Captured is the decision in the capture
+	Captured string
+	// Replayed is the decision on replay
+	Replayed string
+}
+
+// String returns a description of the difference
+func (r *replayDifference) String() string {
+	return fmt.Sprintf("line %d: %s %s: captured %s, replayed %s", r.Line, r.Kind, r.Subject, r.Captured, r.Replayed)
+}
+
+// hashToken returns the stable hash a token is replaced with in the capture
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newCapturedReview creates a capture of the review, the token is never written to the capture
+func newCapturedReview(kind string, request, response interface{}) (*capturedReview, error) {
+	if review, ok := request.(*auth.TokenReview); ok {
+		hashed := *review
+		hashed.Spec.Token = hashToken(review.Spec.Token)
+		request = &hashed
+	}
+	encodedRequest, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	encodedResponse, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &capturedReview{
+		Timestamp: time.Now().UTC(),
+		Kind:      kind,
+		Request:   encodedRequest,
+		Response:  encodedResponse,
+	}, nil
+}
+
+// newReplayService creates a service for replaying captures; the tokens are keyed on their hash
+// as the capture never contains the tokens themselves
+func newReplayService(o options) (*service, error) {
+	s := &service{
+		cfg:     &o,
+		files:   make(map[string][16]byte, 0),
+		metrics: newServiceMetrics(),
+		diffs:   newPolicyDiffHistory(0),
+	}
+	sources, err := newAuthorizerSources(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadSources(sources); err != nil {
+		return nil, err
+	}
+	if s.tokens != nil {
+		s.tokens = s.tokens.hashed()
+	}
+
+	return s, nil
+}
+
+// replay reruns the reviews in the capture file, returning the number of reviews and any
+// decisions which differ
+func (s *service) replay(filename string) (int, []*replayDifference, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	var count int
+	var diffs []*replayDifference
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		captured := new(capturedReview)
+		if err := json.Unmarshal(scanner.Bytes(), captured); err != nil {
+			return count, diffs, fmt.Errorf("error reading capture file %s, line %d: %s", filename, line, err)
+		}
+		diff, err := s.replayReview(captured)
+		if err != nil {
+			return count, diffs, fmt.Errorf("error replaying capture file %s, line %d: %s", filename, line, err)
+		}
+		count++
+		if diff != nil {
+			diff.Line = line
+			diffs = append(diffs, diff)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, diffs, err
+	}
+
+	return count, diffs, nil
+}
+
+// replayReview reruns a single review, returning the difference if the decision changed
+func (s *service) replayReview(captured *capturedReview) (*replayDifference, error) {
+	var subject, before, after string
+	switch captured.Kind {
+	case tokenReviewKind:
+		request, response := new(auth.TokenReview), new(auth.TokenReview)
+		if err := json.Unmarshal(captured.Request, request); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(captured.Response, response); err != nil {
+			return nil, err
+		}
+		result, err := s.authentication(request)
+		if err != nil {
+			return nil, err
+		}
+		subject = request.Spec.Token
+		if len(subject) > 19 {
+			subject = subject[:19]
+		}
+		before, after = describeTokenDecision(response.Status), describeTokenDecision(result.Status)
+	case accessReviewKind:
+		request, response := new(authz.SubjectAccessReview), new(authz.SubjectAccessReview)
+		if err := json.Unmarshal(captured.Request, request); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(captured.Response, response); err != nil {
+			return nil, err
+		}
+		result, err := s.authorize(request)
+		if err != nil {
+			return nil, err
+		}
+		subject = describeAccessReview(request)
+		before, after = describeAccessDecision(response.Status), describeAccessDecision(result.Status)
+	default:
+		return nil, fmt.Errorf("unknown review kind: %s", captured.Kind)
+	}
+	if before == after {
+		return nil, nil
+	}
+
+	return &replayDifference{Kind: captured.Kind, Subject: subject, Captured: before, Replayed: after}, nil
+}
+
+// describeTokenDecision returns a description of the token review decision
+func describeTokenDecision(status auth.TokenReviewStatus) string {
+	if !status.Authenticated {
+		return "unauthenticated"
+	}
+
+	return fmt.Sprintf("authenticated as %s (uid: %s, groups: %s)", status.User.Username, status.User.UID,
+		strings.Join(status.User.Groups, ","))
+}
+
+// describeAccessDecision returns a description of the access review decision
+func describeAccessDecision(status authz.SubjectAccessReviewStatus) string {
+	if status.Allowed {
+		return "allowed"
+	}
+
+	return "denied"
+}
+
+// describeAccessReview returns a description of the request
+func describeAccessReview(review *authz.SubjectAccessReview) string {
+	if r := review.Spec.ResourceAttributes; r != nil {
+		return fmt.Sprintf("%s %s %s/%s in namespace %q", review.Spec.User, r.Verb, r.Group, r.Resource, r.Namespace)
+	}
+	if r := review.Spec.NonResourceAttributes; r != nil {
+		return fmt.Sprintf("%s %s %s", review.Spec.User, r.Verb, r.Path)
+	}
+
+	return review.Spec.User
+}
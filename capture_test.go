@@ -0,0 +1,108 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	auth "k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+	authz "k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	capture, err := ioutil.TempFile("/tmp", "kube-auth.capture.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("unable to create the capture file, error: %s", err)
+	}
+	defer os.Remove(capture.Name())
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{captureFile: capture.Name()})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	for _, token := range []string{"token1", "token3", "not_there"} {
+		_, err := makeTestAuthRequest(s.URL(), auth.TokenReview{Spec: auth.TokenReviewSpec{Token: token}})
+		assert.NoError(t, err)
+	}
+	for _, namespace := range []string{"adm", "adm-dev", "prod"} {
+		_, err := makeTestAuthzRequest(s.URL(), authz.SubjectAccessReview{
+			Spec: authz.SubjectAccessReviewSpec{
+				User:               "user1",
+				ResourceAttributes: &authz.ResourceAttributes{Resource: "pods", Namespace: namespace, Verb: "get"},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	content, err := ioutil.ReadFile(capture.Name())
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), 6)
+	assert.NotContains(t, string(content), `"token1"`)
+	assert.Contains(t, string(content), hashToken("token1"))
+
+	// step: replaying against the same configuration has no differences
+	replay, err := newReplayService(options{tokenFile: s.s.cfg.tokenFile, authFile: s.s.cfg.authFile})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	count, diffs, err := replay.replay(capture.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+	assert.Empty(t, diffs)
+
+	// step: replay against a candidate which renames a user and drops a rule
+	tokens, err := writeTestFile("token1,user1,uuid1\ntoken3,renamed,uuid3,group3\n")
+	if err != nil {
+		t.Fatalf("unable to write the tokens, error: %s", err)
+	}
+	defer os.Remove(tokens.Name())
+	policy, err := writeTestFile(`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"user1","namespace":"adm","resource":"*","apiGroup":"*"}}`)
+	if err != nil {
+		t.Fatalf("unable to write the policy, error: %s", err)
+	}
+	defer os.Remove(policy.Name())
+
+	replay, err = newReplayService(options{tokenFile: tokens.Name(), authFile: policy.Name()})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	count, diffs, err = replay.replay(capture.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+	if assert.Len(t, diffs, 2) {
+		assert.Equal(t, 2, diffs[0].Line)
+		assert.Equal(t, "token", diffs[0].Kind)
+		assert.Equal(t, "authenticated as user3 (uid: uuid3, groups: group3)", diffs[0].Captured)
+		assert.Equal(t, "authenticated as renamed (uid: uuid3, groups: group3)", diffs[0].Replayed)
+		assert.Equal(t, 5, diffs[1].Line)
+		assert.Equal(t, "policy", diffs[1].Kind)
+		assert.Equal(t, `user1 get /pods in namespace "adm-dev"`, diffs[1].Subject)
+		assert.Equal(t, "allowed", diffs[1].Captured)
+		assert.Equal(t, "denied", diffs[1].Replayed)
+	}
+
+	_, _, err = replay.replay("/does_not_exist")
+	assert.Error(t, err)
+}
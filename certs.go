@@ -0,0 +1,230 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// certificateValidity is the default validity period of the generated certificates
+const certificateValidity = 365 * 24 * time.Hour
+
+// certificateHosts returns the hosts for a serving certificate, defaulting to the local host
+func certificateHosts(hosts []string) []string {
+	if len(hosts) == 0 {
+		return []string{"localhost", "127.0.0.1"}
+	}
+
+	return hosts
+}
+
+// certificateFiles are the locations of the generated certificates and keys
+type certificateFiles struct {
+	caCert     string
+	caKey      string
+	serverCert string
+	serverKey  string
+	clientCert string
+	clientKey  string
+}
+
+// defaultCertificateFiles returns the standard filenames within the directory
+func defaultCertificateFiles(dir string) certificateFiles {
+	return certificateFiles{
+		caCert:     filepath.Join(dir, "ca.pem"),
+		caKey:      filepath.Join(dir, "ca-key.pem"),
+		serverCert: filepath.Join(dir, "server.pem"),
+		serverKey:  filepath.Join(dir, "server-key.pem"),
+		clientCert: filepath.Join(dir, "client.pem"),
+		clientKey:  filepath.Join(dir, "client-key.pem"),
+	}
+}
+
+// list returns all the files
+func (c certificateFiles) list() []string {
+	return []string{c.caCert, c.caKey, c.serverCert, c.serverKey, c.clientCert, c.clientKey}
+}
+
+// certificateKeyPair is a certificate and the private key
+type certificateKeyPair struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// generateCertificates creates a certificate authority, a serving certificate for the hosts and
+// a client certificate for the apiserver; existing files are only replaced if overwrite is set
+func generateCertificates(files certificateFiles, hosts []string, validity time.Duration, overwrite bool) error {
+	if !overwrite {
+		for _, x := range files.list() {
+			if _, err := os.Stat(x); err == nil {
+				return fmt.Errorf("the file %s already exists", x)
+			}
+		}
+	}
+
+	ca, err := newCertificate(nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "kube-auth-ca"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, validity)
+	if err != nil {
+		return err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "kube-auth"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, x := range hosts {
+		if ip := net.ParseIP(x); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+			continue
+		}
+		server.DNSNames = append(server.DNSNames, x)
+	}
+	serving, err := newCertificate(ca, server, validity)
+	if err != nil {
+		return err
+	}
+
+	client, err := newCertificate(ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "kube-apiserver"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, validity)
+	if err != nil {
+		return err
+	}
+
+	for _, x := range []struct {
+		pair      *certificateKeyPair
+		cert, key string
+	}{
+		{pair: ca, cert: files.caCert, key: files.caKey},
+		{pair: serving, cert: files.serverCert, key: files.serverKey},
+		{pair: client, cert: files.clientCert, key: files.clientKey},
+	} {
+		if err := writeCertificateKeyPair(x.pair, x.cert, x.key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newCertificate creates a certificate from the template, self signed if no signer is given
+func newCertificate(signer *certificateKeyPair, template *x509.Certificate, validity time.Duration) (*certificateKeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-5 * time.Minute).UTC()
+	template.NotAfter = time.Now().Add(validity).UTC()
+
+	parent, parentKey := template, key
+	if signer != nil {
+		parent, parentKey = signer.cert, signer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificateKeyPair{cert: cert, der: der, key: key}, nil
+}
+
+// writeCertificateKeyPair writes the certificate and private key, the key is only readable by the owner
+func writeCertificateKeyPair(pair *certificateKeyPair, certFile, keyFile string) error {
+	encoded, err := x509.MarshalECPrivateKey(pair.key)
+	if err != nil {
+		return err
+	}
+	for _, x := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(x), 0755); err != nil {
+			return err
+		}
+	}
+	if err := writeFileWithMode(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encoded}), 0600); err != nil {
+		return err
+	}
+
+	return writeFileWithMode(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.der}), 0644)
+}
+
+// writeFileWithMode writes the file, ensuring the permissions even if the file already exists
+func writeFileWithMode(filename string, content []byte, mode os.FileMode) error {
+	if err := ioutil.WriteFile(filename, content, mode); err != nil {
+		return err
+	}
+
+	return os.Chmod(filename, mode)
+}
+
+// ensureCertificates generates the serving certificates on first start if they don't exist; the
+// certificate authority and apiserver client certificate are written alongside. The authority is
+// never written to the --tls-ca, client certificates are only required if the operator asks for them
+func (s *service) ensureCertificates() error {
+	_, certErr := os.Stat(s.cfg.tlsCert)
+	_, keyErr := os.Stat(s.cfg.tlsKey)
+	switch {
+	case certErr == nil && keyErr == nil:
+		return nil
+	case certErr == nil || keyErr == nil:
+		return fmt.Errorf("only one of the tls certificate and key exist, refusing to generate")
+	}
+
+	files := defaultCertificateFiles(filepath.Dir(s.cfg.tlsCert))
+	files.serverCert, files.serverKey = s.cfg.tlsCert, s.cfg.tlsKey
+	if err := generateCertificates(files, s.cfg.tlsHosts, s.cfg.tlsValidity, false); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"ca":          files.caCert,
+		"certificate": files.serverCert,
+		"client":      files.clientCert,
+	}).Info("generated the serving certificates, use --tls-ca to require the client certificate")
+
+	return nil
+}
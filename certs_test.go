@@ -0,0 +1,116 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-auth-certs")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	files := defaultCertificateFiles(dir)
+	if !assert.NoError(t, generateCertificates(files, []string{"kube-auth.kube-system", "10.0.0.1"}, time.Hour, false)) {
+		return
+	}
+	for _, x := range []string{files.caKey, files.serverKey, files.clientKey} {
+		info, err := os.Stat(x)
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "key %s", x)
+		}
+	}
+
+	content, err := ioutil.ReadFile(files.caCert)
+	if !assert.NoError(t, err) {
+		return
+	}
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM(content))
+
+	server, err := tls.LoadX509KeyPair(files.serverCert, files.serverKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cert, err := x509.ParseCertificate(server.Certificate[0])
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"kube-auth.kube-system"}, cert.DNSNames)
+	assert.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "10.0.0.1", Roots: pool})
+	assert.NoError(t, err)
+
+	client, err := tls.LoadX509KeyPair(files.clientCert, files.clientKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cert, err = x509.ParseCertificate(client.Certificate[0])
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+
+	// @check we refuse to overwrite unless forced
+	assert.Error(t, generateCertificates(files, nil, time.Hour, false))
+	assert.NoError(t, generateCertificates(files, nil, time.Hour, true))
+}
+
+func TestEnsureCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-auth-certs")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	s := &service{cfg: &options{
+		tlsCert:     filepath.Join(dir, "tls.pem"),
+		tlsKey:      filepath.Join(dir, "tls-key.pem"),
+		tlsCA:       filepath.Join(dir, "apiserver-ca.pem"),
+		tlsHosts:    certificateHosts(nil),
+		tlsValidity: time.Hour,
+	}}
+	if !assert.NoError(t, s.ensureCertificates()) {
+		return
+	}
+	for _, x := range []string{s.cfg.tlsCert, s.cfg.tlsKey, filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem")} {
+		_, err := os.Stat(x)
+		assert.NoError(t, err, "file %s", x)
+	}
+	// @check the client-auth authority is never overwritten by the generated one
+	_, err = os.Stat(s.cfg.tlsCA)
+	assert.True(t, os.IsNotExist(err))
+	// @check existing certificates are left alone
+	assert.NoError(t, s.ensureCertificates())
+
+	// @check a missing key is an error rather than a silent replacement
+	assert.NoError(t, os.Remove(s.cfg.tlsKey))
+	assert.Error(t, s.ensureCertificates())
+}
@@ -0,0 +1,557 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// newPolicyCommand returns the policy tooling commands
+func newPolicyCommand() cli.Command {
+	return cli.Command{
+		Name:  "policy",
+		Usage: "offline tooling for the authorization policy",
+		Subcommands: []cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "show the rules and access which differ between two policy files or directories",
+				ArgsUsage: "OLD NEW",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the diff as json",
+					},
+					cli.BoolFlag{
+						Name:  "recursive",
+						Usage: "read the policy files in subdirectories as well",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					if cx.NArg() != 2 {
+						errorMessage("you must specify the old and new policy")
+					}
+					diff, err := diffPolicyPaths(cx.Args().Get(0), cx.Args().Get(1), cx.Bool("recursive"))
+					if err != nil {
+						errorMessage(err.Error())
+					}
+					if cx.Bool("json") {
+						encoded, err := json.MarshalIndent(diff, "", "  ")
+						if err != nil {
+							errorMessage(err.Error())
+						}
+						fmt.Fprintf(os.Stdout, "%s\n", encoded)
+						return nil
+					}
+					fmt.Fprint(os.Stdout, diff.String())
+
+					return nil
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "convert the policy into kubernetes rbac roles and bindings, verifying the two agree",
+				ArgsUsage: "POLICY",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "format",
+						Usage: "the format to export the policy as, only rbac is supported",
+						Value: "rbac",
+					},
+					cli.StringFlag{
+						Name:  "name-prefix",
+						Usage: "the prefix of the generated role and binding names",
+						Value: rbacExportPrefix,
+					},
+					cli.BoolFlag{
+						Name:  "recursive",
+						Usage: "read the policy files in subdirectories as well",
+					},
+					cli.IntFlag{
+						Name:  "samples",
+						Usage: "the maximum number of generated requests used to verify the export",
+						Value: 50000,
+					},
+					cli.BoolFlag{
+						Name:  "strict",
+						Usage: "exit with an error if any rule can't be expressed or the verification finds differences",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					if cx.NArg() != 1 {
+						errorMessage("you must specify the policy file or directory")
+					}
+					if cx.String("format") != "rbac" {
+						errorMessage(fmt.Sprintf("unsupported format: %s", cx.String("format")))
+					}
+					rules, err := loadPolicyPath(cx.Args().First(), cx.Bool("recursive"))
+					if err != nil {
+						errorMessage(err.Error())
+					}
+					export := exportRBAC(rules, cx.String("name-prefix"))
+					encoded, err := export.YAML()
+					if err != nil {
+						errorMessage(err.Error())
+					}
+					fmt.Fprintf(os.Stdout, "%s", encoded)
+
+					for _, x := range export.Problems {
+						fmt.Fprintf(os.Stderr, "[warning] %s\n", x)
+					}
+					checked, differences, err := verifyRBACExport(rules, export.Objects, cx.Int("samples"))
+					if err != nil {
+						errorMessage(err.Error())
+					}
+					for i, x := range differences {
+						if i == maxRBACDifferences {
+							fmt.Fprintf(os.Stderr, "[difference] ... and %d more\n", len(differences)-i)
+							break
+						}
+						fmt.Fprintf(os.Stderr, "[difference] %s\n", x)
+					}
+					fmt.Fprintf(os.Stderr, "verified %d requests, %d differences\n", checked, len(differences))
+					if cx.Bool("strict") && (len(export.Problems) > 0 || len(differences) > 0) {
+						os.Exit(1)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// newReplayCommand returns the command to replay a capture against a candidate configuration
+func newReplayCommand() cli.Command {
+	return cli.Command{
+		Name:      "replay",
+		Usage:     "rerun the captured reviews against a candidate token file and policy, reporting every decision which differs",
+		ArgsUsage: "CAPTURE",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "token-file",
+				Usage: "the path to the candidate file containing the tokens",
+			},
+			cli.StringSliceFlag{
+				Name:  "token-files",
+				Usage: "an additional candidate token file in the format path[?group=name&prefix=value], can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "auth-policy",
+				Usage: "the path to the candidate auth policy",
+			},
+			cli.StringFlag{
+				Name:  "auth-policy-dir",
+				Usage: "the path to a candidate directory of policy files",
+			},
+			cli.BoolFlag{
+				Name:  "auth-policy-dir-recursive",
+				Usage: "read the policy files in subdirectories of the policy directory as well",
+			},
+			cli.StringSliceFlag{
+				Name:  "authorizer",
+				Usage: "an additional candidate authorization source in the format kind:path, can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "identity-mapping",
+				Usage: "the path to the candidate identity mapping rules",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			if cx.NArg() != 1 {
+				errorMessage("you must specify the capture file")
+			}
+			s, err := newReplayService(options{
+				tokenFile:        cx.String("token-file"),
+				tokenFiles:       cx.StringSlice("token-files"),
+				authFile:         cx.String("auth-policy"),
+				authDir:          cx.String("auth-policy-dir"),
+				authDirRecursive: cx.Bool("auth-policy-dir-recursive"),
+				authorizers:      cx.StringSlice("authorizer"),
+				mappingFile:      cx.String("identity-mapping"),
+			})
+			if err != nil {
+				errorMessage(fmt.Sprintf("unable to load the candidate, error: %s", err))
+			}
+			count, diffs, err := s.replay(cx.Args().First())
+			if err != nil {
+				errorMessage(err.Error())
+			}
+			for _, x := range diffs {
+				fmt.Fprintln(os.Stdout, x.String())
+			}
+			fmt.Fprintf(os.Stdout, "replayed %d reviews, %d differ\n", count, len(diffs))
+			if len(diffs) > 0 {
+				os.Exit(1)
+			}
+
+			return nil
+		},
+	}
+}
+
+// newWebhookConfigCommand returns the command to generate the apiserver webhook kubeconfigs
+func newWebhookConfigCommand(opts *options) cli.Command {
+	return cli.Command{
+		Name:  "webhook-config",
+		Usage: "generate the kubeconfig files for the apiserver webhooks from the service options, i.e. kube-auth [options] webhook-config",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "server",
+				Usage: "the url the apiserver uses to reach the service, defaults to the --listen address of the service",
+			},
+			cli.StringFlag{
+				Name:  "server-ca",
+				Usage: "the path to the CA certificate which signed the service certificate, for the apiserver to verify the service",
+			},
+			cli.StringFlag{
+				Name:  "client-cert",
+				Usage: "the path to the client certificate the apiserver presents, signed by the --tls-ca of the service",
+			},
+			cli.StringFlag{
+				Name:  "client-key",
+				Usage: "the path to the private key of the client certificate",
+			},
+			cli.BoolFlag{
+				Name:  "embed",
+				Usage: "embed the certificates in the kubeconfig rather than referencing the files",
+			},
+			cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "the directory to write the " + authenticationWebhookFile + " and " + authorizationWebhookFile + " files",
+				Value: ".",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			// step: apply the configuration file and environment, as the service would
+			if err := resolveOptions(cx, opts); err != nil {
+				errorMessage(err.Error())
+			}
+			if opts.tlsCA != "" && cx.String("client-cert") == "" {
+				fmt.Fprintf(os.Stderr, "[warning] the service requires client certificates signed by %s, specify the --client-cert and --client-key\n", opts.tlsCA)
+			}
+			configs, err := newWebhookConfigs(webhookConfigOptions{
+				listen:     opts.listen,
+				server:     cx.String("server"),
+				serverCA:   cx.String("server-ca"),
+				clientCert: cx.String("client-cert"),
+				clientKey:  cx.String("client-key"),
+				embed:      cx.Bool("embed"),
+			})
+			if err != nil {
+				errorMessage(err.Error())
+			}
+			for _, name := range []string{authenticationWebhookFile, authorizationWebhookFile} {
+				filename := filepath.Join(cx.String("output-dir"), name)
+				// @note: the config may contain an embedded private key
+				if err := ioutil.WriteFile(filename, configs[name], 0600); err != nil {
+					errorMessage(err.Error())
+				}
+				fmt.Fprintf(os.Stdout, "wrote %s\n", filename)
+			}
+
+			return nil
+		},
+	}
+}
+
+// newCertsCommand returns the certificate tooling commands
+func newCertsCommand() cli.Command {
+	return cli.Command{
+		Name:  "certs",
+		Usage: "bootstrap the certificates for the service",
+		Subcommands: []cli.Command{
+			{
+				Name:  "generate",
+				Usage: "generate a CA, a serving certificate and a client certificate for the apiserver",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "output-dir",
+						Usage: "the directory to write the certificates and keys",
+						Value: ".",
+					},
+					cli.StringSliceFlag{
+						Name:  "host",
+						Usage: "a hostname or ip address of the serving certificate, can be repeated, defaults to localhost and 127.0.0.1",
+					},
+					cli.DurationFlag{
+						Name:  "validity",
+						Usage: "the validity period of the certificates",
+						Value: certificateValidity,
+					},
+					cli.BoolFlag{
+						Name:  "force",
+						Usage: "overwrite any existing certificates",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					files := defaultCertificateFiles(cx.String("output-dir"))
+					if err := generateCertificates(files, certificateHosts(cx.StringSlice("host")), cx.Duration("validity"), cx.Bool("force")); err != nil {
+						errorMessage(err.Error())
+					}
+					for _, x := range files.list() {
+						fmt.Fprintf(os.Stdout, "wrote %s\n", x)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// newConfigCommand returns the configuration commands, these use the options of the service
+func newConfigCommand(opts *options) cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "check the configuration of the service",
+		Subcommands: []cli.Command{
+			{
+				Name:  "validate",
+				Usage: "validate the service options along with the files they reference, i.e. kube-auth [options] config validate",
+				Action: func(cx *cli.Context) error {
+					if err := resolveOptions(cx, opts); err != nil {
+						errorMessage(err.Error())
+					}
+					problems := validateOptions(opts)
+					for _, x := range problems {
+						fmt.Fprintf(os.Stderr, "[error] %s\n", x)
+					}
+					if len(problems) > 0 {
+						os.Exit(1)
+					}
+					fmt.Fprintln(os.Stdout, "the configuration is valid")
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// newBreakGlassCommand returns the commands to manage the break-glass grants of a running service
+func newBreakGlassCommand() cli.Command {
+	return cli.Command{
+		Name:  "break-glass",
+		Usage: "give, list and revoke temporary break-glass grants on a running service",
+		Subcommands: []cli.Command{
+			{
+				Name:  "grant",
+				Usage: "give a user or group temporary access, by default to everything",
+				Flags: append(breakGlassClientFlags(),
+					cli.StringFlag{Name: "user", Usage: "the user given the access"},
+					cli.StringFlag{Name: "group", Usage: "the group given the access"},
+					cli.StringFlag{Name: "namespace", Usage: "limit the access to the namespace, * for all"},
+					cli.StringFlag{Name: "resource", Usage: "limit the access to the resource, * for all"},
+					cli.StringFlag{Name: "api-group", Usage: "limit the access to the api group, * for all"},
+					cli.StringFlag{Name: "path", Usage: "limit the access to the non resource path, * for all"},
+					cli.BoolFlag{Name: "readonly", Usage: "limit the access to get, list and watch"},
+					cli.DurationFlag{Name: "ttl", Usage: "the lifetime of the grant, required"},
+					cli.StringFlag{Name: "justification", Usage: "the reason for the grant i.e. the incident, required"},
+				),
+				Action: func(cx *cli.Context) error {
+					request := &breakGlassRequest{
+						Rule: policySpec{
+							User:            cx.String("user"),
+							Group:           cx.String("group"),
+							Namespace:       cx.String("namespace"),
+							Resource:        cx.String("resource"),
+							APIGroup:        cx.String("api-group"),
+							NonResourcePath: cx.String("path"),
+							Readonly:        cx.Bool("readonly"),
+						},
+						Justification: cx.String("justification"),
+					}
+					if cx.Duration("ttl") > 0 {
+						request.TTL = cx.Duration("ttl").String()
+					}
+					grant := new(breakGlassGrant)
+					if err := breakGlassCall(cx, "POST", "", request, grant); err != nil {
+						errorMessage(err.Error())
+					}
+					fmt.Fprintf(os.Stdout, "granted %s, expires %s\n", grant.ID, grant.Expires.Format(time.RFC3339))
+
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "list the active grants",
+				Flags: breakGlassClientFlags(),
+				Action: func(cx *cli.Context) error {
+					var grants []*breakGlassGrant
+					if err := breakGlassCall(cx, "GET", "", nil, &grants); err != nil {
+						errorMessage(err.Error())
+					}
+					for _, x := range grants {
+						fmt.Fprintf(os.Stdout, "%s expires %s %s (%s)\n", x.ID, x.Expires.Format(time.RFC3339),
+							(&policy{Spec: x.Rule}).describe(), x.Justification)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "revoke",
+				Usage:     "revoke a grant before it expires",
+				ArgsUsage: "ID",
+				Flags:     breakGlassClientFlags(),
+				Action: func(cx *cli.Context) error {
+					if cx.NArg() != 1 {
+						errorMessage("the id of the grant must be given")
+					}
+					if err := breakGlassCall(cx, "DELETE", "/"+cx.Args().First(), nil, nil); err != nil {
+						errorMessage(err.Error())
+					}
+					fmt.Fprintf(os.Stdout, "revoked %s\n", cx.Args().First())
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// breakGlassClientFlags returns the options to connect to the admin listener
+func breakGlassClientFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "server",
+			Usage: "the url of the admin listener of the service",
+			Value: "https://127.0.0.1:8081",
+		},
+		cli.StringFlag{
+			Name:  "token",
+			Usage: "the bearer token identifying you to the service, recorded against the changes",
+		},
+		cli.StringFlag{
+			Name:  "tls-ca",
+			Usage: "the certificate authority to verify the admin listener",
+		},
+		cli.StringFlag{
+			Name:  "client-cert",
+			Usage: "the client certificate to present to the admin listener",
+		},
+		cli.StringFlag{
+			Name:  "client-key",
+			Usage: "the private key of the client certificate",
+		},
+	}
+}
+
+// breakGlassCall makes the request to the break-glass endpoint, decoding the response if required
+func breakGlassCall(cx *cli.Context, method, path string, request, response interface{}) error {
+	client, err := newAdminClient(cx.String("tls-ca"), cx.String("client-cert"), cx.String("client-key"))
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if request != nil {
+		encoded, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(cx.String("server"), "/")+breakGlassPath+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := cx.String("token"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		failure := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(content, &failure) != nil || failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return fmt.Errorf("the request failed, %s", failure.Error)
+	}
+	if response == nil {
+		return nil
+	}
+
+	return json.Unmarshal(content, response)
+}
+
+// newAdminClient creates a http client for the admin listener, verifying the server and
+// presenting a client certificate if required
+func newAdminClient(ca, cert, key string) (*http.Client, error) {
+	config := &tls.Config{}
+	if ca != "" {
+		content, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: config},
+	}, nil
+}
+
+// diffPolicyPaths loads the old and new policy and computes the differences
+func diffPolicyPaths(old, updated string, recursive bool) (*policyDiff, error) {
+	before, err := loadPolicyPath(old, recursive)
+	if err != nil {
+		return nil, err
+	}
+	after, err := loadPolicyPath(updated, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffPolicies(before, after), nil
+}
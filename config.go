@@ -0,0 +1,356 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// configAPIVersion is the version of the configuration file format
+	configAPIVersion = "kube-auth/v1"
+	// configKind is the kind of the configuration file
+	configKind = "Configuration"
+	// envVarPrefix is the prefix of the environment variables for the options
+	envVarPrefix = "KUBE_AUTH_"
+)
+
+// configOption maps a key in the configuration file to a command line option
+type configOption struct {
+	// key is the dotted path of the option in the file
+	key string
+	// flag is the name of the command line option
+	flag string
+	// list indicates the option accepts multiple values
+	list bool
+	// reload parses a change and returns the method which applies it while running, options
+	// without are only read on start
+	reload func([]string) (func(*options), error)
+}
+
+// configValues are the values from the configuration file keyed by the option key
+type configValues map[string][]string
+
+// flagSetter sets the value of a command line option
+type flagSetter interface {
+	Set(string, string) error
+}
+
+// configOptions are the options which can be set in the configuration file
+var configOptions = []*configOption{
+	{key: "listen.address", flag: "listen"},
+	{key: "listen.socketMode", flag: "listen-socket-mode"},
+	{key: "listen.admin", flag: "admin-listen"},
+	{key: "listen.shutdownDelay", flag: "shutdown-delay"},
+	{key: "tls.cert", flag: "tls-cert"},
+	{key: "tls.key", flag: "tls-key"},
+	{key: "tls.ca", flag: "tls-ca"},
+	{key: "tls.autoGenerate", flag: "tls-auto-generate"},
+	{key: "tls.hosts", flag: "tls-host", list: true},
+	{key: "tls.validity", flag: "tls-validity"},
+	{key: "tls.admin.cert", flag: "admin-tls-cert"},
+	{key: "tls.admin.key", flag: "admin-tls-key"},
+	{key: "tls.admin.ca", flag: "admin-tls-ca"},
+	{key: "authentication.tokenFile", flag: "token-file"},
+	{key: "authentication.tokenFiles", flag: "token-files", list: true},
+	{key: "authentication.identityMapping", flag: "identity-mapping"},
+	{key: "authentication.webhook.config", flag: "token-webhook-config"},
+	{key: "authentication.webhook.cacheTTL", flag: "token-webhook-cache-ttl"},
+	{key: "authentication.webhook.negativeCacheTTL", flag: "token-webhook-negative-cache-ttl"},
+	{key: "authentication.jwt.publicKeys", flag: "jwt-public-keys"},
+	{key: "authentication.jwt.issuer", flag: "jwt-issuer"},
+	{key: "authentication.jwt.audience", flag: "jwt-audience"},
+	{key: "authentication.jwt.usernameClaim", flag: "jwt-username-claim"},
+	{key: "authentication.jwt.groupsClaims", flag: "jwt-groups-claim", list: true},
+	{key: "authentication.jwt.extraClaims", flag: "jwt-extra-claim", list: true},
+	{key: "authorization.policy", flag: "auth-policy"},
+	{key: "authorization.policyDir", flag: "auth-policy-dir"},
+	{key: "authorization.policyDirRecursive", flag: "auth-policy-dir-recursive"},
+	{key: "authorization.authorizers", flag: "authorizer", list: true},
+	{key: "authorization.shadowPolicy", flag: "shadow-policy"},
+	{key: "authorization.policyDiffHistory", flag: "policy-diff-history"},
+	{key: "authorization.explainDecisions", flag: "explain-decisions", reload: reloadBool(func(o *options, v bool) {
+		o.explainDecisions = v
+	})},
+	{key: "authorization.breakGlass.enabled", flag: "break-glass"},
+	{key: "authorization.breakGlass.journal", flag: "break-glass-journal"},
+	{key: "authorization.breakGlass.maxTTL", flag: "break-glass-max-ttl"},
+	{key: "authorization.breakGlass.adminGroup", flag: "break-glass-admin-group"},
+	{key: "authorization.delegation.policy", flag: "delegation-policy"},
+	{key: "authorization.delegation.grants", flag: "delegation-grants"},
+	{key: "authorization.webhook.config", flag: "authorization-webhook-config"},
+	{key: "authorization.webhook.authorizedCacheTTL", flag: "authorization-webhook-cache-authorized-ttl"},
+	{key: "authorization.webhook.unauthorizedCacheTTL", flag: "authorization-webhook-cache-unauthorized-ttl"},
+	{key: "webhooks.timeout", flag: "webhook-timeout"},
+	{key: "webhooks.retries", flag: "webhook-retries"},
+	{key: "audit.log", flag: "audit-log"},
+	{key: "audit.captureFile", flag: "capture-file"},
+	{key: "logging.enabled", flag: "disable-logging"},
+	{key: "logging.verbose", flag: "verbose", reload: reloadBool(func(o *options, v bool) {
+		o.verbose = v
+		logrus.SetLevel(logrus.InfoLevel)
+		if v {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+	})},
+}
+
+// reloadBool returns a reload method for a boolean option, a removed value is false
+func reloadBool(set func(*options, bool)) func([]string) (func(*options), error) {
+	return func(values []string) (func(*options), error) {
+		var v bool
+		if len(values) > 0 {
+			parsed, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, err
+			}
+			v = parsed
+		}
+
+		return func(o *options) { set(o, v) }, nil
+	}
+}
+
+// findConfigOption returns the option for the key, or nil
+func findConfigOption(key string) *configOption {
+	for _, x := range configOptions {
+		if x.key == key {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// isConfigSection checks if the key is the parent of any options
+func isConfigSection(key string) bool {
+	for _, x := range configOptions {
+		if strings.HasPrefix(x.key, key+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// envVarName returns the environment variable for a command line option
+func envVarName(flag string) string {
+	return envVarPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// loadConfigFile reads and validates the configuration file, all the problems are returned together
+func loadConfigFile(filename string) (configValues, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", filename, err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", filename, err)
+	}
+
+	var problems []string
+	switch version := document["apiVersion"]; version {
+	case configAPIVersion:
+	case nil:
+		problems = append(problems, "apiVersion: must be specified as "+configAPIVersion)
+	default:
+		problems = append(problems, fmt.Sprintf("apiVersion: unsupported version %v, expected %s", version, configAPIVersion))
+	}
+	if kind := document["kind"]; kind != configKind {
+		problems = append(problems, fmt.Sprintf("kind: must be %s", configKind))
+	}
+	delete(document, "apiVersion")
+	delete(document, "kind")
+
+	values := make(configValues, 0)
+	problems = append(problems, flattenConfig("", document, values)...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: %s", filename, strings.Join(problems, ", "))
+	}
+
+	return values, nil
+}
+
+// flattenConfig walks the sections of the document, adding the option values and returning any problems
+func flattenConfig(prefix string, section map[string]interface{}, values configValues) []string {
+	var keys []string
+	for k := range section {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, k := range keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		value := section[k]
+		option := findConfigOption(key)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if option != nil {
+				problems = append(problems, key+": expected a value, not a section")
+				continue
+			}
+			if !isConfigSection(key) {
+				problems = append(problems, key+": unknown section")
+				continue
+			}
+			problems = append(problems, flattenConfig(key, v, values)...)
+			continue
+		}
+		if option == nil {
+			problems = append(problems, key+": unknown option")
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		} else if !option.list {
+			problems = append(problems, key+": expected a single value, not a list")
+			continue
+		}
+		for _, x := range items {
+			item, err := configValue(x)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", key, err))
+				continue
+			}
+			values[key] = append(values[key], item)
+		}
+	}
+
+	return problems
+}
+
+// configValue converts a scalar value from the document into the command line form
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", fmt.Errorf("no value specified")
+	}
+
+	return "", fmt.Errorf("expected a value, found %v", value)
+}
+
+// applyConfig sets the command line options from the configuration file, skipping those which
+// have been overridden on the command line or environment
+func applyConfig(set flagSetter, values configValues, overrides map[string]bool) error {
+	var problems []string
+	for _, x := range configOptions {
+		if overrides[x.flag] {
+			continue
+		}
+		for _, v := range values[x.key] {
+			if err := set.Set(x.flag, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid value %q, %s", x.key, v, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config file: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// configOverrides returns the options set on the command line or by environment variable
+func configOverrides(isSet func(string) bool) map[string]bool {
+	overrides := make(map[string]bool, 0)
+	for _, x := range configOptions {
+		if isSet(x.flag) || os.Getenv(envVarName(x.flag)) != "" {
+			overrides[x.flag] = true
+		}
+	}
+
+	return overrides
+}
+
+// reloadConfig applies the options which are safe to change at runtime from the configuration
+// file; a change to any other option is logged as requiring a restart. Every change is parsed
+// before any is applied, so a bad value leaves the current options untouched
+func (s *service) reloadConfig() error {
+	values, err := loadConfigFile(s.cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	// step: parse the changed values
+	var changes []*configOption
+	var apply []func(*options)
+	for _, x := range configOptions {
+		if reflect.DeepEqual(values[x.key], s.cfg.configValues[x.key]) {
+			continue
+		}
+		switch {
+		case s.cfg.configOverrides[x.flag]:
+			logrus.WithFields(logrus.Fields{
+				"option": x.key,
+				"flag":   x.flag,
+			}).Warn("configuration option changed, but is overridden on the command line")
+		case x.reload == nil:
+			logrus.WithFields(logrus.Fields{
+				"option": x.key,
+			}).Warn("configuration option changed, a restart is required to apply it")
+		default:
+			fn, err := x.reload(values[x.key])
+			if err != nil {
+				return fmt.Errorf("%s: %s", x.key, err)
+			}
+			changes = append(changes, x)
+			apply = append(apply, fn)
+		}
+	}
+
+	// step: apply the changes together
+	for i, x := range changes {
+		apply[i](s.cfg)
+		logrus.WithFields(logrus.Fields{
+			"option": x.key,
+			"value":  strings.Join(values[x.key], ","),
+		}).Info("applied the configuration option change")
+	}
+	s.cfg.configValues = values
+
+	return nil
+}
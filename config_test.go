@@ -0,0 +1,228 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfigFile = `
+apiVersion: kube-auth/v1
+kind: Configuration
+listen:
+  address: 0.0.0.0:8443
+  shutdownDelay: 5s
+tls:
+  cert: /etc/secrets/tls.pem
+  hosts:
+  - kube-auth.kube-system
+  - 10.0.0.1
+authorization:
+  explainDecisions: true
+  policyDiffHistory: 20
+logging:
+  verbose: false
+`
+
+func TestLoadConfigFile(t *testing.T) {
+	file, err := writeTestFile(testConfigFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	values, err := loadConfigFile(file.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, configValues{
+		"listen.address":                  {"0.0.0.0:8443"},
+		"listen.shutdownDelay":            {"5s"},
+		"tls.cert":                        {"/etc/secrets/tls.pem"},
+		"tls.hosts":                       {"kube-auth.kube-system", "10.0.0.1"},
+		"authorization.explainDecisions":  {"true"},
+		"authorization.policyDiffHistory": {"20"},
+		"logging.verbose":                 {"false"},
+	}, values)
+}
+
+func TestLoadConfigFileBad(t *testing.T) {
+	cs := []struct {
+		Content string
+		Errors  []string
+	}{
+		{
+			Content: "kind: Configuration\n",
+			Errors:  []string{"apiVersion: must be specified"},
+		},
+		{
+			Content: "apiVersion: kube-auth/v2\nkind: Configuration\n",
+			Errors:  []string{"apiVersion: unsupported version kube-auth/v2"},
+		},
+		{
+			Content: "apiVersion: kube-auth/v1\nkind: Configuration\ntls:\n  crt: a\nlisten:\n  address:\n  - a\n  - b\n",
+			Errors:  []string{"listen.address: expected a single value, not a list", "tls.crt: unknown option"},
+		},
+		{
+			Content: "apiVersion: kube-auth/v1\nkind: Configuration\nmetrics:\n  path: /metrics\ntls: /etc/tls\n",
+			Errors:  []string{"metrics: unknown section", "tls: unknown option"},
+		},
+		{
+			Content: "apiVersion: kube-auth/v1\nkind: Configuration\nauthorization:\n  policy:\n    file: a\n",
+			Errors:  []string{"authorization.policy: expected a value, not a section"},
+		},
+	}
+	for i, c := range cs {
+		file, err := writeTestFile(c.Content)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		_, err = loadConfigFile(file.Name())
+		os.Remove(file.Name())
+		if !assert.Error(t, err, "case %d, expected an error", i) {
+			continue
+		}
+		for _, x := range c.Errors {
+			assert.Contains(t, err.Error(), x, "case %d", i)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	var listen string
+	var delay time.Duration
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.StringVar(&listen, "listen", "127.0.0.1:8443", "")
+	set.DurationVar(&delay, "shutdown-delay", 0, "")
+
+	values := configValues{
+		"listen.address":       {"0.0.0.0:8443"},
+		"listen.shutdownDelay": {"5s"},
+	}
+	assert.NoError(t, applyConfig(set, values, map[string]bool{"listen": true}))
+	assert.Equal(t, "127.0.0.1:8443", listen)
+	assert.Equal(t, 5*time.Second, delay)
+
+	values["listen.shutdownDelay"] = []string{"soon"}
+	err := applyConfig(set, values, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `listen.shutdownDelay: invalid value "soon"`)
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	os.Setenv("KUBE_AUTH_TLS_CERT", "/tmp/tls.pem")
+	defer os.Unsetenv("KUBE_AUTH_TLS_CERT")
+
+	overrides := configOverrides(func(name string) bool { return name == "listen" })
+	assert.Equal(t, map[string]bool{"listen": true, "tls-cert": true}, overrides)
+	assert.Equal(t, "KUBE_AUTH_TLS_CERT", envVarName("tls-cert"))
+}
+
+func TestReloadConfig(t *testing.T) {
+	file, err := writeTestFile(testConfigFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	values, err := loadConfigFile(file.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := &service{cfg: &options{
+		configFile:       file.Name(),
+		configValues:     values,
+		configOverrides:  map[string]bool{"verbose": true},
+		explainDecisions: true,
+		listen:           "0.0.0.0:8443",
+	}}
+
+	// @check only the runtime options are applied, the rest wait for a restart
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte(`
+apiVersion: kube-auth/v1
+kind: Configuration
+listen:
+  address: 127.0.0.1:9443
+authorization:
+  explainDecisions: false
+logging:
+  verbose: true
+`), 0644))
+	assert.NoError(t, s.reloadConfig())
+	assert.False(t, s.cfg.explainDecisions)
+	assert.False(t, s.cfg.verbose)
+	assert.Equal(t, "0.0.0.0:8443", s.cfg.listen)
+
+	// @check a bad file keeps the current options
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte("apiVersion: kube-auth/v1\nkind: Configuration\nauthorization:\n  explain: true\n"), 0644))
+	assert.Error(t, s.reloadConfig())
+	assert.False(t, s.cfg.explainDecisions)
+}
+
+func TestReloadConfigAllOrNothing(t *testing.T) {
+	file, err := writeTestFile(testConfigFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	values, err := loadConfigFile(file.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := &service{cfg: &options{
+		configFile:       file.Name(),
+		configValues:     values,
+		explainDecisions: true,
+	}}
+
+	// @check a bad value in a later option means none of the changes are applied
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte(`
+apiVersion: kube-auth/v1
+kind: Configuration
+authorization:
+  explainDecisions: false
+logging:
+  verbose: sometimes
+`), 0644))
+	assert.Error(t, s.reloadConfig())
+	assert.True(t, s.cfg.explainDecisions)
+	assert.Equal(t, values, s.cfg.configValues)
+
+	// @check the applied values are recorded, so an unchanged option is not applied again
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte(`
+apiVersion: kube-auth/v1
+kind: Configuration
+authorization:
+  explainDecisions: false
+`), 0644))
+	assert.NoError(t, s.reloadConfig())
+	assert.False(t, s.cfg.explainDecisions)
+	assert.Equal(t, []string{"false"}, s.cfg.configValues["authorization.explainDecisions"])
+
+	s.cfg.explainDecisions = true
+	assert.NoError(t, s.reloadConfig())
+	assert.True(t, s.cfg.explainDecisions)
+}
@@ -0,0 +1,489 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+const (
+	// delegationSource is the name of the delegated grants authorization source
+	delegationSource = "delegation"
+	// delegationPath is the admin endpoint for the delegated grants
+	delegationPath = "/admin/delegation/grants"
+)
+
+// readVerbs are the verbs permitted by a readonly rule
+var readVerbs = []string{"get", "list", "watch"}
+
+// namespaceOwner designates the group which owns the namespaces and the ceiling of what
+// the members may grant within them
+type namespaceOwner struct {
+	// Group is the owner group
+	Group string `json:"group"`
+	// Namespaces is the namespace, glob or regex of the namespaces owned
+	Namespaces string `json:"namespaces"`
+	// Ceiling are the rules the owners may grant, any grant must fall within one of them
+	Ceiling []policySpec `json:"ceiling"`
+}
+
+// delegationPolicy is the platform defined delegation file
+type delegationPolicy struct {
+	// Owners are the namespace owners
+	Owners []*namespaceOwner `json:"owners"`
+}
+
+// delegatedGrant is the access given by a namespace owner
+type delegatedGrant struct {
+	// ID is the identifier of the grant
+	ID string `json:"id"`
+	// Namespace is the namespace the access is limited to
+	Namespace string `json:"namespace"`
+	// Rule is the access given
+	Rule policySpec `json:"rule"`
+	// GrantedBy is the owner who gave the grant
+	GrantedBy string `json:"grantedBy"`
+	// Owner is the owner group whose ceiling permitted the grant
+	Owner string `json:"owner,omitempty"`
+	// Created is the time the grant was given
+	Created time.Time `json:"created"`
+}
+
+// delegationRequest is a request for a grant on the admin endpoint
+type delegationRequest struct {
+	// Namespace is the namespace the access is limited to
+	Namespace string `json:"namespace"`
+	// Rule is the access given, the verbs must be listed
+	Rule policySpec `json:"rule"`
+}
+
+// delegationStore holds the owners and the delegated grants and implements the authorization
+// interface; like the break-glass grants it's mutable and lives across reloads of the union
+type delegationStore struct {
+	sync.RWMutex
+	// owners are the namespace owners
+	owners []*namespaceOwner
+	// grants are the delegated grants
+	grants []*delegatedGrant
+	// filename is the file the grants are stored in
+	filename string
+	// policyFile is the delegation policy file
+	policyFile string
+	// now returns the current time
+	now func() time.Time
+}
+
+// newDelegationStore loads the delegation policy and any existing grants
+func newDelegationStore(policyFile, grantsFile string, now func() time.Time) (*delegationStore, error) {
+	content, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	policy := new(delegationPolicy)
+	if err := yaml.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("invalid delegation policy %s: %s", policyFile, err)
+	}
+	for i, x := range policy.Owners {
+		if err := x.validate(); err != nil {
+			return nil, fmt.Errorf("invalid delegation policy %s, owner %d: %s", policyFile, i+1, err)
+		}
+	}
+
+	d := &delegationStore{owners: policy.Owners, filename: grantsFile, policyFile: policyFile, now: now}
+	content, err = ioutil.ReadFile(grantsFile)
+	switch {
+	case os.IsNotExist(err):
+		return d, nil
+	case err != nil:
+		return nil, err
+	case len(strings.TrimSpace(string(content))) == 0:
+		return d, nil
+	}
+	var grants []*delegatedGrant
+	if err := json.Unmarshal(content, &grants); err != nil {
+		return nil, fmt.Errorf("invalid delegation grants %s: %s", grantsFile, err)
+	}
+
+	// step: the owners or ceilings may have been narrowed since the grants were given, so
+	// the grants are checked as if given now and those no longer permitted are dropped
+	for _, x := range grants {
+		if err := d.check(x); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"id":         x.ID,
+				"namespace":  x.Namespace,
+				"granted_by": x.GrantedBy,
+				"error":      err.Error(),
+			}).Warn("dropping a delegated grant no longer permitted by the delegation policy")
+			continue
+		}
+		d.grants = append(d.grants, x)
+	}
+	if len(d.grants) != len(grants) {
+		if err := d.save(d.grants); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// reload re-reads the delegation policy and grants; if either is invalid the current ones are kept
+func (d *delegationStore) reload() error {
+	updated, err := newDelegationStore(d.policyFile, d.filename, d.now)
+	if err != nil {
+		return err
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.owners, d.grants = updated.owners, updated.grants
+
+	return nil
+}
+
+// check validates a stored grant against the current owners and ceilings
+func (d *delegationStore) check(grant *delegatedGrant) error {
+	if err := validateDelegatedRule(grant.Namespace, &grant.Rule); err != nil {
+		return err
+	}
+	if grant.Rule.Namespace != grant.Namespace {
+		return errors.New("the rule namespace must be the namespace of the grant")
+	}
+	var owners []*namespaceOwner
+	for _, x := range d.owners {
+		if (grant.Owner == "" || x.Group == grant.Owner) && patternMatches(x.Namespaces, grant.Namespace) {
+			owners = append(owners, x)
+		}
+	}
+	if permittedBy(owners, &grant.Rule) == nil {
+		return errors.New("the access exceeds the ceiling for the namespace")
+	}
+
+	return nil
+}
+
+// validate checks the owner
+func (o *namespaceOwner) validate() error {
+	if o.Group == "" || o.Group == "*" {
+		return errors.New("the owner group must be given and can not be *")
+	}
+	if o.Namespaces == "" {
+		return errors.New("the owned namespaces must be given")
+	}
+	if len(o.Ceiling) == 0 {
+		return errors.New("the ceiling must have at least one rule")
+	}
+	for _, x := range o.Ceiling {
+		if x.User != "" || x.Group != "" || x.Namespace != "" || x.NonResourcePath != "" {
+			return errors.New("the ceiling rules can not have a user, group, namespace or nonResourcePath")
+		}
+		if err := (&policy{Spec: x}).validatePatterns(); err != nil {
+			return err
+		}
+	}
+	if isPattern(o.Namespaces) {
+		if _, err := compilePattern(o.Namespaces); err != nil {
+			return fmt.Errorf("invalid namespaces pattern %q: %s", o.Namespaces, err)
+		}
+	}
+
+	return nil
+}
+
+// ownersOf returns the owners of the namespace the caller is a member of, the lock must be held
+func (d *delegationStore) ownersOf(caller user.Info, namespace string) []*namespaceOwner {
+	var list []*namespaceOwner
+	for _, x := range d.owners {
+		if containedIn(x.Group, caller.GetGroups()) && patternMatches(x.Namespaces, namespace) {
+			list = append(list, x)
+		}
+	}
+
+	return list
+}
+
+// owns checks if the caller owns the namespace
+func (d *delegationStore) owns(caller user.Info, namespace string) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.isOwner(caller, namespace)
+}
+
+// isOwner checks if the caller owns the namespace, the lock must be held
+func (d *delegationStore) isOwner(caller user.Info, namespace string) bool {
+	return namespace != "" && !isPattern(namespace) && len(d.ownersOf(caller, namespace)) > 0
+}
+
+// add validates the request against the ceiling of the owners and gives the grant
+func (d *delegationStore) add(caller user.Info, request *delegationRequest) (*delegatedGrant, error) {
+	rule := request.Rule
+	if err := validateDelegatedRule(request.Namespace, &rule); err != nil {
+		return nil, err
+	}
+	rule.Namespace = request.Namespace
+
+	d.RLock()
+	owner := permittedBy(d.ownersOf(caller, request.Namespace), &rule)
+	d.RUnlock()
+	if owner == nil {
+		return nil, errors.New("the access exceeds the ceiling for the namespace")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	grant := &delegatedGrant{
+		ID:        hex.EncodeToString(id),
+		Namespace: request.Namespace,
+		Rule:      rule,
+		GrantedBy: caller.GetName(),
+		Owner:     owner.Group,
+		Created:   d.now().UTC(),
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if err := d.save(append(d.grants, grant)); err != nil {
+		return nil, err
+	}
+	d.grants = append(d.grants, grant)
+
+	logrus.WithFields(logrus.Fields{
+		"id":         grant.ID,
+		"namespace":  grant.Namespace,
+		"grant":      (&policy{Spec: grant.Rule}).describe(),
+		"granted_by": grant.GrantedBy,
+	}).Info("namespace access delegated")
+
+	return grant, nil
+}
+
+// validateDelegatedRule checks the shape of a delegated rule, the namespace of the rule is
+// either empty or the namespace of the grant
+func validateDelegatedRule(namespace string, rule *policySpec) error {
+	if rule.User == "" && rule.Group == "" {
+		return errors.New("a user or group must be given")
+	}
+	if rule.User == "*" || rule.Group == "*" {
+		return errors.New("access can not be given to all users or groups")
+	}
+	if rule.Namespace != "" && rule.Namespace != namespace {
+		return errors.New("the rule namespace must be the namespace of the grant")
+	}
+	if rule.NonResourcePath != "" {
+		return errors.New("non resource paths can not be delegated")
+	}
+	if rule.Resource == "" && len(rule.Subresources) == 0 {
+		return errors.New("a resource or subresources must be given")
+	}
+	if len(rule.Verbs) == 0 {
+		return errors.New("the verbs must be given")
+	}
+
+	return (&policy{Spec: *rule}).validatePatterns()
+}
+
+// permittedBy returns the first of the owners with a ceiling covering the rule, or nil
+func permittedBy(owners []*namespaceOwner, rule *policySpec) *namespaceOwner {
+	for _, x := range owners {
+		for i := range x.Ceiling {
+			if withinCeiling(rule, &x.Ceiling[i]) {
+				return x
+			}
+		}
+	}
+
+	return nil
+}
+
+// find returns the grant or nil
+func (d *delegationStore) find(id string) *delegatedGrant {
+	d.RLock()
+	defer d.RUnlock()
+	for _, x := range d.grants {
+		if x.ID == id {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// revoke removes the grant
+func (d *delegationStore) revoke(caller user.Info, id string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	var grants []*delegatedGrant
+	for _, x := range d.grants {
+		if x.ID != id {
+			grants = append(grants, x)
+		}
+	}
+	if err := d.save(grants); err != nil {
+		return err
+	}
+	d.grants = grants
+
+	logrus.WithFields(logrus.Fields{
+		"id":         id,
+		"revoked_by": caller.GetName(),
+	}).Info("namespace access revoked")
+
+	return nil
+}
+
+// list returns the grants in the namespaces owned by the caller
+func (d *delegationStore) list(caller user.Info) []*delegatedGrant {
+	d.RLock()
+	defer d.RUnlock()
+
+	list := make([]*delegatedGrant, 0)
+	for _, x := range d.grants {
+		if d.isOwner(caller, x.Namespace) {
+			list = append(list, x)
+		}
+	}
+
+	return list
+}
+
+// Authorize checks the request against the delegated grants
+func (d *delegationStore) Authorize(a authorizer.Attributes) (bool, string, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	now := d.now()
+	for _, x := range d.grants {
+		if (&policy{Spec: x.Rule}).matches(a, now) {
+			return true, fmt.Sprintf("grant %s in %s by %s", x.ID, x.Namespace, x.GrantedBy), nil
+		}
+	}
+
+	return false, "", nil
+}
+
+// save writes the grants to a temporary file and renames it over the grants file, the lock must be held
+func (d *delegationStore) save(grants []*delegatedGrant) error {
+	if grants == nil {
+		grants = []*delegatedGrant{}
+	}
+	encoded, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(d.filename), "."+filepath.Base(d.filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), d.filename)
+}
+
+// withinCeiling checks the rule gives no more than the ceiling rule
+func withinCeiling(rule, ceiling *policySpec) bool {
+	if rule.Resource != "" && !coversValue(ceiling.Resource, rule.Resource) {
+		return false
+	}
+	if !coversValue(ceiling.APIGroup, rule.APIGroup) {
+		return false
+	}
+	if ceiling.Name != "" && (rule.Name == "" || !coversValue(ceiling.Name, rule.Name)) {
+		return false
+	}
+	for _, x := range rule.Verbs {
+		if !ceilingVerb(ceiling, x) {
+			return false
+		}
+	}
+
+	// step: a rule for a resource without subresources gives all the subresources
+	if rule.Resource != "" && len(rule.Subresources) == 0 {
+		return len(ceiling.Subresources) == 0
+	}
+	for _, x := range rule.Subresources {
+		if len(ceiling.Subresources) == 0 {
+			if !coversValue(ceiling.Resource, strings.SplitN(x, "/", 2)[0]) {
+				return false
+			}
+			continue
+		}
+		var covered bool
+		for _, y := range ceiling.Subresources {
+			covered = covered || coversValue(y, x)
+		}
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ceilingVerb checks the ceiling rule permits the verb
+func ceilingVerb(ceiling *policySpec, verb string) bool {
+	all := len(ceiling.Verbs) == 0 || containedIn("*", ceiling.Verbs)
+	if verb == "*" {
+		return all && !ceiling.Readonly
+	}
+	if ceiling.Readonly && !containedIn(verb, readVerbs) {
+		return false
+	}
+
+	return all || containedIn(verb, ceiling.Verbs)
+}
+
+// coversValue checks the ceiling covers every value the rule could match; a rule pattern is
+// only covered by the same pattern or a wildcard
+func coversValue(ceiling, value string) bool {
+	if ceiling == "*" || ceiling == value {
+		return true
+	}
+	if isPattern(value) {
+		return false
+	}
+
+	return patternMatches(ceiling, value)
+}
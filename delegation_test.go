@@ -0,0 +1,294 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDelegationPolicy = `
+owners:
+- group: group3
+  namespaces: te-*
+  ceiling:
+  - resource: '*'
+    apiGroup: '*'
+    verbs: [get, list, watch, create, update, patch, delete]
+    subresources: [pods/log, pods/portforward]
+  - resource: secrets
+    apiGroup: ''
+    readonly: true
+`
+
+func TestWithinCeiling(t *testing.T) {
+	ceiling := policySpec{Resource: "*", APIGroup: "*", Verbs: []string{"get", "list", "create"}, Subresources: []string{"pods/log"}}
+	readonly := policySpec{Resource: "secrets", APIGroup: "", Readonly: true}
+	cs := []struct {
+		Rule     policySpec
+		Ceiling  policySpec
+		Expected bool
+	}{
+		{Rule: policySpec{Resource: "pods", APIGroup: "", Verbs: []string{"get", "create"}, Subresources: []string{"pods/log"}}, Ceiling: ceiling, Expected: true},
+		{Rule: policySpec{Resource: "pods", APIGroup: "", Verbs: []string{"get"}}, Ceiling: ceiling},
+		{Rule: policySpec{Resource: "pods", APIGroup: "", Verbs: []string{"delete"}, Subresources: []string{"pods/log"}}, Ceiling: ceiling},
+		{Rule: policySpec{Resource: "pods", APIGroup: "", Verbs: []string{"*"}, Subresources: []string{"pods/log"}}, Ceiling: ceiling},
+		{Rule: policySpec{APIGroup: "", Verbs: []string{"create"}, Subresources: []string{"pods/exec"}}, Ceiling: ceiling},
+		{Rule: policySpec{Resource: "secrets", APIGroup: "", Verbs: []string{"get", "watch"}}, Ceiling: readonly, Expected: true},
+		{Rule: policySpec{Resource: "secrets", APIGroup: "", Verbs: []string{"update"}}, Ceiling: readonly},
+		{Rule: policySpec{Resource: "*", APIGroup: "", Verbs: []string{"get"}}, Ceiling: readonly},
+		{Rule: policySpec{Resource: "secrets", APIGroup: "*", Verbs: []string{"get"}}, Ceiling: readonly},
+		{Rule: policySpec{Resource: "secret*", APIGroup: "", Verbs: []string{"get"}}, Ceiling: policySpec{Resource: "sec*", APIGroup: ""}},
+		{Rule: policySpec{Resource: "secrets", APIGroup: "", Verbs: []string{"get"}}, Ceiling: policySpec{Resource: "sec*", APIGroup: ""}, Expected: true},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, withinCeiling(&c.Rule, &c.Ceiling), "case %d", i)
+	}
+}
+
+func TestDelegationOwnerPatternAnchored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-auth-delegation")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	policyFile := filepath.Join(dir, "delegation.yaml")
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte("owners:\n- group: group3\n  namespaces: ^team-a|team-b$\n  ceiling:\n  - resource: '*'\n"), 0644))
+
+	d, err := newDelegationStore(policyFile, filepath.Join(dir, "grants.json"), time.Now)
+	if !assert.NoError(t, err) {
+		return
+	}
+	owner := &user.DefaultInfo{Name: "user3", Groups: []string{"group3"}}
+	assert.True(t, d.owns(owner, "team-a"))
+	assert.True(t, d.owns(owner, "team-b"))
+	assert.False(t, d.owns(owner, "evil-team-b"))
+	assert.False(t, d.owns(owner, "team-a-other"))
+}
+
+func TestDelegationStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-auth-delegation")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	policyFile, grantsFile := filepath.Join(dir, "delegation.yaml"), filepath.Join(dir, "grants.json")
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte(testDelegationPolicy), 0644))
+
+	d, err := newDelegationStore(policyFile, grantsFile, time.Now)
+	if !assert.NoError(t, err) {
+		return
+	}
+	owner := &user.DefaultInfo{Name: "user3", Groups: []string{"group3"}}
+	assert.True(t, d.owns(owner, "te-dev"))
+	assert.False(t, d.owns(owner, "te-*"))
+	assert.False(t, d.owns(owner, "adm"))
+	assert.False(t, d.owns(&user.DefaultInfo{Name: "user1"}, "te-dev"))
+
+	cs := []struct {
+		Request delegationRequest
+		Error   string
+	}{
+		{Request: delegationRequest{Namespace: "te-dev", Rule: policySpec{Resource: "pods", Verbs: []string{"get"}}}, Error: "a user or group must be given"},
+		{Request: delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", Resource: "pods"}}, Error: "the verbs must be given"},
+		{Request: delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", Namespace: "adm", Resource: "pods", Verbs: []string{"get"}}}, Error: "the rule namespace"},
+		{Request: delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", NonResourcePath: "/api"}}, Error: "non resource paths"},
+		{Request: delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", Resource: "secrets", Verbs: []string{"delete"}}}, Error: "exceeds the ceiling"},
+		{Request: delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", Resource: "secrets", Verbs: []string{"get", "list"}}}},
+	}
+	var grant *delegatedGrant
+	for i, c := range cs {
+		g, err := d.add(owner, &c.Request)
+		if c.Error == "" {
+			assert.NoError(t, err, "case %d", i)
+			grant = g
+			continue
+		}
+		if assert.Error(t, err, "case %d", i) {
+			assert.Contains(t, err.Error(), c.Error, "case %d", i)
+		}
+	}
+	if !assert.NotNil(t, grant) {
+		return
+	}
+	assert.Equal(t, "te-dev", grant.Rule.Namespace)
+	assert.Equal(t, "user3", grant.GrantedBy)
+
+	attrs := &authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "jane"}, Verb: "get", Namespace: "te-dev", Resource: "secrets", ResourceRequest: true}
+	allowed, reason, _ := d.Authorize(attrs)
+	assert.True(t, allowed)
+	assert.Equal(t, "grant "+grant.ID+" in te-dev by user3", reason)
+
+	// @check the grants are persisted
+	restored, err := newDelegationStore(policyFile, grantsFile, time.Now)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, restored.list(owner), 1)
+	assert.Len(t, restored.list(&user.DefaultInfo{Name: "user1"}), 0)
+	assert.NoError(t, restored.revoke(owner, grant.ID))
+	allowed, _, _ = restored.Authorize(attrs)
+	assert.False(t, allowed)
+
+	restored, err = newDelegationStore(policyFile, grantsFile, time.Now)
+	if assert.NoError(t, err) {
+		assert.Len(t, restored.list(owner), 0)
+	}
+
+	// @check grants no longer within the ceiling are dropped on load
+	grant, err = restored.add(owner, &delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", Resource: "secrets", Verbs: []string{"get"}}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "group3", grant.Owner)
+	_, err = restored.add(owner, &delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "jane", Resource: "pods", Subresources: []string{"pods/log"}, Verbs: []string{"get"}}})
+	assert.NoError(t, err)
+	narrowed := strings.Replace(testDelegationPolicy, "  - resource: secrets\n    apiGroup: ''\n    readonly: true\n", "", 1)
+	assert.NotEqual(t, testDelegationPolicy, narrowed)
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte(narrowed), 0644))
+	assert.NoError(t, restored.reload())
+	if grants := restored.list(owner); assert.Len(t, grants, 1) {
+		assert.Equal(t, "pods", grants[0].Rule.Resource)
+	}
+	allowed, _, _ = restored.Authorize(attrs)
+	assert.False(t, allowed)
+	content, err := ioutil.ReadFile(grantsFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), grant.ID)
+
+	// @check grants from an owner who has been removed are dropped
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte(strings.Replace(narrowed, "group3", "group4", 1)), 0644))
+	restored, err = newDelegationStore(policyFile, grantsFile, time.Now)
+	if assert.NoError(t, err) {
+		assert.Len(t, restored.list(&user.DefaultInfo{Name: "user4", Groups: []string{"group4"}}), 0)
+	}
+}
+
+func TestDelegationPolicyBad(t *testing.T) {
+	cs := []string{
+		"owners:\n- namespaces: te-*\n  ceiling:\n  - resource: '*'\n",
+		"owners:\n- group: '*'\n  namespaces: te-*\n  ceiling:\n  - resource: '*'\n",
+		"owners:\n- group: team\n  namespaces: te-*\n",
+		"owners:\n- group: team\n  namespaces: te-*\n  ceiling:\n  - resource: '*'\n    namespace: adm\n",
+		"owners:\n- group: team\n  namespaces: ^te-[$\n  ceiling:\n  - resource: '*'\n",
+	}
+	for i, c := range cs {
+		f, err := writeTestFile(c)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		_, err = newDelegationStore(f.Name(), f.Name()+".grants", time.Now)
+		assert.Error(t, err, "case %d", i)
+		os.Remove(f.Name())
+	}
+}
+
+func TestDelegationEndpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-auth-delegation")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	policyFile := filepath.Join(dir, "delegation.yaml")
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte(testDelegationPolicy), 0644))
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		adminListen:      "127.0.0.1:8081",
+		adminTLSCert:     "does_not_exist",
+		adminTLSKey:      "does_not_exist",
+		delegationPolicy: policyFile,
+		delegationGrants: filepath.Join(dir, "grants.json"),
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+	admin := httptest.NewServer(s.s.admin)
+	defer admin.Close()
+
+	review := v1beta1.SubjectAccessReview{
+		Spec: v1beta1.SubjectAccessReviewSpec{
+			User:               "user1",
+			ResourceAttributes: &v1beta1.ResourceAttributes{Verb: "get", Namespace: "te-dev", Resource: "secrets"},
+		},
+	}
+	status, err := makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+
+	request := &delegationRequest{Namespace: "te-dev", Rule: policySpec{User: "user1", Resource: "secrets", Verbs: []string{"get"}}}
+	res, err := hc.R().SetBody(request).Post(admin.URL + delegationPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode())
+	res, err = hc.R().SetAuthToken("token2").SetBody(request).Post(admin.URL + delegationPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode())
+
+	grant := new(delegatedGrant)
+	res, err = hc.R().SetAuthToken("token3").SetBody(request).SetResult(grant).Post(admin.URL + delegationPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode())
+
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+	assert.Contains(t, status.Status.Reason, "delegation: grant "+grant.ID)
+
+	var grants []*delegatedGrant
+	_, err = hc.R().SetAuthToken("token3").SetResult(&grants).Get(admin.URL + delegationPath)
+	assert.NoError(t, err)
+	assert.Len(t, grants, 1)
+
+	res, err = hc.R().SetAuthToken("token2").Delete(admin.URL + delegationPath + "/" + grant.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode())
+	res, err = hc.R().SetAuthToken("token3").Delete(admin.URL + delegationPath + "/" + grant.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode())
+
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+
+	// @check a change to the delegation policy is applied to the existing grants
+	res, err = hc.R().SetAuthToken("token3").SetBody(request).Post(admin.URL + delegationPath)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode())
+	time.Sleep(800 * time.Millisecond)
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte(strings.Replace(testDelegationPolicy, "group3", "group4", 1)), 0644))
+	time.Sleep(800 * time.Millisecond)
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+}
@@ -18,25 +18,37 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	auth "k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
 	authz "k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/user"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// authorizePath is the prefix of the review endpoints
+	authorizePath = "/authorize/"
+	// tokenReviewKind is the kind of the token review endpoint
+	tokenReviewKind = "token"
+	// accessReviewKind is the kind of the subject access review endpoint
+	accessReviewKind = "policy"
+)
+
 // authorizeHandler is responsible for verifying the tokens or a user request
 func (r *service) authorizeHandler(cx *gin.Context) {
 	kind := cx.Param("kind")
 	// step: decode the input
 	var review interface{}
 	switch kind {
-	case "token":
+	case tokenReviewKind:
 		review = new(auth.TokenReview)
-	case "policy":
+	case accessReviewKind:
 		review = new(authz.SubjectAccessReview)
 	default:
 		cx.AbortWithStatus(http.StatusNotFound)
@@ -65,9 +77,9 @@ func (r *service) authorizeHandler(cx *gin.Context) {
 	var result interface{}
 	var err error
 	switch kind {
-	case "token":
+	case tokenReviewKind:
 		result, err = r.authentication(review.(*auth.TokenReview))
-	case "policy":
+	case accessReviewKind:
 		result, err = r.authorize(review.(*authz.SubjectAccessReview))
 	}
 	if err != nil {
@@ -87,6 +99,19 @@ func (r *service) authorizeHandler(cx *gin.Context) {
 		"response":  fmt.Sprintf("%#v", result),
 	}).Debug("response to request")
 
+	// step: capture the review if required
+	if r.capture != nil {
+		captured, err := newCapturedReview(kind, review, result)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"kind":  kind,
+				"error": err.Error(),
+			}).Error("unable to capture the review")
+		} else {
+			r.capture.record(captured)
+		}
+	}
+
 	// step: return the result
 	cx.JSON(http.StatusOK, result)
 }
@@ -104,3 +129,176 @@ func (r *service) healthHandler(cx *gin.Context) {
 func (r *service) versionHandler(cx *gin.Context) {
 	cx.String(http.StatusOK, "%s\n", version)
 }
+
+//
+// metricsHandler is responsible for showing the metrics
+//
+func (r *service) metricsHandler(cx *gin.Context) {
+	cx.Header("Content-Type", "text/plain; version=0.0.4")
+	cx.Status(http.StatusOK)
+	r.metrics.write(cx.Writer)
+}
+
+//
+// policyDiffsHandler is responsible for showing the policy changes of the last reloads
+//
+func (r *service) policyDiffsHandler(cx *gin.Context) {
+	cx.JSON(http.StatusOK, r.diffs.list())
+}
+
+//
+// breakGlassListHandler is responsible for showing the active break-glass grants
+//
+func (r *service) breakGlassListHandler(cx *gin.Context) {
+	if _, found := r.authorizeBreakGlassCaller(cx); !found {
+		return
+	}
+	cx.JSON(http.StatusOK, r.breakGlass.list())
+}
+
+//
+// breakGlassGrantHandler is responsible for giving a break-glass grant
+//
+func (r *service) breakGlassGrantHandler(cx *gin.Context) {
+	caller, found := r.authorizeBreakGlassCaller(cx)
+	if !found {
+		return
+	}
+	request := new(breakGlassRequest)
+	if err := json.NewDecoder(cx.Request.Body).Decode(request); err != nil {
+		cx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request, " + err.Error()})
+		return
+	}
+	grant, err := r.breakGlass.add(caller.GetName(), request)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"client_ip": cx.ClientIP(),
+			"caller":    caller.GetName(),
+			"error":     err.Error(),
+		}).Error("unable to give the break-glass grant")
+
+		cx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cx.JSON(http.StatusCreated, grant)
+}
+
+//
+// breakGlassRevokeHandler is responsible for revoking a break-glass grant
+//
+func (r *service) breakGlassRevokeHandler(cx *gin.Context) {
+	caller, found := r.authorizeBreakGlassCaller(cx)
+	if !found {
+		return
+	}
+	if err := r.breakGlass.revoke(caller.GetName(), cx.Param("id")); err != nil {
+		cx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	cx.Status(http.StatusNoContent)
+}
+
+//
+// delegationListHandler is responsible for showing the grants in the namespaces owned by the caller
+//
+func (r *service) delegationListHandler(cx *gin.Context) {
+	caller, found := r.authenticateCaller(cx)
+	if !found {
+		return
+	}
+
+	cx.JSON(http.StatusOK, r.delegation.list(caller))
+}
+
+//
+// delegationGrantHandler is responsible for giving access within a namespace owned by the caller
+//
+func (r *service) delegationGrantHandler(cx *gin.Context) {
+	caller, found := r.authenticateCaller(cx)
+	if !found {
+		return
+	}
+	request := new(delegationRequest)
+	if err := json.NewDecoder(cx.Request.Body).Decode(request); err != nil {
+		cx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request, " + err.Error()})
+		return
+	}
+	if !r.delegation.owns(caller, request.Namespace) {
+		cx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s does not own the namespace %q", caller.GetName(), request.Namespace)})
+		return
+	}
+	grant, err := r.delegation.add(caller, request)
+	if err != nil {
+		cx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cx.JSON(http.StatusCreated, grant)
+}
+
+//
+// delegationRevokeHandler is responsible for revoking a grant within a namespace owned by the caller
+//
+func (r *service) delegationRevokeHandler(cx *gin.Context) {
+	caller, found := r.authenticateCaller(cx)
+	if !found {
+		return
+	}
+	grant := r.delegation.find(cx.Param("id"))
+	if grant == nil {
+		cx.JSON(http.StatusNotFound, gin.H{"error": "no grant " + cx.Param("id")})
+		return
+	}
+	if !r.delegation.owns(caller, grant.Namespace) {
+		cx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s does not own the namespace %q", caller.GetName(), grant.Namespace)})
+		return
+	}
+	if err := r.delegation.revoke(caller, grant.ID); err != nil {
+		cx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cx.Status(http.StatusNoContent)
+}
+
+// authenticateCaller authenticates the bearer token of the request against the tokens, aborting
+// the request if not found
+func (r *service) authenticateCaller(cx *gin.Context) (user.Info, bool) {
+	token := strings.TrimSpace(strings.TrimPrefix(cx.Request.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		cx.JSON(http.StatusUnauthorized, gin.H{"error": "a bearer token must be given"})
+		return nil, false
+	}
+	review, err := r.authentication(&auth.TokenReview{Spec: auth.TokenReviewSpec{Token: token}})
+	if err != nil || !review.Status.Authenticated {
+		cx.JSON(http.StatusUnauthorized, gin.H{"error": "the token is not valid"})
+		return nil, false
+	}
+
+	return &user.DefaultInfo{
+		Name:   review.Status.User.Username,
+		Groups: review.Status.User.Groups,
+	}, true
+}
+
+// authorizeBreakGlassCaller authenticates the caller and checks they are a member of the break-glass
+// admin group, responding with the error if not
+func (r *service) authorizeBreakGlassCaller(cx *gin.Context) (user.Info, bool) {
+	caller, found := r.authenticateCaller(cx)
+	if !found {
+		return nil, false
+	}
+	if !containedIn(r.cfg.breakGlassAdminGroup, caller.GetGroups()) {
+		logrus.WithFields(logrus.Fields{
+			"client_ip": cx.ClientIP(),
+			"caller":    caller.GetName(),
+		}).Warn("denied a break-glass request from a caller outside the admin group")
+
+		cx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s is not permitted to manage the break-glass grants", caller.GetName())})
+		return nil, false
+	}
+
+	return caller, true
+}
@@ -19,6 +19,7 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -59,3 +60,60 @@ func TestAuthorizeDecodeBad(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode())
 }
+
+func TestWebhookListenerEndpoints(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	assert.Nil(t, s.s.admin)
+
+	cs := []struct {
+		URL    string
+		Status int
+	}{
+		{URL: s.URL() + "/health", Status: http.StatusOK},
+		{URL: s.URL() + "/version", Status: http.StatusOK},
+		{URL: s.URL() + "/healthz", Status: http.StatusOK},
+		{URL: s.URL() + "/metrics", Status: http.StatusNotFound},
+		{URL: s.URL() + "/admin/policy/diffs", Status: http.StatusNotFound},
+		{URL: s.URL() + "/debug/pprof/", Status: http.StatusNotFound},
+	}
+	for _, x := range cs {
+		res, err := hc.R().Get(x.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, x.Status, res.StatusCode(), "url: %s", x.URL)
+	}
+}
+
+func TestAdminListenerEndpoints(t *testing.T) {
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{adminListen: "127.0.0.1:8081"})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+	admin := httptest.NewServer(s.s.admin)
+	defer admin.Close()
+
+	cs := []struct {
+		URL    string
+		Status int
+	}{
+		{URL: s.URL() + "/health", Status: http.StatusNotFound},
+		{URL: s.URL() + "/metrics", Status: http.StatusNotFound},
+		{URL: s.URL() + "/debug/pprof/", Status: http.StatusNotFound},
+		{URL: admin.URL + "/health", Status: http.StatusOK},
+		{URL: admin.URL + "/version", Status: http.StatusOK},
+		{URL: admin.URL + "/metrics", Status: http.StatusOK},
+		{URL: admin.URL + "/admin/policy/diffs", Status: http.StatusOK},
+		{URL: admin.URL + "/debug/pprof/", Status: http.StatusOK},
+	}
+	for _, x := range cs {
+		res, err := hc.R().Get(x.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, x.Status, res.StatusCode(), "url: %s", x.URL)
+	}
+
+	// step: the webhook is only served on the webhook listener
+	res, err := hc.R().Post(admin.URL + "/authorize/token")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode())
+}
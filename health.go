@@ -0,0 +1,145 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheck is a named check reported by the health and readiness endpoints
+type healthCheck struct {
+	// name is the name of the check
+	name string
+	// check returns an error if the check fails
+	check func() error
+}
+
+// livenessChecks are the checks indicating the process is alive
+func (s *service) livenessChecks() []healthCheck {
+	return []healthCheck{
+		{name: "ping", check: func() error { return nil }},
+	}
+}
+
+// readinessChecks are the checks indicating the service is able to answer reviews
+func (s *service) readinessChecks() []healthCheck {
+	return []healthCheck{
+		{name: "reload", check: s.checkReload},
+		{name: "tls-certificate", check: s.checkCertificate},
+		{name: "draining", check: s.checkDraining},
+	}
+}
+
+// checkReload checks the last reload of every watched file succeeded, a failed reload leaves
+// the previous contents in place so the service is answering with stale policy
+func (s *service) checkReload() error {
+	s.RLock()
+	defer s.RUnlock()
+
+	var failed []string
+	for filename := range s.reloadErrors {
+		failed = append(failed, filename)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("unable to reload: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// checkCertificate checks the certificate held by the webhook listener is within its validity period;
+// a rotated file on disk is not served until a restart, so it's the loaded certificate which matters
+func (s *service) checkCertificate() error {
+	if s.cfg.tlsCert == "" || s.cfg.tlsKey == "" {
+		return nil
+	}
+	s.RLock()
+	cert := s.serving
+	s.RUnlock()
+	if cert == nil {
+		return errors.New("the webhook listener is not serving a certificate")
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("the certificate is not valid until %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("the certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// checkDraining checks the service is not shutting down
+func (s *service) checkDraining() error {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return errors.New("the service is draining")
+	}
+
+	return nil
+}
+
+// drain marks the service as shutting down, failing the readiness checks
+func (s *service) drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+// checkHandler returns a handler running the checks; like the kubernetes apiserver, the checks
+// are only listed on failure or when the verbose query parameter is given, and the reason for a
+// failure is withheld unless verbose
+func (s *service) checkHandler(name string, checks func() []healthCheck) gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		_, verbose := cx.GetQuery("verbose")
+
+		failed := false
+		b := new(bytes.Buffer)
+		for _, x := range checks() {
+			if err := x.check(); err != nil {
+				failed = true
+				reason := "reason withheld"
+				if verbose {
+					reason = err.Error()
+				}
+				fmt.Fprintf(b, "[-]%s failed: %s\n", x.name, reason)
+				continue
+			}
+			fmt.Fprintf(b, "[+]%s ok\n", x.name)
+		}
+
+		switch {
+		case failed:
+			fmt.Fprintf(b, "%s check failed\n", name)
+			cx.String(http.StatusInternalServerError, b.String())
+		case verbose:
+			fmt.Fprintf(b, "%s check passed\n", name)
+			cx.String(http.StatusOK, b.String())
+		default:
+			cx.String(http.StatusOK, "ok")
+		}
+	}
+}
@@ -0,0 +1,177 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLivenessHandler(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+
+	res, err := hc.R().Get(s.URL() + "/healthz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.Equal(t, "ok", string(res.Body()))
+
+	res, err = hc.R().Get(s.URL() + "/healthz?verbose")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.Equal(t, "[+]ping ok\nhealthz check passed\n", string(res.Body()))
+}
+
+func TestReadinessHandler(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+
+	// step: the test service has no certificate
+	res, err := hc.R().Get(s.URL() + "/readyz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode())
+	assert.Contains(t, string(res.Body()), "[-]tls-certificate failed: reason withheld\n")
+
+	cert, key := writeTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	defer os.Remove(cert)
+	defer os.Remove(key)
+	s.s.serving = loadTestCertificate(t, cert, key)
+
+	res, err = hc.R().Get(s.URL() + "/readyz?verbose")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.Equal(t, "[+]reload ok\n[+]tls-certificate ok\n[+]draining ok\nreadyz check passed\n", string(res.Body()))
+
+	// step: a failed reload is reported until the file reloads
+	s.s.recordReload("policy.json", errors.New("invalid policy"))
+	res, err = hc.R().Get(s.URL() + "/readyz?verbose")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode())
+	assert.Contains(t, string(res.Body()), "[-]reload failed: unable to reload: policy.json\n")
+	s.s.recordReload("policy.json", nil)
+	res, err = hc.R().Get(s.URL() + "/readyz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+
+	s.s.drain()
+	res, err = hc.R().Get(s.URL() + "/readyz?verbose")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode())
+	assert.Contains(t, string(res.Body()), "[-]draining failed: the service is draining\n")
+
+	// step: the liveness is unaffected by draining
+	res, err = hc.R().Get(s.URL() + "/healthz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+}
+
+func TestCheckCertificateExpired(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+
+	cert, key := writeTestCertificate(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	defer os.Remove(cert)
+	defer os.Remove(key)
+	s.s.cfg.tlsCert, s.s.cfg.tlsKey = cert, key
+
+	serving, err := s.s.serve("127.0.0.1:0", s.s.engine, cert, key, "")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s.s.serving = serving
+
+	// @check rotating the file does not hide the expired certificate being served
+	rotatedCert, rotatedKey := writeTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	defer os.Remove(rotatedCert)
+	defer os.Remove(rotatedKey)
+	assert.NoError(t, os.Rename(rotatedCert, cert))
+	assert.NoError(t, os.Rename(rotatedKey, key))
+
+	err = s.s.checkCertificate()
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), "the certificate expired at"))
+	}
+}
+
+// loadTestCertificate parses the certificate as it would be held by the listener
+func loadTestCertificate(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unable to load the certificate, error: %s", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatalf("unable to parse the certificate, error: %s", err)
+	}
+
+	return cert
+}
+
+// writeTestCertificate writes a self signed certificate and key valid for the period
+func writeTestCertificate(t *testing.T, notBefore, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate the key, error: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-auth"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create the certificate, error: %s", err)
+	}
+	encodedKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to encode the key, error: %s", err)
+	}
+
+	certFile, err := ioutil.TempFile("/tmp", "kube-auth.cert.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("unable to create the certificate file, error: %s", err)
+	}
+	defer certFile.Close()
+	pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyFile, err := ioutil.TempFile("/tmp", "kube-auth.key.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("unable to create the key file, error: %s", err)
+	}
+	defer keyFile.Close()
+	pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey})
+
+	return certFile.Name(), keyFile.Name()
+}
@@ -0,0 +1,309 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// defaultJWTGroupsClaim is the claim the groups are taken from when none are given
+const defaultJWTGroupsClaim = "groups"
+
+// jwtAuthenticator authenticates signed json web tokens, mapping the claims on to the user
+type jwtAuthenticator struct {
+	// keys are the public keys the tokens can be signed with
+	keys []crypto.PublicKey
+	// issuer is the required iss claim, if any
+	issuer string
+	// audience is the required aud claim, if any
+	audience string
+	// usernameClaim is the claim the username is taken from
+	usernameClaim string
+	// groupsClaims are the claims the groups are taken from
+	groupsClaims []string
+	// extraClaims maps the extra attribute keys to the claims they are taken from
+	extraClaims map[string]string
+	// now returns the current time
+	now func() time.Time
+}
+
+// jwtHeader is the header of a json web token
+type jwtHeader struct {
+	// Algorithm is the signing algorithm
+	Algorithm string `json:"alg"`
+}
+
+// newJWTAuthenticator creates a json web token authenticator from the options
+func newJWTAuthenticator(o *options, now func() time.Time) (*jwtAuthenticator, error) {
+	keys, err := loadJWTPublicKeys(o.jwtPublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := parseJWTExtraClaims(o.jwtExtraClaims)
+	if err != nil {
+		return nil, err
+	}
+	groups := o.jwtGroupsClaims
+	if len(groups) == 0 {
+		groups = []string{defaultJWTGroupsClaim}
+	}
+
+	return &jwtAuthenticator{
+		keys:          keys,
+		issuer:        o.jwtIssuer,
+		audience:      o.jwtAudience,
+		usernameClaim: o.jwtUsernameClaim,
+		groupsClaims:  groups,
+		extraClaims:   extra,
+		now:           now,
+	}, nil
+}
+
+// loadJWTPublicKeys reads the public keys from a file of pem encoded public keys or certificates
+func loadJWTPublicKeys(filename string) ([]crypto.PublicKey, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwt public keys '%s': %s", filename, err)
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("jwt public keys '%s': only rsa and ecdsa keys are supported", filename)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwt public keys '%s': no public keys found", filename)
+	}
+
+	return keys, nil
+}
+
+// parseJWTExtraClaims parses the extra claim options in the format key=claim
+func parseJWTExtraClaims(specs []string) (map[string]string, error) {
+	extra := make(map[string]string, 0)
+	for _, x := range specs {
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return nil, fmt.Errorf("invalid jwt extra claim: %s, must be in the format key=claim", x)
+		}
+		// @note: the user.Info interface requires the keys are lowercase
+		extra[strings.ToLower(items[0])] = items[1]
+	}
+
+	return extra, nil
+}
+
+// AuthenticateToken verifies the token and maps the claims on to the user; a token which isn't
+// a json web token, or fails verification, is treated as not found so the other sources are asked
+func (j *jwtAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false, nil
+	}
+	claims, err := j.verify(parts)
+	if err != nil {
+		// @note: we never log the token itself
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Debug("the json web token failed verification")
+		return nil, false, nil
+	}
+	info, err := j.mapClaims(claims)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Debug("unable to map the json web token claims")
+		return nil, false, nil
+	}
+
+	return info, true, nil
+}
+
+// verify checks the signature and the registered claims, returning the claims
+func (j *jwtAuthenticator) verify(parts []string) (map[string]interface{}, error) {
+	header := new(jwtHeader)
+	if err := decodeJWTSegment(parts[0], header); err != nil {
+		return nil, fmt.Errorf("invalid header: %s", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err)
+	}
+	if err := j.verifySignature(header.Algorithm, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %s", err)
+	}
+	now := j.now()
+	expires, found := claims["exp"].(json.Number)
+	if !found {
+		return nil, errors.New("the token has no expiry")
+	}
+	if v, err := expires.Int64(); err != nil || !now.Before(time.Unix(v, 0)) {
+		return nil, errors.New("the token has expired")
+	}
+	if notBefore, found := claims["nbf"].(json.Number); found {
+		if v, err := notBefore.Int64(); err != nil || now.Before(time.Unix(v, 0)) {
+			return nil, errors.New("the token is not yet valid")
+		}
+	}
+	if j.issuer != "" && claims["iss"] != j.issuer {
+		return nil, fmt.Errorf("the token issuer %v is not trusted", claims["iss"])
+	}
+	if j.audience != "" && !containedIn(j.audience, claimValues(claims["aud"])) {
+		return nil, errors.New("the token is not for this audience")
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks the signature against each of the keys suitable for the algorithm
+func (j *jwtAuthenticator) verifySignature(algorithm, signed string, signature []byte) error {
+	hashed := sha256.Sum256([]byte(signed))
+	for _, key := range j.keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if algorithm == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, hashed[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			// @note: the signature is the r and s values concatenated, each the size of the curve
+			if algorithm == "ES256" && len(signature) == 64 {
+				r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(k, hashed[:], r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	switch algorithm {
+	case "RS256", "ES256":
+		return errors.New("the token signature is not valid")
+	}
+
+	return fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+}
+
+// mapClaims maps the claims on to the user
+func (j *jwtAuthenticator) mapClaims(claims map[string]interface{}) (user.Info, error) {
+	name, _ := claims[j.usernameClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("the token has no %s claim", j.usernameClaim)
+	}
+	subject, _ := claims["sub"].(string)
+	info := &user.DefaultInfo{Name: name, UID: subject}
+
+	for _, x := range j.groupsClaims {
+		for _, group := range claimValues(claims[x]) {
+			if !containedIn(group, info.Groups) {
+				info.Groups = append(info.Groups, group)
+			}
+		}
+	}
+	for key, claim := range j.extraClaims {
+		if values := claimValues(claims[claim]); len(values) > 0 {
+			if info.Extra == nil {
+				info.Extra = make(map[string][]string, 0)
+			}
+			info.Extra[key] = values
+		}
+	}
+
+	return info, nil
+}
+
+// claimValues converts a claim, a scalar or a list of scalars, into a list of strings
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case json.Number:
+		return []string{v.String()}
+	case bool:
+		return []string{fmt.Sprintf("%t", v)}
+	case []interface{}:
+		var list []string
+		for _, x := range v {
+			list = append(list, claimValues(x)...)
+		}
+		return list
+	}
+
+	return nil
+}
+
+// decodeJWTSegment decodes a base64url encoded json segment of the token
+func decodeJWTSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
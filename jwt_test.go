@@ -0,0 +1,234 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+)
+
+// testJWTKeys are the keys used to sign the test tokens
+type testJWTKeys struct {
+	rsa   *rsa.PrivateKey
+	ecdsa *ecdsa.PrivateKey
+	// filename is the file holding the public keys
+	filename string
+}
+
+func newTestJWTKeys(t *testing.T) *testJWTKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate the rsa key, error: %s", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate the ecdsa key, error: %s", err)
+	}
+	var content []byte
+	for _, x := range []crypto.PublicKey{&rsaKey.PublicKey, &ecdsaKey.PublicKey} {
+		encoded, err := x509.MarshalPKIXPublicKey(x)
+		if err != nil {
+			t.Fatalf("unable to encode the public key, error: %s", err)
+		}
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: encoded})...)
+	}
+	f, err := writeTestFile(string(content))
+	if err != nil {
+		t.Fatalf("unable to write the public keys, error: %s", err)
+	}
+
+	return &testJWTKeys{rsa: rsaKey, ecdsa: ecdsaKey, filename: f.Name()}
+}
+
+// sign creates a token with the claims signed by the algorithm
+func (k *testJWTKeys) sign(t *testing.T, algorithm string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch algorithm {
+	case "RS256":
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, hashed[:])
+		if err != nil {
+			t.Fatalf("unable to sign the token, error: %s", err)
+		}
+		signature = sig
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecdsa, hashed[:])
+		if err != nil {
+			t.Fatalf("unable to sign the token, error: %s", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		signature = []byte("signature")
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// tamperJWT returns the token with the claims taken from another token
+func tamperJWT(token, other string) string {
+	parts, claims := strings.Split(token, "."), strings.Split(other, ".")
+
+	return parts[0] + "." + claims[1] + "." + parts[2]
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	defer os.Remove(keys.filename)
+
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	j, err := newJWTAuthenticator(&options{
+		jwtPublicKeys:    keys.filename,
+		jwtIssuer:        "https://issuer.example.com",
+		jwtAudience:      "kube-auth",
+		jwtUsernameClaim: "email",
+		jwtGroupsClaims:  []string{"groups", "roles"},
+		jwtExtraClaims:   []string{"Team=team", "scopes=scp"},
+	}, func() time.Time { return now })
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    "https://issuer.example.com",
+			"aud":    []string{"other", "kube-auth"},
+			"sub":    "1234",
+			"email":  "jdoe@example.com",
+			"exp":    now.Add(time.Hour).Unix(),
+			"groups": []string{"dev", "platform"},
+			"roles":  "platform",
+			"team":   "platform",
+			"scp":    []string{"read", "write"},
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, algorithm := range []string{"RS256", "ES256"} {
+		info, found, err := j.AuthenticateToken(keys.sign(t, algorithm, claims(nil)))
+		assert.NoError(t, err)
+		if !assert.True(t, found, "algorithm: %s", algorithm) {
+			continue
+		}
+		assert.Equal(t, "jdoe@example.com", info.GetName())
+		assert.Equal(t, "1234", info.GetUID())
+		assert.Equal(t, []string{"dev", "platform"}, info.GetGroups())
+		assert.Equal(t, map[string][]string{"team": {"platform"}, "scopes": {"read", "write"}}, info.GetExtra())
+	}
+
+	cs := []struct {
+		Token string
+	}{
+		{Token: "token1"},
+		{Token: "a.b.c"},
+		{Token: keys.sign(t, "HS256", claims(nil))},
+		{Token: tamperJWT(keys.sign(t, "RS256", claims(nil)), keys.sign(t, "RS256", claims(map[string]interface{}{"email": "admin"})))},
+		{Token: keys.sign(t, "RS256", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}))},
+		{Token: keys.sign(t, "RS256", claims(map[string]interface{}{"exp": nil}))},
+		{Token: keys.sign(t, "RS256", claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}))},
+		{Token: keys.sign(t, "RS256", claims(map[string]interface{}{"iss": "https://other.example.com"}))},
+		{Token: keys.sign(t, "ES256", claims(map[string]interface{}{"aud": "other"}))},
+		{Token: keys.sign(t, "ES256", claims(map[string]interface{}{"email": nil}))},
+	}
+	for i, x := range cs {
+		_, found, err := j.AuthenticateToken(x.Token)
+		assert.NoError(t, err, "case %d", i)
+		assert.False(t, found, "case %d", i)
+	}
+}
+
+func TestNewJWTAuthenticatorErrors(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	defer os.Remove(keys.filename)
+	empty, err := writeTestFile("not a key")
+	if err != nil {
+		t.Fatalf("unable to write the file, error: %s", err)
+	}
+	defer os.Remove(empty.Name())
+
+	cs := []options{
+		{jwtPublicKeys: "does_not_exist"},
+		{jwtPublicKeys: empty.Name()},
+		{jwtPublicKeys: keys.filename, jwtExtraClaims: []string{"team"}},
+		{jwtPublicKeys: keys.filename, jwtExtraClaims: []string{"=team"}},
+	}
+	for i, x := range cs {
+		_, err := newJWTAuthenticator(&x, time.Now)
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+func TestAuthenticationJWT(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	defer os.Remove(keys.filename)
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		jwtPublicKeys:    keys.filename,
+		jwtUsernameClaim: "sub",
+		jwtExtraClaims:   []string{"team=team"},
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	token := keys.sign(t, "RS256", map[string]interface{}{
+		"sub":    "jdoe",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"dev"},
+		"team":   "platform",
+	})
+	status, err := makeTestAuthRequest(s.URL(), v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: token}})
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Authenticated)
+	assert.Equal(t, "jdoe", status.Status.User.Username)
+	assert.Equal(t, []string{"dev"}, status.Status.User.Groups)
+	assert.Equal(t, map[string]v1beta1.ExtraValue{"team": {"platform"}}, status.Status.User.Extra)
+
+	// step: the token file is still consulted
+	status, err = makeTestAuthRequest(s.URL(), v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: "token1"}})
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Authenticated)
+}
@@ -0,0 +1,174 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// unixScheme is the prefix of a unix domain socket listener, e.g. unix:///var/run/kube-auth.sock
+	unixScheme = "unix://"
+	// systemdScheme is the prefix of a listener passed by systemd, optionally followed by the fd name
+	systemdScheme = "systemd://"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+var listenFdsStart = 3
+
+// systemdSocket is a socket passed by systemd
+type systemdSocket struct {
+	// name is the FileDescriptorName of the socket
+	name string
+	// fd is the file descriptor, or -1 once it has been used
+	fd int
+}
+
+// createListener creates a listener for the address; a unix:// address is a unix domain socket
+// created with the given permissions, a systemd:// address is one of the sockets passed by systemd
+// and anything else is a tcp address
+func createListener(address string, mode os.FileMode, sockets []*systemdSocket) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, unixScheme):
+		return createUnixListener(strings.TrimPrefix(address, unixScheme), mode)
+	case strings.HasPrefix(address, systemdScheme):
+		return createSystemdListener(sockets, strings.TrimPrefix(address, systemdScheme))
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// createUnixListener creates a unix domain socket, removing any stale socket left behind
+func createUnixListener(filename string, mode os.FileMode) (net.Listener, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("no path for the unix socket")
+	}
+	if info, err := os.Lstat(filename); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("unable to create the unix socket, %s exists and is not a socket", filename)
+		}
+		if err := os.Remove(filename); err != nil {
+			return nil, err
+		}
+	}
+	// @note: the socket is created with the umask, so restrict it to the owner until the
+	// permissions have been set, else it's briefly open to everyone
+	previous := syscall.Umask(0177)
+	listener, err := net.Listen("unix", filename)
+	syscall.Umask(previous)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(filename, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// takeSystemdSockets returns the sockets passed by systemd (LISTEN_FDS) and unsets the environment
+// variables, so they are not inherited by any child processes
+func takeSystemdSockets() ([]*systemdSocket, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets have been passed by systemd to this process")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("no sockets have been passed by systemd, LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var sockets []*systemdSocket
+	for i := 0; i < count; i++ {
+		socket := &systemdSocket{fd: listenFdsStart + i}
+		if i < len(names) {
+			socket.name = names[i]
+		}
+		sockets = append(sockets, socket)
+	}
+
+	return sockets, nil
+}
+
+// createSystemdListener returns one of the sockets passed by systemd; if a name is given it must
+// match the FileDescriptorName of the socket, otherwise the first unused socket is used
+func createSystemdListener(sockets []*systemdSocket, name string) (net.Listener, error) {
+	for i, x := range sockets {
+		if x.fd < 0 || (name != "" && x.name != name) {
+			continue
+		}
+		file := os.NewFile(uintptr(x.fd), fmt.Sprintf("systemd:%d", i))
+		x.fd = -1
+		// @note: the listener holds a duplicate of the descriptor
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		return listener, nil
+	}
+
+	return nil, fmt.Errorf("no socket named %q has been passed by systemd", name)
+}
+
+// isLocalAddress checks the listener address is only reachable from the host, i.e. a unix socket
+// or a tcp address on the loopback interface; a socket passed by systemd is never assumed local
+func isLocalAddress(address string) bool {
+	switch {
+	case strings.HasPrefix(address, unixScheme):
+		return true
+	case strings.HasPrefix(address, systemdScheme):
+		return false
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// defaultSocketMode is the permissions of a unix socket if none are given
+const defaultSocketMode os.FileMode = 0660
+
+// parseSocketMode parses the octal permissions of a unix socket
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return defaultSocketMode, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("invalid socket mode: %s, must be octal permissions, e.g. 0660", mode)
+	}
+
+	return os.FileMode(value), nil
+}
@@ -0,0 +1,181 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSocketMode(t *testing.T) {
+	cs := []struct {
+		Mode     string
+		Expected os.FileMode
+		Error    bool
+	}{
+		{Mode: "", Expected: 0660},
+		{Mode: "0600", Expected: 0600},
+		{Mode: "660", Expected: 0660},
+		{Mode: "0999", Error: true},
+		{Mode: "01777", Error: true},
+		{Mode: "rw", Error: true},
+	}
+	for _, x := range cs {
+		mode, err := parseSocketMode(x.Mode)
+		if x.Error {
+			assert.Error(t, err, "mode: %s", x.Mode)
+			continue
+		}
+		assert.NoError(t, err, "mode: %s", x.Mode)
+		assert.Equal(t, x.Expected, mode, "mode: %s", x.Mode)
+	}
+}
+
+func TestIsLocalAddress(t *testing.T) {
+	cs := []struct {
+		Address  string
+		Expected bool
+	}{
+		{Address: "127.0.0.1:8081", Expected: true},
+		{Address: "[::1]:8081", Expected: true},
+		{Address: "localhost:8081", Expected: true},
+		{Address: "unix:///var/run/kube-auth.sock", Expected: true},
+		{Address: ":8081"},
+		{Address: "0.0.0.0:8081"},
+		{Address: "10.0.0.1:8081"},
+		{Address: "admin.example.com:8081"},
+		{Address: "systemd://admin"},
+		{Address: "bad"},
+	}
+	for _, x := range cs {
+		assert.Equal(t, x.Expected, isLocalAddress(x.Address), "address: %s", x.Address)
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "kube-auth.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("unable to create the directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "kube-auth.sock")
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{socketMode: "0600"})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	// step: a stale socket should be replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unable to create the stale socket, error: %s", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	if _, err := s.s.serve(unixScheme+socket, s.s.engine, "", "", ""); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	info, err := os.Stat(socket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, address string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+	resp, err := client.Get("http://kube-auth/health")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	// step: a regular file should never be removed
+	regular := filepath.Join(dir, "regular")
+	assert.NoError(t, ioutil.WriteFile(regular, []byte("keep"), 0644))
+	_, err = createListener(unixScheme+regular, 0660, nil)
+	assert.Error(t, err)
+}
+
+func TestSystemdListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create the listener, error: %s", err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("unable to get the listener file, error: %s", err)
+	}
+	defer file.Close()
+
+	// step: pretend the socket was passed by systemd as the second descriptor
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("unable to duplicate the descriptor, error: %s", err)
+	}
+	defer func(start int) { listenFdsStart = start }(listenFdsStart)
+	listenFdsStart = fd - 1
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "other:webhook")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	sockets, err := takeSystemdSockets()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Len(t, sockets, 2)
+	// @check the environment is not passed on to any child processes
+	assert.Empty(t, os.Getenv("LISTEN_PID"))
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+	assert.Empty(t, os.Getenv("LISTEN_FDNAMES"))
+
+	passed, err := createListener(systemdScheme+"webhook", 0, sockets)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer passed.Close()
+	assert.Equal(t, listener.Addr().String(), passed.Addr().String())
+
+	// @check a socket can only be used once
+	_, err = createListener(systemdScheme+"webhook", 0, sockets)
+	assert.Error(t, err)
+	_, err = createListener(systemdScheme+"not_there", 0, sockets)
+	assert.Error(t, err)
+
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "2")
+	_, err = takeSystemdSockets()
+	assert.Error(t, err)
+}
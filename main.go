@@ -22,6 +22,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -40,74 +41,384 @@ func main() {
 		return err
 	}
 
-	app.Flags = []cli.Flag{
+	app.Flags = newServerFlags(&opts)
+	app.Commands = []cli.Command{
+		newPolicyCommand(),
+		newReplayCommand(),
+		newWebhookConfigCommand(&opts),
+		newCertsCommand(),
+		newConfigCommand(&opts),
+		newBreakGlassCommand(),
+	}
+
+	// step: the default action to run
+	app.Action = func(cx *cli.Context) error {
+		// step: apply the configuration file and list options
+		if err := resolveOptions(cx, &opts); err != nil {
+			errorMessage(err.Error())
+		}
+
+		// step: create the service
+		s, err := newService(opts)
+		if err != nil {
+			errorMessage(fmt.Sprintf("unable to create service, error: %s", err))
+		}
+
+		// step: run the service
+		if err := s.run(); err != nil {
+			errorMessage(fmt.Sprintf("unable to run service, error: %s", err))
+		}
+
+		// step: wait for the termination signal
+		signalChannel := make(chan os.Signal, 1)
+		signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+		<-signalChannel
+
+		// step: report not ready while the load balancers catch up
+		s.drain()
+		time.Sleep(opts.shutdownDelay)
+
+		return nil
+	}
+
+	app.Run(os.Args)
+}
+
+// newServerFlags returns the options of the service, each can be set by environment variable
+func newServerFlags(opts *options) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "config",
+			EnvVar:      "KUBE_AUTH_CONFIG",
+			Usage:       "the path to a configuration file, command line options and environment variables take precedence",
+			Destination: &opts.configFile,
+		},
 		cli.StringFlag{
 			Name:        "listen",
-			Usage:       "the interface and port the service should bind",
+			EnvVar:      "KUBE_AUTH_LISTEN",
+			Usage:       "the interface and port the service should bind, unix:///path for a unix socket or systemd://[name] for a socket passed by systemd",
 			Value:       "127.0.0.1:8443",
 			Destination: &opts.listen,
 		},
+		cli.StringFlag{
+			Name:        "listen-socket-mode",
+			EnvVar:      "KUBE_AUTH_LISTEN_SOCKET_MODE",
+			Usage:       "the permissions of a unix socket listener, i.e. --listen=unix:///var/run/kube-auth.sock",
+			Value:       "0660",
+			Destination: &opts.socketMode,
+		},
 		cli.StringFlag{
 			Name:        "token-file",
+			EnvVar:      "KUBE_AUTH_TOKEN_FILE",
 			Usage:       "the path to the file containing the tokens",
 			Destination: &opts.tokenFile,
 		},
+		cli.StringSliceFlag{
+			Name:   "token-files",
+			EnvVar: "KUBE_AUTH_TOKEN_FILES",
+			Usage:  "an additional token file in the format path[?group=name&prefix=value], the group and prefix are applied to every token in the file, can be repeated",
+		},
 		cli.StringFlag{
 			Name:        "auth-policy",
+			EnvVar:      "KUBE_AUTH_AUTH_POLICY",
 			Usage:       "the path to the file containing the auth policy",
 			Destination: &opts.authFile,
 		},
+		cli.StringFlag{
+			Name:        "auth-policy-dir",
+			EnvVar:      "KUBE_AUTH_AUTH_POLICY_DIR",
+			Usage:       "the path to a directory of policy files (*.jsonl, *.yaml) merged in sorted order",
+			Destination: &opts.authDir,
+		},
+		cli.BoolFlag{
+			Name:        "auth-policy-dir-recursive",
+			EnvVar:      "KUBE_AUTH_AUTH_POLICY_DIR_RECURSIVE",
+			Usage:       "read the policy files in subdirectories of the policy directory as well",
+			Destination: &opts.authDirRecursive,
+		},
+		cli.StringFlag{
+			Name:        "shadow-policy",
+			EnvVar:      "KUBE_AUTH_SHADOW_POLICY",
+			Usage:       "the path to a policy file evaluated alongside the active policy, disagreements are logged but never affect the answer",
+			Destination: &opts.shadowPolicy,
+		},
+		cli.StringFlag{
+			Name:        "capture-file",
+			EnvVar:      "KUBE_AUTH_CAPTURE_FILE",
+			Usage:       "the path to a file to capture the review requests and responses to for replay, tokens are replaced by their hash",
+			Destination: &opts.captureFile,
+		},
+		cli.BoolFlag{
+			Name:        "explain-decisions",
+			EnvVar:      "KUBE_AUTH_EXPLAIN_DECISIONS",
+			Usage:       "return the matching policy rule, or the closest rules if none matched, as the reason of the review",
+			Destination: &opts.explainDecisions,
+		},
+		cli.IntFlag{
+			Name:        "policy-diff-history",
+			EnvVar:      "KUBE_AUTH_POLICY_DIFF_HISTORY",
+			Usage:       "the number of policy reload diffs kept for the admin endpoint",
+			Value:       10,
+			Destination: &opts.policyDiffHistory,
+		},
+		cli.StringFlag{
+			Name:        "identity-mapping",
+			EnvVar:      "KUBE_AUTH_IDENTITY_MAPPING",
+			Usage:       "the path to a file containing the username and group mapping rules",
+			Destination: &opts.mappingFile,
+		},
+		cli.StringFlag{
+			Name:        "token-webhook-config",
+			EnvVar:      "KUBE_AUTH_TOKEN_WEBHOOK_CONFIG",
+			Usage:       "the path to a kubeconfig file for an upstream token webhook, used for tokens not in the token file",
+			Destination: &opts.tokenWebhookConfig,
+		},
+		cli.DurationFlag{
+			Name:        "token-webhook-cache-ttl",
+			EnvVar:      "KUBE_AUTH_TOKEN_WEBHOOK_CACHE_TTL",
+			Usage:       "the duration to cache tokens authenticated by the upstream webhook",
+			Value:       2 * time.Minute,
+			Destination: &opts.tokenWebhookCacheTTL,
+		},
+		cli.DurationFlag{
+			Name:        "token-webhook-negative-cache-ttl",
+			EnvVar:      "KUBE_AUTH_TOKEN_WEBHOOK_NEGATIVE_CACHE_TTL",
+			Usage:       "the duration to cache tokens rejected by the upstream webhook",
+			Value:       30 * time.Second,
+			Destination: &opts.tokenWebhookNegativeTTL,
+		},
+		cli.StringFlag{
+			Name:        "jwt-public-keys",
+			EnvVar:      "KUBE_AUTH_JWT_PUBLIC_KEYS",
+			Usage:       "the path to a file of pem encoded public keys or certificates, used to verify json web tokens (RS256 or ES256)",
+			Destination: &opts.jwtPublicKeys,
+		},
+		cli.StringFlag{
+			Name:        "jwt-issuer",
+			EnvVar:      "KUBE_AUTH_JWT_ISSUER",
+			Usage:       "the issuer (iss claim) a json web token must have",
+			Destination: &opts.jwtIssuer,
+		},
+		cli.StringFlag{
+			Name:        "jwt-audience",
+			EnvVar:      "KUBE_AUTH_JWT_AUDIENCE",
+			Usage:       "the audience (aud claim) a json web token must be issued for",
+			Destination: &opts.jwtAudience,
+		},
+		cli.StringFlag{
+			Name:        "jwt-username-claim",
+			EnvVar:      "KUBE_AUTH_JWT_USERNAME_CLAIM",
+			Usage:       "the json web token claim the username is taken from",
+			Value:       "sub",
+			Destination: &opts.jwtUsernameClaim,
+		},
+		cli.StringSliceFlag{
+			Name:   "jwt-groups-claim",
+			EnvVar: "KUBE_AUTH_JWT_GROUPS_CLAIM",
+			Usage:  "a json web token claim the groups are taken from, can be repeated, defaults to groups",
+		},
+		cli.StringSliceFlag{
+			Name:   "jwt-extra-claim",
+			EnvVar: "KUBE_AUTH_JWT_EXTRA_CLAIM",
+			Usage:  "maps a json web token claim to an extra attribute in the format key=claim, can be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:   "authorizer",
+			EnvVar: "KUBE_AUTH_AUTHORIZER",
+			Usage:  "an additional authorization source in the format kind:path, kind being abac, abac-dir, rbac or webhook, can be repeated; sources are evaluated in order, naming a built-in source (break-glass, policy, policy-dir, delegation or webhook) places it at that position",
+		},
+		cli.StringFlag{
+			Name:        "audit-log",
+			EnvVar:      "KUBE_AUTH_AUDIT_LOG",
+			Usage:       "the path to a file to write the authorization audit log, - for stdout",
+			Destination: &opts.auditLog,
+		},
+		cli.BoolFlag{
+			Name:        "break-glass",
+			EnvVar:      "KUBE_AUTH_BREAK_GLASS",
+			Usage:       "enable the temporary break-glass grants on the admin listener, these are evaluated before any other authorizer",
+			Destination: &opts.breakGlass,
+		},
+		cli.StringFlag{
+			Name:        "break-glass-journal",
+			EnvVar:      "KUBE_AUTH_BREAK_GLASS_JOURNAL",
+			Usage:       "the path to a file the break-glass grants are journaled to, so they survive restarts",
+			Destination: &opts.breakGlassJournal,
+		},
+		cli.DurationFlag{
+			Name:        "break-glass-max-ttl",
+			EnvVar:      "KUBE_AUTH_BREAK_GLASS_MAX_TTL",
+			Usage:       "the longest lifetime of a break-glass grant",
+			Value:       4 * time.Hour,
+			Destination: &opts.breakGlassMaxTTL,
+		},
+		cli.StringFlag{
+			Name:        "break-glass-admin-group",
+			EnvVar:      "KUBE_AUTH_BREAK_GLASS_ADMIN_GROUP",
+			Usage:       "the group a caller must be a member of to list, give or revoke the break-glass grants",
+			Destination: &opts.breakGlassAdminGroup,
+		},
+		cli.StringFlag{
+			Name:        "delegation-policy",
+			EnvVar:      "KUBE_AUTH_DELEGATION_POLICY",
+			Usage:       "the path to the file defining the namespace owner groups and the ceiling of the access they may delegate",
+			Destination: &opts.delegationPolicy,
+		},
+		cli.StringFlag{
+			Name:        "delegation-grants",
+			EnvVar:      "KUBE_AUTH_DELEGATION_GRANTS",
+			Usage:       "the path to the file the namespace owner grants are stored in, managed by the service",
+			Destination: &opts.delegationGrants,
+		},
+		cli.StringFlag{
+			Name:        "authorization-webhook-config",
+			EnvVar:      "KUBE_AUTH_AUTHORIZATION_WEBHOOK_CONFIG",
+			Usage:       "the path to a kubeconfig file for an upstream authorization webhook, used for requests the policy does not allow",
+			Destination: &opts.authzWebhookConfig,
+		},
+		cli.DurationFlag{
+			Name:        "authorization-webhook-cache-authorized-ttl",
+			EnvVar:      "KUBE_AUTH_AUTHORIZATION_WEBHOOK_CACHE_AUTHORIZED_TTL",
+			Usage:       "the duration to cache allowed decisions from the upstream webhook",
+			Value:       5 * time.Minute,
+			Destination: &opts.authzWebhookAllowTTL,
+		},
+		cli.DurationFlag{
+			Name:        "authorization-webhook-cache-unauthorized-ttl",
+			EnvVar:      "KUBE_AUTH_AUTHORIZATION_WEBHOOK_CACHE_UNAUTHORIZED_TTL",
+			Usage:       "the duration to cache denied decisions from the upstream webhook",
+			Value:       30 * time.Second,
+			Destination: &opts.authzWebhookDenyTTL,
+		},
+		cli.DurationFlag{
+			Name:        "webhook-timeout",
+			EnvVar:      "KUBE_AUTH_WEBHOOK_TIMEOUT",
+			Usage:       "the timeout for requests to an upstream webhook",
+			Value:       5 * time.Second,
+			Destination: &opts.webhookTimeout,
+		},
+		cli.IntFlag{
+			Name:        "webhook-retries",
+			EnvVar:      "KUBE_AUTH_WEBHOOK_RETRIES",
+			Usage:       "the number of times to retry a failed request to an upstream webhook",
+			Value:       2,
+			Destination: &opts.webhookRetries,
+		},
 		cli.StringFlag{
 			Name:        "tls-cert",
+			EnvVar:      "KUBE_AUTH_TLS_CERT",
 			Usage:       "the path to a file containing the certificate to use",
 			Destination: &opts.tlsCert,
 		},
 		cli.StringFlag{
 			Name:        "tls-key",
+			EnvVar:      "KUBE_AUTH_TLS_KEY",
 			Usage:       "the path to a file containing the private key",
 			Destination: &opts.tlsKey,
 		},
 		cli.StringFlag{
 			Name:        "tls-ca",
+			EnvVar:      "KUBE_AUTH_TLS_CA",
 			Usage:       "the path to a file containing a CA certificate for client auth",
 			Destination: &opts.tlsCA,
 		},
+		cli.BoolFlag{
+			Name:        "tls-auto-generate",
+			EnvVar:      "KUBE_AUTH_TLS_AUTO_GENERATE",
+			Usage:       "generate a CA, the serving certificate and an apiserver client certificate if the tls cert and key don't exist",
+			Destination: &opts.tlsAutoGenerate,
+		},
+		cli.StringSliceFlag{
+			Name:   "tls-host",
+			EnvVar: "KUBE_AUTH_TLS_HOST",
+			Usage:  "a hostname or ip address added to a generated serving certificate, can be repeated",
+		},
+		cli.DurationFlag{
+			Name:        "tls-validity",
+			EnvVar:      "KUBE_AUTH_TLS_VALIDITY",
+			Usage:       "the validity period of the generated certificates",
+			Value:       certificateValidity,
+			Destination: &opts.tlsValidity,
+		},
+		cli.StringFlag{
+			Name:        "admin-listen",
+			EnvVar:      "KUBE_AUTH_ADMIN_LISTEN",
+			Usage:       "the interface and port for a separate listener serving the health, metrics, profiling and admin endpoints",
+			Destination: &opts.adminListen,
+		},
+		cli.StringFlag{
+			Name:        "admin-tls-cert",
+			EnvVar:      "KUBE_AUTH_ADMIN_TLS_CERT",
+			Usage:       "the path to a certificate for the admin listener, plain http if not set",
+			Destination: &opts.adminTLSCert,
+		},
+		cli.StringFlag{
+			Name:        "admin-tls-key",
+			EnvVar:      "KUBE_AUTH_ADMIN_TLS_KEY",
+			Usage:       "the path to the private key for the admin listener",
+			Destination: &opts.adminTLSKey,
+		},
+		cli.StringFlag{
+			Name:        "admin-tls-ca",
+			EnvVar:      "KUBE_AUTH_ADMIN_TLS_CA",
+			Usage:       "the path to a CA certificate for client auth on the admin listener",
+			Destination: &opts.adminTLSCA,
+		},
+		cli.DurationFlag{
+			Name:        "shutdown-delay",
+			EnvVar:      "KUBE_AUTH_SHUTDOWN_DELAY",
+			Usage:       "the time to keep serving, reporting not ready on /readyz, after receiving a termination signal",
+			Destination: &opts.shutdownDelay,
+		},
 		cli.BoolTFlag{
 			Name:        "disable-logging",
+			EnvVar:      "KUBE_AUTH_DISABLE_LOGGING",
 			Usage:       "disable all logging messages",
 			Destination: &opts.logging,
 		},
 		cli.BoolFlag{
 			Name:        "verbose",
+			EnvVar:      "KUBE_AUTH_VERBOSE",
 			Usage:       "switch on verbose logging",
 			Destination: &opts.verbose,
 		},
 	}
-	// step: the default action to run
-	app.Action = func(cx *cli.Context) error {
-		// step: create the service
-		s, err := newService(opts)
+}
+
+// resolveOptions applies the configuration file and the list options from the command line; the
+// global context is used so it works the same from the subcommands
+func resolveOptions(cx *cli.Context, opts *options) error {
+	if opts.configFile != "" {
+		values, err := loadConfigFile(opts.configFile)
 		if err != nil {
-			errorMessage(fmt.Sprintf("unable to create service, error: %s", err))
+			return err
 		}
-
-		// step: run the service
-		if err := s.run(); err != nil {
-			errorMessage(fmt.Sprintf("unable to run service, error: %s", err))
+		opts.configOverrides = configOverrides(cx.GlobalIsSet)
+		if err := applyConfig(globalFlagSetter{cx}, values, opts.configOverrides); err != nil {
+			return err
 		}
+		opts.configValues = values
+	}
+	opts.tokenFiles = cx.GlobalStringSlice("token-files")
+	opts.tlsHosts = certificateHosts(cx.GlobalStringSlice("tls-host"))
+	opts.authorizers = cx.GlobalStringSlice("authorizer")
+	opts.jwtGroupsClaims = cx.GlobalStringSlice("jwt-groups-claim")
+	opts.jwtExtraClaims = cx.GlobalStringSlice("jwt-extra-claim")
 
-		// step: wait for the termination signal
-		signalChannel := make(chan os.Signal)
-		signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-		<-signalChannel
+	return nil
+}
 
-		return nil
-	}
+// globalFlagSetter sets the options on the global flags
+type globalFlagSetter struct {
+	cx *cli.Context
+}
 
-	app.Run(os.Args)
+// Set sets the global option
+func (g globalFlagSetter) Set(name, value string) error {
+	return g.cx.GlobalSet(name, value)
 }
 
 func errorMessage(message string) {
-	fmt.Fprintf(os.Stderr, "[error] "+message)
+	fmt.Fprintf(os.Stderr, "[error] %s\n", message)
 	os.Exit(1)
 }
@@ -0,0 +1,210 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// mappingConfig is the file format for the identity mapping rules
+type mappingConfig struct {
+	// UsernameRewrites are regex rewrites applied in order to the username
+	UsernameRewrites []mappingRewrite `json:"usernameRewrites,omitempty"`
+	// GroupRewrites are regex rewrites applied in order to each group
+	GroupRewrites []mappingRewrite `json:"groupRewrites,omitempty"`
+	// DropGroups is a list of regexes, any group matching is removed
+	DropGroups []string `json:"dropGroups,omitempty"`
+	// GroupAdditions are static groups added to a user
+	GroupAdditions []mappingAddition `json:"groupAdditions,omitempty"`
+	// UsernamePrefix is a prefix added to the username
+	UsernamePrefix *mappingPrefix `json:"usernamePrefix,omitempty"`
+}
+
+// mappingRewrite is a regex rewrite
+type mappingRewrite struct {
+	// Match is the regex to match
+	Match string `json:"match"`
+	// Replace is the replacement, capture groups can be referenced by $1 etc
+	Replace string `json:"replace"`
+}
+
+// mappingAddition adds groups to a specific user
+type mappingAddition struct {
+	// User is the username (after rewrites) to add the groups to
+	User string `json:"user"`
+	// Groups is a list of groups to add
+	Groups []string `json:"groups"`
+}
+
+// mappingPrefix is prefix added to usernames
+type mappingPrefix struct {
+	// Prefix is the value to prefix the username with
+	Prefix string `json:"prefix"`
+	// Exclude is an optional regex, usernames matching are not prefixed
+	Exclude string `json:"exclude,omitempty"`
+}
+
+// identityMapping is a compiled set of mapping rules applied to authenticated users
+type identityMapping struct {
+	usernameRewrites []*compiledRewrite
+	groupRewrites    []*compiledRewrite
+	dropGroups       []*regexp.Regexp
+	groupAdditions   map[string][]string
+	prefix           string
+	prefixExclude    *regexp.Regexp
+}
+
+// compiledRewrite is a compiled regex rewrite
+type compiledRewrite struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// newIdentityMappingFromFile reads in and compiles the mapping rules
+func newIdentityMappingFromFile(filename string) (*identityMapping, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := new(mappingConfig)
+	if len(strings.TrimSpace(string(content))) > 0 {
+		if err := yaml.Unmarshal(content, config); err != nil {
+			return nil, fmt.Errorf("unable to decode mapping file %s, error: %s", filename, err)
+		}
+	}
+
+	return newIdentityMapping(config)
+}
+
+// newIdentityMapping compiles the mapping rules
+func newIdentityMapping(config *mappingConfig) (*identityMapping, error) {
+	var err error
+	m := &identityMapping{groupAdditions: make(map[string][]string, 0)}
+
+	if m.usernameRewrites, err = compileRewrites(config.UsernameRewrites); err != nil {
+		return nil, fmt.Errorf("invalid username rewrite, %s", err)
+	}
+	if m.groupRewrites, err = compileRewrites(config.GroupRewrites); err != nil {
+		return nil, fmt.Errorf("invalid group rewrite, %s", err)
+	}
+	for _, x := range config.DropGroups {
+		re, err := regexp.Compile(x)
+		if err != nil {
+			return nil, fmt.Errorf("invalid drop group regex: %s, error: %s", x, err)
+		}
+		m.dropGroups = append(m.dropGroups, re)
+	}
+	for _, x := range config.GroupAdditions {
+		if x.User == "" {
+			return nil, fmt.Errorf("group addition has no user")
+		}
+		m.groupAdditions[x.User] = append(m.groupAdditions[x.User], x.Groups...)
+	}
+	if config.UsernamePrefix != nil {
+		m.prefix = config.UsernamePrefix.Prefix
+		if config.UsernamePrefix.Exclude != "" {
+			if m.prefixExclude, err = regexp.Compile(config.UsernamePrefix.Exclude); err != nil {
+				return nil, fmt.Errorf("invalid username prefix exclude: %s", err)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// compileRewrites compiles a list of rewrites
+func compileRewrites(rewrites []mappingRewrite) ([]*compiledRewrite, error) {
+	var list []*compiledRewrite
+	for _, x := range rewrites {
+		if x.Match == "" {
+			return nil, fmt.Errorf("rewrite has no match")
+		}
+		re, err := regexp.Compile(x.Match)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %s, error: %s", x.Match, err)
+		}
+		list = append(list, &compiledRewrite{match: re, replace: x.Replace})
+	}
+
+	return list, nil
+}
+
+// apply maps the user; the order is username rewrites, group rewrites, group drops,
+// group additions (keyed on the rewritten username) and lastly the username prefix
+func (m *identityMapping) apply(info user.Info) user.Info {
+	username := info.GetName()
+	for _, x := range m.usernameRewrites {
+		if x.match.MatchString(username) {
+			username = x.match.ReplaceAllString(username, x.replace)
+		}
+	}
+
+	var groups []string
+	seen := make(map[string]bool, 0)
+	add := func(group string) {
+		if group == "" || seen[group] {
+			return
+		}
+		seen[group] = true
+		groups = append(groups, group)
+	}
+	for _, group := range info.GetGroups() {
+		for _, x := range m.groupRewrites {
+			if x.match.MatchString(group) {
+				group = x.match.ReplaceAllString(group, x.replace)
+			}
+		}
+		if m.isDropped(group) {
+			continue
+		}
+		add(group)
+	}
+	for _, group := range m.groupAdditions[username] {
+		add(group)
+	}
+
+	if m.prefix != "" && !strings.HasPrefix(username, m.prefix) {
+		if m.prefixExclude == nil || !m.prefixExclude.MatchString(username) {
+			username = m.prefix + username
+		}
+	}
+
+	return &user.DefaultInfo{
+		Name:   username,
+		UID:    info.GetUID(),
+		Groups: groups,
+		Extra:  info.GetExtra(),
+	}
+}
+
+// isDropped checks if the group should be removed
+func (m *identityMapping) isDropped(group string) bool {
+	for _, x := range m.dropGroups {
+		if x.MatchString(group) {
+			return true
+		}
+	}
+
+	return false
+}
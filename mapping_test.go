@@ -0,0 +1,144 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+const defaultTestMapping = `
+usernameRewrites:
+- match: '^(.*)@example\.com$'
+  replace: '$1'
+groupRewrites:
+- match: '^cn=([^,]+),ou=.*$'
+  replace: '$1'
+dropGroups:
+- '^ou=.*$'
+groupAdditions:
+- user: jdoe
+  groups: [oncall]
+usernamePrefix:
+  prefix: 'sso:'
+  exclude: '^system:'
+`
+
+func TestIdentityMappingApply(t *testing.T) {
+	f, err := writeTestFile(defaultTestMapping)
+	if err != nil {
+		t.Fatalf("failed to write the mapping file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	m, err := newIdentityMappingFromFile(f.Name())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	cs := []struct {
+		User     user.DefaultInfo
+		Expected user.DefaultInfo
+	}{
+		{
+			User:     user.DefaultInfo{Name: "user1", UID: "uid1"},
+			Expected: user.DefaultInfo{Name: "sso:user1", UID: "uid1"},
+		},
+		{
+			User:     user.DefaultInfo{Name: "system:kubelet"},
+			Expected: user.DefaultInfo{Name: "system:kubelet"},
+		},
+		{
+			User:     user.DefaultInfo{Name: "sso:user1"},
+			Expected: user.DefaultInfo{Name: "sso:user1"},
+		},
+		{
+			User: user.DefaultInfo{
+				Name:   "jdoe@example.com",
+				Groups: []string{"cn=platform-admins,ou=groups,dc=example", "ou=people", "dev", "cn=dev,ou=groups"},
+			},
+			Expected: user.DefaultInfo{
+				Name:   "sso:jdoe",
+				Groups: []string{"platform-admins", "dev", "oncall"},
+			},
+		},
+		{
+			User:     user.DefaultInfo{Name: "user2", Extra: map[string][]string{"team": {"platform"}}},
+			Expected: user.DefaultInfo{Name: "sso:user2", Extra: map[string][]string{"team": {"platform"}}},
+		},
+	}
+	for _, x := range cs {
+		info := x.User
+		assert.Equal(t, &x.Expected, m.apply(&info))
+	}
+}
+
+func TestNewIdentityMappingBad(t *testing.T) {
+	cs := []mappingConfig{
+		{UsernameRewrites: []mappingRewrite{{Match: "("}}},
+		{GroupRewrites: []mappingRewrite{{Replace: "test"}}},
+		{DropGroups: []string{"[a-"}},
+		{GroupAdditions: []mappingAddition{{Groups: []string{"test"}}}},
+		{UsernamePrefix: &mappingPrefix{Prefix: "sso:", Exclude: "("}},
+	}
+	for i, x := range cs {
+		_, err := newIdentityMapping(&x)
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+func TestIdentityMappingFileChange(t *testing.T) {
+	f, err := writeTestFile("")
+	if err != nil {
+		t.Fatalf("failed to write the mapping file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{mappingFile: f.Name()})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	request := v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: "token3"}}
+	updateTestFile(t, f.Name(), defaultTestMapping)
+	time.Sleep(800 * time.Millisecond)
+
+	status, err := makeTestAuthRequest(s.URL(), request)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, v1beta1.TokenReview{
+		TypeMeta: unversioned.TypeMeta{
+			APIVersion: "authentication.k8s.io/v1beta1",
+			Kind:       "TokenReview",
+		},
+		Status: v1beta1.TokenReviewStatus{
+			Authenticated: true,
+			User: v1beta1.UserInfo{
+				Username: "sso:user3",
+				UID:      "uuid3",
+				Groups:   []string{"group3"},
+			},
+		},
+	}, status)
+}
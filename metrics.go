@@ -0,0 +1,115 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricCounter is a monotonic counter with a set of labels, rendered in the prometheus text format
+type metricCounter struct {
+	sync.Mutex
+	// name is the metric name
+	name string
+	// help is the description of the metric
+	help string
+	// labels are the label names
+	labels []string
+	// values are the counts keyed on the label values
+	values map[string]float64
+}
+
+// newMetricCounter creates a counter
+func newMetricCounter(name, help string, labels ...string) *metricCounter {
+	return &metricCounter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64, 0),
+	}
+}
+
+// inc increments the counter for the label values
+func (c *metricCounter) inc(values ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.values[strings.Join(values, "\x00")]++
+}
+
+// get returns the current count for the label values
+func (c *metricCounter) get(values ...string) float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.values[strings.Join(values, "\x00")]
+}
+
+// write renders the counter in the prometheus text format
+func (c *metricCounter) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var pairs []string
+		for i, v := range strings.Split(k, "\x00") {
+			if i < len(c.labels) {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", c.labels[i], v))
+			}
+		}
+		if len(pairs) > 0 {
+			fmt.Fprintf(w, "%s{%s} %v\n", c.name, strings.Join(pairs, ","), c.values[k])
+			continue
+		}
+		fmt.Fprintf(w, "%s %v\n", c.name, c.values[k])
+	}
+}
+
+// serviceMetrics are the metrics exposed by the service
+type serviceMetrics struct {
+	// shadowEvaluations counts the shadow policy evaluations by outcome
+	shadowEvaluations *metricCounter
+}
+
+// newServiceMetrics creates the service metrics
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{
+		shadowEvaluations: newMetricCounter("kube_auth_shadow_evaluations_total",
+			"The number of requests evaluated against the shadow policy by outcome, including those dropped", "outcome"),
+	}
+}
+
+// write renders all the metrics in the prometheus text format
+func (m *serviceMetrics) write(w io.Writer) {
+	for _, x := range []*metricCounter{m.shadowEvaluations} {
+		x.write(w)
+	}
+}
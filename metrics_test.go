@@ -0,0 +1,42 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricCounter(t *testing.T) {
+	c := newMetricCounter("test_total", "A test counter", "code", "kind")
+	c.inc("200", "token")
+	c.inc("200", "token")
+	c.inc("500", "policy")
+	assert.Equal(t, float64(2), c.get("200", "token"))
+	assert.Equal(t, float64(0), c.get("404", "token"))
+
+	buf := new(bytes.Buffer)
+	c.write(buf)
+	assert.Equal(t, `# HELP test_total A test counter
+# TYPE test_total counter
+test_total{code="200",kind="token"} 2
+test_total{code="500",kind="policy"} 1
+`, buf.String())
+}
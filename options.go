@@ -17,33 +17,149 @@ limitations under the License.
 
 package main
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type options struct {
-	listen    string // the interface to bind service
-	tlsCert   string
-	tlsKey    string
-	tlsCA     string
-	tokenFile string
-	authFile  string
-	logging   bool
-	verbose   bool
+	listen      string // the interface to bind service
+	socketMode  string
+	tlsCert     string
+	tlsKey      string
+	tlsCA       string
+	tokenFile   string
+	authFile    string
+	mappingFile string
+	logging     bool
+	verbose     bool
+
+	// the token and policy options
+	tokenFiles        []string
+	authDir           string
+	authDirRecursive  bool
+	authorizers       []string
+	auditLog          string
+	shadowPolicy      string
+	policyDiffHistory int
+	explainDecisions  bool
+	captureFile       string
+
+	// the upstream webhook options
+	tokenWebhookConfig      string
+	tokenWebhookCacheTTL    time.Duration
+	tokenWebhookNegativeTTL time.Duration
+	authzWebhookConfig      string
+	authzWebhookAllowTTL    time.Duration
+	authzWebhookDenyTTL     time.Duration
+	webhookTimeout          time.Duration
+	webhookRetries          int
+
+	// the json web token options
+	jwtPublicKeys    string
+	jwtIssuer        string
+	jwtAudience      string
+	jwtUsernameClaim string
+	jwtGroupsClaims  []string
+	jwtExtraClaims   []string
+
+	// the certificate generation options
+	tlsAutoGenerate bool
+	tlsHosts        []string
+	tlsValidity     time.Duration
+
+	// the admin listener and lifecycle options
+	shutdownDelay time.Duration
+	adminListen   string
+	adminTLSCert  string
+	adminTLSKey   string
+	adminTLSCA    string
+
+	// the break-glass options
+	breakGlass        bool
+	breakGlassJournal string
+	breakGlassMaxTTL  time.Duration
+	// breakGlassAdminGroup is the group permitted to give and revoke the grants
+	breakGlassAdminGroup string
+
+	// the namespace owner delegation options
+	delegationPolicy string
+	delegationGrants string
+
+	// the configuration file options
+	configFile      string
+	configOverrides map[string]bool
+	configValues    configValues
 }
 
 // isValid check the options are valid
 func (o *options) isValid() error {
+	if problems := o.problems(); len(problems) > 0 {
+		return problems[0]
+	}
+
+	return nil
+}
+
+// problems returns all the problems with the options
+func (o *options) problems() []error {
+	var list []error
 	if o.listen == "" {
-		return errors.New("no listen")
+		list = append(list, errors.New("no listen"))
 	}
 	if o.tlsCert == "" {
-		return errors.New("no tls cert")
+		list = append(list, errors.New("no tls cert"))
 	}
 	if o.tlsKey == "" {
-		return errors.New("no tls key")
+		list = append(list, errors.New("no tls key"))
+	}
+	if o.tokenFile == "" && len(o.tokenFiles) == 0 && o.tokenWebhookConfig == "" && o.jwtPublicKeys == "" {
+		list = append(list, errors.New("no tokens file"))
 	}
-	if o.tokenFile == "" {
-		return errors.New("no tokens file")
+	if _, err := parseSocketMode(o.socketMode); err != nil {
+		list = append(list, err)
+	}
+	if (o.adminTLSCert == "") != (o.adminTLSKey == "") {
+		list = append(list, errors.New("the admin tls cert and key must be specified together"))
+	}
+	if o.adminTLSCA != "" && o.adminTLSCert == "" {
+		list = append(list, errors.New("the admin tls ca requires an admin tls cert"))
+	}
+	if o.adminListen != "" && o.adminTLSCert == "" && !isLocalAddress(o.adminListen) {
+		list = append(list, errors.New("the admin listener must use tls unless bound to a loopback address or unix socket"))
+	}
+	if o.breakGlass && o.adminListen == "" {
+		list = append(list, errors.New("the break-glass grants require an admin listener"))
+	}
+	if o.breakGlass && (o.adminTLSCert == "" || o.adminTLSKey == "" || o.adminTLSCA == "") {
+		list = append(list, errors.New("the break-glass grants require the admin listener to use tls with client certificates"))
+	}
+	if o.breakGlass && o.breakGlassAdminGroup == "" {
+		list = append(list, errors.New("the break-glass grants require an admin group"))
+	}
+	if (o.delegationPolicy == "") != (o.delegationGrants == "") {
+		list = append(list, errors.New("the delegation policy and grants file must be specified together"))
+	}
+	if o.delegationPolicy != "" && o.adminListen == "" {
+		list = append(list, errors.New("the delegation api requires an admin listener"))
+	}
+	if o.delegationPolicy != "" && (o.adminTLSCert == "" || o.adminTLSKey == "") {
+		list = append(list, errors.New("the delegation api requires the admin listener to use tls, as the owners send their tokens"))
+	}
+	for _, x := range o.tokenFiles {
+		if _, err := parseTokenFile(x); err != nil {
+			list = append(list, err)
+		}
+	}
+	if o.jwtPublicKeys != "" && o.jwtUsernameClaim == "" {
+		list = append(list, errors.New("the jwt username claim must be specified"))
+	}
+	if _, err := parseJWTExtraClaims(o.jwtExtraClaims); err != nil {
+		list = append(list, err)
+	}
+	if _, err := newAuthorizerSources(o); err != nil {
+		list = append(list, err)
 	}
 
-	return nil
+	return list
 }
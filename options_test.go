@@ -62,6 +62,138 @@ func TestOptionsIsValid(t *testing.T) {
 			},
 			Err: nil,
 		},
+		{
+			Opts: options{
+				listen:     "127.0.0.1:8080",
+				tlsCert:    "no_cert",
+				tlsKey:     "no_key",
+				tokenFiles: []string{"robots.csv?group=robots&prefix=robot:"},
+			},
+			Err: nil,
+		},
+		{
+			Opts: options{
+				listen:     "127.0.0.1:8080",
+				tlsCert:    "no_cert",
+				tlsKey:     "no_key",
+				tokenFiles: []string{"robots.csv?unknown=robots"},
+			},
+			Err: errors.New("invalid token file: robots.csv?unknown=robots, unknown option: unknown"),
+		},
+		{
+			Opts: options{
+				listen:       "127.0.0.1:8080",
+				tlsCert:      "no_cert",
+				tlsKey:       "no_key",
+				tokenFile:    "token_file",
+				adminListen:  "127.0.0.1:8081",
+				adminTLSCert: "admin_cert",
+			},
+			Err: errors.New("the admin tls cert and key must be specified together"),
+		},
+		{
+			Opts: options{
+				listen:      "127.0.0.1:8080",
+				tlsCert:     "no_cert",
+				tlsKey:      "no_key",
+				tokenFile:   "token_file",
+				adminListen: "0.0.0.0:8081",
+			},
+			Err: errors.New("the admin listener must use tls unless bound to a loopback address or unix socket"),
+		},
+		{
+			Opts: options{
+				listen:       "127.0.0.1:8080",
+				tlsCert:      "no_cert",
+				tlsKey:       "no_key",
+				tokenFile:    "token_file",
+				adminListen:  "0.0.0.0:8081",
+				adminTLSCert: "admin_cert",
+				adminTLSKey:  "admin_key",
+			},
+		},
+		{
+			Opts: options{
+				listen:      "127.0.0.1:8080",
+				tlsCert:     "no_cert",
+				tlsKey:      "no_key",
+				tokenFile:   "token_file",
+				adminListen: "127.0.0.1:8081",
+				adminTLSCA:  "admin_ca",
+			},
+			Err: errors.New("the admin tls ca requires an admin tls cert"),
+		},
+		{
+			Opts: options{
+				listen:     "127.0.0.1:8080",
+				tlsCert:    "no_cert",
+				tlsKey:     "no_key",
+				tokenFile:  "token_file",
+				breakGlass: true,
+			},
+			Err: errors.New("the break-glass grants require an admin listener"),
+		},
+		{
+			Opts: options{
+				listen:       "127.0.0.1:8080",
+				tlsCert:      "no_cert",
+				tlsKey:       "no_key",
+				tokenFile:    "token_file",
+				adminListen:  "127.0.0.1:8081",
+				adminTLSCert: "admin_cert",
+				adminTLSKey:  "admin_key",
+				breakGlass:   true,
+			},
+			Err: errors.New("the break-glass grants require the admin listener to use tls with client certificates"),
+		},
+		{
+			Opts: options{
+				listen:       "127.0.0.1:8080",
+				tlsCert:      "no_cert",
+				tlsKey:       "no_key",
+				tokenFile:    "token_file",
+				adminListen:  "127.0.0.1:8081",
+				adminTLSCert: "admin_cert",
+				adminTLSKey:  "admin_key",
+				adminTLSCA:   "admin_ca",
+				breakGlass:   true,
+			},
+			Err: errors.New("the break-glass grants require an admin group"),
+		},
+		{
+			Opts: options{
+				listen:           "127.0.0.1:8080",
+				tlsCert:          "no_cert",
+				tlsKey:           "no_key",
+				tokenFile:        "token_file",
+				adminListen:      "127.0.0.1:8081",
+				delegationPolicy: "delegation.yaml",
+			},
+			Err: errors.New("the delegation policy and grants file must be specified together"),
+		},
+		{
+			Opts: options{
+				listen:           "127.0.0.1:8080",
+				tlsCert:          "no_cert",
+				tlsKey:           "no_key",
+				tokenFile:        "token_file",
+				delegationPolicy: "delegation.yaml",
+				delegationGrants: "grants.json",
+			},
+			Err: errors.New("the delegation api requires an admin listener"),
+		},
+		{
+			Opts: options{
+				listen:           "127.0.0.1:8080",
+				tlsCert:          "no_cert",
+				tlsKey:           "no_key",
+				tokenFile:        "token_file",
+				adminListen:      "127.0.0.1:8081",
+				delegationPolicy: "delegation.yaml",
+				delegationGrants: "grants.json",
+			},
+			Err: errors.New("the delegation api requires the admin listener to use tls, as the owners send their tokens"),
+		},
 	}
 	for _, x := range cs {
 		err := x.Opts.isValid()
@@ -0,0 +1,613 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// abacAPIVersion is the api version of a versioned abac policy line
+	abacAPIVersion = "abac.authorization.kubernetes.io/v1beta1"
+	// abacKind is the kind of a versioned abac policy line
+	abacKind = "Policy"
+)
+
+// policy is a single rule from the policy file; it's a superset of the abac
+// policy with the addition of kube-auth specific attributes
+type policy struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Spec       policySpec `json:"spec"`
+	// file is the file the rule was read from
+	file string
+	// line is the line number of the rule within the file
+	line int
+}
+
+// policySpec is the specification of the rule
+type policySpec struct {
+	// User is the username this rule applies to, * matches all users
+	User string `json:"user,omitempty"`
+	// Group is the group this rule applies to, * matches all groups
+	Group string `json:"group,omitempty"`
+	// Readonly limits the rule to get, list and watch
+	Readonly bool `json:"readonly,omitempty"`
+	// Verbs limits the rule to the verbs, * matches all verbs
+	Verbs []string `json:"verbs,omitempty"`
+	// APIGroup is the api group name, * matches all groups
+	APIGroup string `json:"apiGroup,omitempty"`
+	// Resource is the resource name, * matches all resources
+	Resource string `json:"resource,omitempty"`
+	// Namespace is the namespace name, * matches all namespaces
+	Namespace string `json:"namespace,omitempty"`
+	// Subresources are the subresources in the format resource/subresource, i.e. pods/log, the
+	// rule permits; if empty a rule for the resource permits all the subresources
+	Subresources []string `json:"subresources,omitempty"`
+	// Name is the name of the resource, empty matches all names
+	Name string `json:"name,omitempty"`
+	// NonResourcePath is the non resource request path, * matches all paths
+	NonResourcePath string `json:"nonResourcePath,omitempty"`
+	// Extra is a map of user extra attributes which must be present, * matches any value
+	Extra map[string]string `json:"extra,omitempty"`
+	// NotBefore is the time the rule starts to apply
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// NotAfter is the time the rule stops applying
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// Schedule are recurring windows in which the rule applies, any of the windows is sufficient
+	Schedule []*policyWindow `json:"schedule,omitempty"`
+}
+
+// unversionedPolicy is the legacy v0 abac format
+type unversionedPolicy struct {
+	User      string `json:"user,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Readonly  bool   `json:"readonly,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// policyList is a collection of rules, see policyAuthorizer for the authorization interface
+type policyList []*policy
+
+// newPolicyFromFile reads in the policy file; the file format is one rule per line,
+// blank lines and lines starting with a # are ignored
+func newPolicyFromFile(filename string) (policyList, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var list policyList
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		trimmed := strings.TrimSpace(scanner.Text())
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		p, err := decodePolicy([]byte(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("error reading policy file %s, line %d: %s: %s", filename, line, trimmed, err)
+		}
+		p.file, p.line = filename, line
+		list = append(list, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading policy file %s: %s", filename, err)
+	}
+
+	return list, nil
+}
+
+// newPolicyFromYAMLFile reads in a yaml policy file; the file is a stream of documents
+// separated by ---, each either a single rule or a list of rules
+func newPolicyFromYAMLFile(filename string) (policyList, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var list policyList
+	var document []string
+	start := 1
+	flush := func() error {
+		defer func() { document = nil }()
+		if isEmptyYAML(document) {
+			return nil
+		}
+
+		encoded, err := yaml.YAMLToJSON([]byte(strings.Join(document, "\n")))
+		if err != nil {
+			return fmt.Errorf("error reading policy file %s, line %d: %s", filename, start, err)
+		}
+		trimmed := bytes.TrimSpace(encoded)
+		if len(trimmed) == 0 || string(trimmed) == "null" {
+			return nil
+		}
+		items := []json.RawMessage{trimmed}
+		if trimmed[0] == '[' {
+			if err := json.Unmarshal(trimmed, &items); err != nil {
+				return fmt.Errorf("error reading policy file %s, line %d: %s", filename, start, err)
+			}
+		}
+		lines := yamlItemLines(document, start, len(items))
+		for i, x := range items {
+			p, err := decodePolicy(x)
+			if err != nil {
+				return fmt.Errorf("error reading policy file %s, line %d, item %d: %s", filename, lines[i], i, err)
+			}
+			p.file, p.line = filename, lines[i]
+			list = append(list, p)
+		}
+
+		return nil
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "---" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			start = i + 2
+			continue
+		}
+		document = append(document, line)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// yamlItemLines returns the line number of each item in the document; if the top level items
+// can't be located every item is given the first line of the content
+func yamlItemLines(document []string, start, count int) []int {
+	first, indent := -1, -1
+	var found []int
+	for i, x := range document {
+		trimmed := strings.TrimLeft(x, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if first < 0 {
+			first = start + i
+		}
+		if !strings.HasPrefix(trimmed, "- ") && trimmed != "-" {
+			continue
+		}
+		switch depth := len(x) - len(trimmed); {
+		case indent < 0 || depth < indent:
+			indent, found = depth, []int{start + i}
+		case depth == indent:
+			found = append(found, start+i)
+		}
+	}
+
+	lines := make([]int, count)
+	for i := range lines {
+		lines[i] = first
+		if len(found) == count {
+			lines[i] = found[i]
+		}
+	}
+
+	return lines
+}
+
+// isEmptyYAML checks if the document only contains blank lines and comments
+func isEmptyYAML(lines []string) bool {
+	for _, x := range lines {
+		trimmed := strings.TrimSpace(x)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newPolicyFromDirectory reads in all the policy files in a directory in sorted order; if any
+// of the files is invalid the whole directory is rejected
+func newPolicyFromDirectory(dir string, recursive bool) (policyList, error) {
+	files, err := listPolicyFiles(dir, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	var list policyList
+	for _, x := range files {
+		var rules policyList
+		switch filepath.Ext(x) {
+		case ".jsonl":
+			rules, err = newPolicyFromFile(x)
+		default:
+			rules, err = newPolicyFromYAMLFile(x)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rules...)
+	}
+
+	return list, nil
+}
+
+// listPolicyFiles returns a sorted list of the policy files in the directory, hidden files
+// and directories are ignored
+func listPolicyFiles(dir string, recursive bool) ([]string, error) {
+	return listFiles(dir, recursive, isPolicyFile)
+}
+
+// listFiles returns a sorted list of the files in the directory accepted by the filter, hidden
+// directories are ignored
+func listFiles(dir string, recursive bool, filter func(string) bool) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && (!recursive || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filter(path) {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// computeDirectorySum gets the md5 sum of the names and contents of the files in a directory
+// accepted by the filter
+func computeDirectorySum(dir string, recursive bool, filter func(string) bool) ([16]byte, error) {
+	files, err := listFiles(dir, recursive, filter)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	hash := md5.New()
+	for _, x := range files {
+		content, err := ioutil.ReadFile(x)
+		if err != nil {
+			return [16]byte{}, err
+		}
+		hash.Write([]byte(x + "\x00"))
+		hash.Write(content)
+	}
+
+	var sum [16]byte
+	copy(sum[:], hash.Sum(nil))
+
+	return sum, nil
+}
+
+// isPolicyFile checks if the file should be read from a policy directory
+func isPolicyFile(filename string) bool {
+	if strings.HasPrefix(filepath.Base(filename), ".") {
+		return false
+	}
+	switch filepath.Ext(filename) {
+	case ".jsonl", ".yaml", ".yml":
+		return true
+	}
+
+	return false
+}
+
+// decodePolicy decodes a single rule, converting the unversioned format if required
+func decodePolicy(content []byte) (*policy, error) {
+	p := new(policy)
+	if err := json.Unmarshal(content, p); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.APIVersion == "" && p.Kind == "":
+		legacy := new(unversionedPolicy)
+		if err := json.Unmarshal(content, legacy); err != nil {
+			return nil, err
+		}
+		p = convertUnversionedPolicy(legacy)
+	case p.APIVersion == abacAPIVersion && p.Kind == abacKind:
+	default:
+		return nil, fmt.Errorf("unrecognized policy, apiVersion: %q, kind: %q", p.APIVersion, p.Kind)
+	}
+	if err := p.validatePatterns(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// convertUnversionedPolicy converts a v0 rule using the same defaults as the abac authorizer
+func convertUnversionedPolicy(in *unversionedPolicy) *policy {
+	p := &policy{
+		APIVersion: abacAPIVersion,
+		Kind:       abacKind,
+		Spec: policySpec{
+			User:      in.User,
+			Group:     in.Group,
+			Namespace: in.Namespace,
+			Resource:  in.Resource,
+			Readonly:  in.Readonly,
+			APIGroup:  "*",
+		},
+	}
+	if in.User == "" && in.Group == "" {
+		p.Spec.User = "*"
+	}
+	if in.Namespace == "" {
+		p.Spec.Namespace = "*"
+	}
+	if in.Resource == "" {
+		p.Spec.Resource = "*"
+	}
+	if in.Namespace == "" && in.Resource == "" {
+		p.Spec.NonResourcePath = "*"
+	}
+
+	return p
+}
+
+// policyAuthorizer implements the authorization interface for the rules, evaluating any time
+// conditions against the clock it was created with
+type policyAuthorizer struct {
+	// rules are the policy rules in order
+	rules policyList
+	// now returns the current time
+	now func() time.Time
+}
+
+// newPolicyAuthorizer creates an authorizer for the rules
+func newPolicyAuthorizer(rules policyList, now func() time.Time) *policyAuthorizer {
+	return &policyAuthorizer{rules: rules, now: now}
+}
+
+// Authorize checks the attributes against the rules
+func (p *policyAuthorizer) Authorize(a authorizer.Attributes) (bool, string, error) {
+	return p.rules.authorize(a, p.now())
+}
+
+// Explain checks the attributes against the rules, describing the matching rule or the near misses
+func (p *policyAuthorizer) Explain(a authorizer.Attributes) (decision, string, error) {
+	return p.rules.explain(a, p.now())
+}
+
+// authorize checks the attributes against the rules at the given time
+func (pl policyList) authorize(a authorizer.Attributes, now time.Time) (bool, string, error) {
+	for _, p := range pl {
+		if p.matches(a, now) {
+			return true, "", nil
+		}
+	}
+
+	return false, "No policy matched.", nil
+}
+
+// explain checks the attributes against the rules at the given time, describing the matching rule
+// or if none matched the closest near misses
+func (pl policyList) explain(a authorizer.Attributes, now time.Time) (decision, string, error) {
+	var misses nearMisses
+	for _, p := range pl {
+		mismatches := p.mismatches(a, now)
+		if len(mismatches) == 0 {
+			return decisionAllow, "allowed by " + p.location(), nil
+		}
+		misses = append(misses, nearMiss{rule: p, mismatches: mismatches})
+	}
+	if len(misses) == 0 {
+		return decisionNoOpinion, "no rule matched", nil
+	}
+
+	sort.Stable(misses)
+	var closest []string
+	for i := 0; i < len(misses) && i < maxNearMisses; i++ {
+		closest = append(closest, fmt.Sprintf("%s (%s)", misses[i].rule.location(), strings.Join(misses[i].mismatches, ", ")))
+	}
+
+	return decisionNoOpinion, "no rule matched, closest: " + strings.Join(closest, "; "), nil
+}
+
+// nearMiss is a rule which did not match the request
+type nearMiss struct {
+	rule       *policy
+	mismatches []string
+}
+
+// nearMisses sorts the rules closest to matching first; rules for the subject are closer than
+// rules for others, then those with the fewest mismatches
+type nearMisses []nearMiss
+
+func (n nearMisses) Len() int      { return len(n) }
+func (n nearMisses) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n nearMisses) Less(i, j int) bool {
+	si, sj := n[i].mismatches[0] == "subject", n[j].mismatches[0] == "subject"
+	if si != sj {
+		return sj
+	}
+
+	return len(n[i].mismatches) < len(n[j].mismatches)
+}
+
+// maxNearMisses is the number of near miss rules given when no rule matched
+const maxNearMisses = 3
+
+// location returns where the rule was defined
+func (p *policy) location() string {
+	if p.file == "" {
+		return fmt.Sprintf("%s rule", p.subject())
+	}
+
+	return fmt.Sprintf("%s:%d", p.file, p.line)
+}
+
+// matches checks if the rule matches the attributes at the given time
+func (p *policy) matches(a authorizer.Attributes, now time.Time) bool {
+	return len(p.mismatches(a, now)) == 0
+}
+
+// mismatches returns the attributes of the request the rule does not match at the given time;
+// the subject is always first if it does not match
+func (p *policy) mismatches(a authorizer.Attributes, now time.Time) []string {
+	var failed []string
+	if !p.subjectMatches(a) {
+		failed = append(failed, "subject")
+	}
+	if !p.verbMatches(a) {
+		failed = append(failed, "readonly")
+	}
+	if !p.verbsMatch(a) {
+		failed = append(failed, "verb")
+	}
+	if p.hasTimeConditions() && !p.activeAt(now) {
+		failed = append(failed, "time")
+	}
+
+	// @note: resource and non-resource requests are mutually exclusive
+	if !a.IsResourceRequest() {
+		if !p.nonResourceMatches(a) {
+			failed = append(failed, "path")
+		}
+		return failed
+	}
+	if !patternMatches(p.Spec.Namespace, a.GetNamespace()) {
+		failed = append(failed, "namespace")
+	}
+	switch {
+	case a.GetSubresource() != "" && len(p.Spec.Subresources) > 0:
+		if !p.subresourceMatches(a) {
+			failed = append(failed, "subresource")
+		}
+	case !patternMatches(p.Spec.Resource, a.GetResource()):
+		failed = append(failed, "resource")
+	}
+	if !patternMatches(p.Spec.APIGroup, a.GetAPIGroup()) {
+		failed = append(failed, "apiGroup")
+	}
+	if p.Spec.Name != "" && !patternMatches(p.Spec.Name, a.GetName()) {
+		failed = append(failed, "name")
+	}
+
+	return failed
+}
+
+// subjectMatches checks the user, group and extra attributes of the rule
+func (p *policy) subjectMatches(a authorizer.Attributes) bool {
+	matched := false
+
+	var username string
+	var groups []string
+	var extra map[string][]string
+	if u := a.GetUser(); u != nil {
+		username = u.GetName()
+		groups = u.GetGroups()
+		extra = u.GetExtra()
+	}
+
+	if p.Spec.User != "" {
+		if p.Spec.User != "*" && p.Spec.User != username {
+			return false
+		}
+		matched = true
+	}
+	if p.Spec.Group != "" {
+		if p.Spec.Group != "*" && !containedIn(p.Spec.Group, groups) {
+			return false
+		}
+		matched = true
+	}
+	for k, v := range p.Spec.Extra {
+		values, found := extra[k]
+		if !found {
+			return false
+		}
+		if v != "*" && !containedIn(v, values) {
+			return false
+		}
+	}
+
+	return matched
+}
+
+// verbMatches checks the rule permits the verb
+func (p *policy) verbMatches(a authorizer.Attributes) bool {
+	return a.IsReadOnly() || !p.Spec.Readonly
+}
+
+// verbsMatch checks the verb is in the verbs of the rule, if any are given
+func (p *policy) verbsMatch(a authorizer.Attributes) bool {
+	if len(p.Spec.Verbs) == 0 {
+		return true
+	}
+
+	return containedIn("*", p.Spec.Verbs) || containedIn(a.GetVerb(), p.Spec.Verbs)
+}
+
+// subresourceMatches checks the resource and subresource of the request against the subresources
+func (p *policy) subresourceMatches(a authorizer.Attributes) bool {
+	for _, x := range p.Spec.Subresources {
+		if patternMatches(x, a.GetResource()+"/"+a.GetSubresource()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nonResourceMatches checks the path of a non resource request, a trailing * is a prefix match
+func (p *policy) nonResourceMatches(a authorizer.Attributes) bool {
+	if a.IsResourceRequest() {
+		return false
+	}
+
+	return patternMatches(p.Spec.NonResourcePath, a.GetPath())
+}
+
+// containedIn checks if a value is in the list
+func containedIn(value string, list []string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,325 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policyDiff is a semantic difference between two sets of rules
+type policyDiff struct {
+	// Timestamp is the time the diff was computed
+	Timestamp time.Time `json:"timestamp"`
+	// Source is the name of the authorization source which was reloaded
+	Source string `json:"source,omitempty"`
+	// Added are the rules only in the new policy
+	Added []*policy `json:"added,omitempty"`
+	// Removed are the rules only in the old policy
+	Removed []*policy `json:"removed,omitempty"`
+	// Changed are the rules for the same subject which were altered
+	Changed []*policyChange `json:"changed,omitempty"`
+	// Gained is the access subjects have under the new policy but not the old
+	Gained []*policyAccess `json:"gained,omitempty"`
+	// Lost is the access subjects had under the old policy but not the new
+	Lost []*policyAccess `json:"lost,omitempty"`
+}
+
+// policyChange is a rule for a subject which was altered
+type policyChange struct {
+	// Before is the rule in the old policy
+	Before *policy `json:"before"`
+	// After is the rule in the new policy
+	After *policy `json:"after"`
+}
+
+// policyAccess is a grant of access to a subject
+type policyAccess struct {
+	// Subject is the user or group, e.g. user:jdoe
+	Subject string `json:"subject"`
+	// Access is a description of the access, e.g. all namespace=dev apiGroup=* resource=pods
+	Access string `json:"access"`
+}
+
+// empty checks if there are no differences
+func (d *policyDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a human readable report of the diff
+func (d *policyDiff) String() string {
+	if d.empty() {
+		return "no changes\n"
+	}
+	b := new(bytes.Buffer)
+	for _, x := range d.Added {
+		fmt.Fprintf(b, "+ %s\n", x.describe())
+	}
+	for _, x := range d.Removed {
+		fmt.Fprintf(b, "- %s\n", x.describe())
+	}
+	for _, x := range d.Changed {
+		fmt.Fprintf(b, "~ %s\n    => %s\n", x.Before.describe(), x.After.describe())
+	}
+	for _, x := range d.Gained {
+		fmt.Fprintf(b, "gained: %s %s\n", x.Subject, x.Access)
+	}
+	for _, x := range d.Lost {
+		fmt.Fprintf(b, "lost: %s %s\n", x.Subject, x.Access)
+	}
+
+	return b.String()
+}
+
+// diffPolicies computes the rules added, removed and changed between the old and new rules,
+// and the access gained and lost by each subject
+func diffPolicies(old, updated policyList) *policyDiff {
+	diff := &policyDiff{Timestamp: time.Now().UTC()}
+
+	// step: find the rules which only exist on one side
+	counts := make(map[string]int, 0)
+	for _, x := range old {
+		counts[x.key()]++
+	}
+	var added, removed policyList
+	for _, x := range updated {
+		if counts[x.key()] > 0 {
+			counts[x.key()]--
+			continue
+		}
+		added = append(added, x)
+	}
+	for _, x := range old {
+		if counts[x.key()] > 0 {
+			counts[x.key()]--
+			removed = append(removed, x)
+		}
+	}
+
+	// step: pair up removed and added rules for the same subject as changes
+	paired := make(map[*policy]bool, 0)
+	for _, x := range removed {
+		for _, y := range added {
+			if paired[y] || x.subject() != y.subject() {
+				continue
+			}
+			diff.Changed = append(diff.Changed, &policyChange{Before: x, After: y})
+			paired[x], paired[y] = true, true
+			break
+		}
+	}
+	for _, x := range added {
+		if !paired[x] {
+			diff.Added = append(diff.Added, x)
+		}
+	}
+	for _, x := range removed {
+		if !paired[x] {
+			diff.Removed = append(diff.Removed, x)
+		}
+	}
+
+	// step: compare the access of the subjects
+	before, after := old.access(), updated.access()
+	for _, x := range sortedAccess(after) {
+		if !x.coveredBy(before) {
+			diff.Gained = append(diff.Gained, x)
+		}
+	}
+	for _, x := range sortedAccess(before) {
+		if !x.coveredBy(after) {
+			diff.Lost = append(diff.Lost, x)
+		}
+	}
+
+	return diff
+}
+
+// access returns the set of access granted by the rules
+func (pl policyList) access() map[string]*policyAccess {
+	access := make(map[string]*policyAccess, 0)
+	for _, x := range pl {
+		subject := x.subject()
+		for _, grant := range x.grants() {
+			access[subject+" "+grant] = &policyAccess{Subject: subject, Access: grant}
+		}
+	}
+
+	return access
+}
+
+// coveredBy checks if the access is in the set; read access is covered by all access to the same resources
+func (a *policyAccess) coveredBy(access map[string]*policyAccess) bool {
+	if _, found := access[a.Subject+" "+a.Access]; found {
+		return true
+	}
+	if strings.HasPrefix(a.Access, "read ") {
+		_, found := access[a.Subject+" all "+strings.TrimPrefix(a.Access, "read ")]
+		return found
+	}
+
+	return false
+}
+
+// sortedAccess returns the access in a stable order
+func sortedAccess(access map[string]*policyAccess) []*policyAccess {
+	keys := make([]string, 0, len(access))
+	for k := range access {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]*policyAccess, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, access[k])
+	}
+
+	return list
+}
+
+// key returns a canonical representation of the rule
+func (p *policy) key() string {
+	// @note: the map keys of the extra attributes are sorted by the encoder
+	encoded, _ := json.Marshal(p.Spec)
+
+	return string(encoded)
+}
+
+// subject returns a description of who the rule applies to
+func (p *policy) subject() string {
+	var items []string
+	if p.Spec.User != "" {
+		items = append(items, "user:"+p.Spec.User)
+	}
+	if p.Spec.Group != "" {
+		items = append(items, "group:"+p.Spec.Group)
+	}
+	var extra []string
+	for k, v := range p.Spec.Extra {
+		extra = append(extra, k+"="+v)
+	}
+	sort.Strings(extra)
+	if len(extra) > 0 {
+		items = append(items, "extra:"+strings.Join(extra, ","))
+	}
+
+	return strings.Join(items, " ")
+}
+
+// grants returns a description of the access given by the rule
+func (p *policy) grants() []string {
+	verbs := "all"
+	if p.Spec.Readonly {
+		verbs = "read"
+	}
+	if len(p.Spec.Verbs) > 0 {
+		verbs = "verbs=" + strings.Join(p.Spec.Verbs, ",")
+		if p.Spec.Readonly {
+			verbs = "read " + verbs
+		}
+	}
+	var grants []string
+	if p.Spec.Resource != "" || len(p.Spec.Subresources) > 0 {
+		grant := fmt.Sprintf("%s namespace=%s apiGroup=%s resource=%s", verbs, p.Spec.Namespace, p.Spec.APIGroup, p.Spec.Resource)
+		if len(p.Spec.Subresources) > 0 {
+			grant += " subresources=" + strings.Join(p.Spec.Subresources, ",")
+		}
+		if p.Spec.Name != "" {
+			grant += " name=" + p.Spec.Name
+		}
+		grants = append(grants, grant)
+	}
+	if p.Spec.NonResourcePath != "" {
+		grants = append(grants, fmt.Sprintf("%s path=%s", verbs, p.Spec.NonResourcePath))
+	}
+	if conditions := p.conditions(); conditions != "" {
+		for i := range grants {
+			grants[i] += " " + conditions
+		}
+	}
+
+	return grants
+}
+
+// describe returns a single line description of the rule
+func (p *policy) describe() string {
+	return fmt.Sprintf("%s: %s", p.subject(), strings.Join(p.grants(), ", "))
+}
+
+// policyDiffHistory keeps the diffs of the last N reloads
+type policyDiffHistory struct {
+	sync.Mutex
+	size  int
+	items []*policyDiff
+}
+
+// newPolicyDiffHistory creates a history of the given size
+func newPolicyDiffHistory(size int) *policyDiffHistory {
+	return &policyDiffHistory{size: size}
+}
+
+// add records a diff, dropping the oldest if full
+func (h *policyDiffHistory) add(diff *policyDiff) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.size <= 0 {
+		return
+	}
+	h.items = append(h.items, diff)
+	if len(h.items) > h.size {
+		h.items = h.items[len(h.items)-h.size:]
+	}
+}
+
+// list returns the diffs, newest first
+func (h *policyDiffHistory) list() []*policyDiff {
+	h.Lock()
+	defer h.Unlock()
+
+	list := make([]*policyDiff, 0, len(h.items))
+	for i := len(h.items) - 1; i >= 0; i-- {
+		list = append(list, h.items[i])
+	}
+
+	return list
+}
+
+// loadPolicyPath reads in a policy file or directory, used by the offline tooling
+func loadPolicyPath(path string, recursive bool) (policyList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return newPolicyFromDirectory(path, recursive)
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return newPolicyFromYAMLFile(path)
+	}
+
+	return newPolicyFromFile(path)
+}
@@ -0,0 +1,133 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	old := policyList{
+		{Spec: policySpec{User: "jdoe", Namespace: "dev", Resource: "pods", APIGroup: "*"}},
+		{Spec: policySpec{User: "ops", Namespace: "*", Resource: "*", APIGroup: "*"}},
+		{Spec: policySpec{Group: "dev", Namespace: "dev", Resource: "*", APIGroup: "*"}},
+	}
+	updated := policyList{
+		{Spec: policySpec{Group: "dev", Namespace: "dev", Resource: "*", APIGroup: "*"}},
+		{Spec: policySpec{User: "jdoe", Namespace: "dev", Resource: "pods", APIGroup: "*", Readonly: true}},
+		{Spec: policySpec{Group: "qa", NonResourcePath: "/healthz"}},
+	}
+
+	diff := diffPolicies(old, updated)
+	assert.False(t, diff.empty())
+	if assert.Len(t, diff.Added, 1) {
+		assert.Equal(t, "qa", diff.Added[0].Spec.Group)
+	}
+	if assert.Len(t, diff.Removed, 1) {
+		assert.Equal(t, "ops", diff.Removed[0].Spec.User)
+	}
+	if assert.Len(t, diff.Changed, 1) {
+		assert.False(t, diff.Changed[0].Before.Spec.Readonly)
+		assert.True(t, diff.Changed[0].After.Spec.Readonly)
+	}
+	assert.Equal(t, []*policyAccess{
+		{Subject: "group:qa", Access: "all path=/healthz"},
+	}, diff.Gained)
+	assert.Equal(t, []*policyAccess{
+		{Subject: "user:jdoe", Access: "all namespace=dev apiGroup=* resource=pods"},
+		{Subject: "user:ops", Access: "all namespace=* apiGroup=* resource=*"},
+	}, diff.Lost)
+
+	// step: reordering the rules is not a change
+	diff = diffPolicies(old, policyList{old[2], old[0], old[1]})
+	assert.True(t, diff.empty())
+	assert.Empty(t, diff.Gained)
+	assert.Empty(t, diff.Lost)
+	assert.Equal(t, "no changes\n", diff.String())
+}
+
+func TestPolicyDiffHistory(t *testing.T) {
+	h := newPolicyDiffHistory(2)
+	for _, x := range []string{"a", "b", "c"} {
+		h.add(&policyDiff{Source: x})
+	}
+	list := h.list()
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "c", list[0].Source)
+		assert.Equal(t, "b", list[1].Source)
+	}
+
+	h = newPolicyDiffHistory(0)
+	h.add(&policyDiff{Source: "a"})
+	assert.Empty(t, h.list())
+}
+
+func TestDiffPolicyPaths(t *testing.T) {
+	old, err := writeTestFile(`{"user":"jdoe","namespace":"dev","resource":"pods"}`)
+	if err != nil {
+		t.Fatalf("failed to write the policy file, error: %s", err)
+	}
+	defer os.Remove(old.Name())
+	updated, err := writeTestFile(`{"user":"jdoe","namespace":"dev","resource":"pods","readonly":true}`)
+	if err != nil {
+		t.Fatalf("failed to write the policy file, error: %s", err)
+	}
+	defer os.Remove(updated.Name())
+
+	diff, err := diffPolicyPaths(old.Name(), updated.Name(), false)
+	assert.NoError(t, err)
+	assert.Len(t, diff.Changed, 1)
+	assert.Empty(t, diff.Gained)
+	assert.Len(t, diff.Lost, 1)
+
+	_, err = diffPolicyPaths(old.Name(), "/does_not_exist", false)
+	assert.Error(t, err)
+}
+
+func TestPolicyDiffOnReload(t *testing.T) {
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{adminListen: "127.0.0.1:8081", policyDiffHistory: 5})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+	admin := httptest.NewServer(s.s.admin)
+	defer admin.Close()
+
+	updateTestFile(t, s.s.cfg.authFile,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"newbie","namespace":"dev","resource":"*","apiGroup":"*"}}`+"\n")
+	time.Sleep(800 * time.Millisecond)
+
+	res, err := hc.R().Get(admin.URL + "/admin/policy/diffs")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var diffs []*policyDiff
+	assert.NoError(t, json.Unmarshal(res.Body(), &diffs))
+	if !assert.Len(t, diffs, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "policy", diffs[0].Source)
+	assert.Len(t, diffs[0].Added, 1)
+	assert.Equal(t, []*policyAccess{{Subject: "user:newbie", Access: "all namespace=dev apiGroup=* resource=*"}}, diffs[0].Gained)
+}
@@ -0,0 +1,138 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// patternCache holds the compiled glob and regex patterns of the rules, it's cleared when the
+// policies are reloaded so it only holds the patterns in use
+var patternCache = struct {
+	sync.RWMutex
+	compiled map[string]*regexp.Regexp
+}{compiled: make(map[string]*regexp.Regexp, 0)}
+
+// patternMatches checks the value against the rule; the rule is either an exact value, * for
+// everything, a glob where * matches any characters and ? a single character, i.e. te-*, or an
+// anchored regex, i.e. ^te-(dev|preprod)$
+func patternMatches(rule, value string) bool {
+	if rule == "*" || rule == value {
+		return true
+	}
+	if !isPattern(rule) {
+		return false
+	}
+	re, err := compilePattern(rule)
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(value)
+}
+
+// isPattern checks if the rule is a glob or regex rather than an exact value
+func isPattern(rule string) bool {
+	return isRegexPattern(rule) || strings.ContainsAny(rule, "*?")
+}
+
+// isRegexPattern checks if the rule is an anchored regex
+func isRegexPattern(rule string) bool {
+	return len(rule) > 1 && strings.HasPrefix(rule, "^") && strings.HasSuffix(rule, "$")
+}
+
+// compilePattern converts the glob or regex, caching the result
+func compilePattern(rule string) (*regexp.Regexp, error) {
+	patternCache.RLock()
+	re, found := patternCache.compiled[rule]
+	patternCache.RUnlock()
+	if found {
+		return re, nil
+	}
+
+	var expression string
+	if isRegexPattern(rule) {
+		// @note: the regex is wrapped in a group, else an alternation such as ^a|b$ escapes the anchors
+		expression = "^(?:" + rule[1:len(rule)-1] + ")$"
+	} else {
+		buf := bytes.NewBufferString("^")
+		for _, x := range rule {
+			switch x {
+			case '*':
+				buf.WriteString(".*")
+			case '?':
+				buf.WriteString(".")
+			default:
+				buf.WriteString(regexp.QuoteMeta(string(x)))
+			}
+		}
+		buf.WriteString("$")
+		expression = buf.String()
+	}
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	patternCache.Lock()
+	patternCache.compiled[rule] = re
+	patternCache.Unlock()
+
+	return re, nil
+}
+
+// resetPatternCache drops all the compiled patterns
+func resetPatternCache() {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+
+	patternCache.compiled = make(map[string]*regexp.Regexp, 0)
+}
+
+// validatePatterns checks the regexes of the rule compile and the subresources are qualified
+func (p *policy) validatePatterns() error {
+	rules := []struct {
+		name, rule string
+	}{
+		{name: "namespace", rule: p.Spec.Namespace},
+		{name: "resource", rule: p.Spec.Resource},
+		{name: "apiGroup", rule: p.Spec.APIGroup},
+		{name: "name", rule: p.Spec.Name},
+		{name: "nonResourcePath", rule: p.Spec.NonResourcePath},
+	}
+	for _, x := range p.Spec.Subresources {
+		if !isRegexPattern(x) && !strings.Contains(x, "/") {
+			return fmt.Errorf("invalid subresource %q, must be in the format resource/subresource", x)
+		}
+		rules = append(rules, struct{ name, rule string }{name: "subresource", rule: x})
+	}
+	for _, x := range rules {
+		if !isPattern(x.rule) {
+			continue
+		}
+		if _, err := compilePattern(x.rule); err != nil {
+			return fmt.Errorf("invalid %s pattern %q: %s", x.name, x.rule, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+func TestPatternMatches(t *testing.T) {
+	cs := []struct {
+		Rule     string
+		Value    string
+		Expected bool
+	}{
+		{Rule: "*", Value: "", Expected: true},
+		{Rule: "te", Value: "te", Expected: true},
+		{Rule: "te", Value: "te-dev"},
+		{Rule: "te-*", Value: "te-dev", Expected: true},
+		{Rule: "te-*", Value: "te"},
+		{Rule: "te*", Value: "te", Expected: true},
+		{Rule: "*-dev", Value: "payments-dev", Expected: true},
+		{Rule: "te-?", Value: "te-a", Expected: true},
+		{Rule: "te-?", Value: "te-ab"},
+		{Rule: "te.*", Value: "te-dev"},
+		{Rule: "/api*", Value: "/api/v1", Expected: true},
+		{Rule: "/apis/*/v1", Value: "/apis/batch/v1", Expected: true},
+		{Rule: "^te(-dev|-preprod)?$", Value: "te", Expected: true},
+		{Rule: "^te(-dev|-preprod)?$", Value: "te-preprod", Expected: true},
+		{Rule: "^te(-dev|-preprod)?$", Value: "te-prod"},
+		{Rule: "^te-[$", Value: "te-["},
+		{Rule: "^te-dev|te-preprod$", Value: "te-preprod", Expected: true},
+		{Rule: "^te-dev|te-preprod$", Value: "evil-te-preprod"},
+		{Rule: "^te-dev|te-preprod$", Value: "te-dev-anything"},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, patternMatches(c.Rule, c.Value), "case %d, rule: %s, value: %s", i, c.Rule, c.Value)
+	}
+}
+
+func TestPolicyPatterns(t *testing.T) {
+	f, err := writeTestFile(`
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"te","namespace":"^te(-dev|-preprod)?$","resource":"*","apiGroup":"*"}}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"ops","namespace":"*","resource":"configmaps","apiGroup":"","name":"ingress-*"}}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"ops","nonResourcePath":"/apis/*/v1"}}
+{"user":"legacy","namespace":"te","resource":"pods"}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	list, err := newPolicyFromFile(f.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cs := []struct {
+		Attributes authorizer.AttributesRecord
+		Expected   bool
+	}{
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Groups: []string{"te"}}, Verb: "get", ResourceRequest: true, Namespace: "te-dev", Resource: "pods"},
+			Expected:   true,
+		},
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Groups: []string{"te"}}, Verb: "get", ResourceRequest: true, Namespace: "te-prod", Resource: "pods"},
+		},
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Groups: []string{"ops"}}, Verb: "update", ResourceRequest: true, Namespace: "kube-system", Resource: "configmaps", Name: "ingress-controller"},
+			Expected:   true,
+		},
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Groups: []string{"ops"}}, Verb: "update", ResourceRequest: true, Namespace: "kube-system", Resource: "configmaps", Name: "dns"},
+		},
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Groups: []string{"ops"}}, Verb: "get", Path: "/apis/batch/v1"},
+			Expected:   true,
+		},
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "legacy"}, Verb: "get", ResourceRequest: true, Namespace: "te", Resource: "pods"},
+			Expected:   true,
+		},
+		{
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "legacy"}, Verb: "get", ResourceRequest: true, Namespace: "te-dev", Resource: "pods"},
+		},
+	}
+	for i, c := range cs {
+		allowed, _, err := list.authorize(c.Attributes, time.Now())
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Expected, allowed, "case %d", i)
+	}
+
+	_, explanation, _ := list.explain(&cs[3].Attributes, time.Now())
+	assert.Contains(t, explanation, f.Name()+":3 (name)")
+}
+
+func TestPolicyPatternsBad(t *testing.T) {
+	_, err := decodePolicy([]byte(`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"a","namespace":"^te-[$"}}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid namespace pattern")
+	}
+}
+
+func TestResetPatternCache(t *testing.T) {
+	assert.True(t, patternMatches("te-*", "te-dev"))
+	patternCache.RLock()
+	assert.NotEmpty(t, patternCache.compiled)
+	patternCache.RUnlock()
+
+	resetPatternCache()
+	patternCache.RLock()
+	assert.Empty(t, patternCache.compiled)
+	patternCache.RUnlock()
+	assert.True(t, patternMatches("te-*", "te-dev"))
+}
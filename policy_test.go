@@ -0,0 +1,404 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+func TestNewPolicyFromFile(t *testing.T) {
+	f, err := writeTestFile(`
+# a comment line
+{"user":"admin"}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"dev","namespace":"dev","resource":"*"}}
+`)
+	if err != nil {
+		t.Fatalf("failed to write the policy file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	list, err := newPolicyFromFile(f.Name())
+	assert.NoError(t, err)
+	if !assert.Len(t, list, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, policySpec{
+		User:            "admin",
+		Namespace:       "*",
+		Resource:        "*",
+		APIGroup:        "*",
+		NonResourcePath: "*",
+	}, list[0].Spec)
+	assert.Equal(t, "dev", list[1].Spec.Group)
+}
+
+func TestNewPolicyFromFileBad(t *testing.T) {
+	cs := []string{
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"admin"}`,
+		`{"apiVersion":"not_known","kind":"Policy","spec":{"user":"admin"}}`,
+	}
+	for _, x := range cs {
+		f, err := writeTestFile(x)
+		if err != nil {
+			t.Fatalf("failed to write the policy file, error: %s", err)
+		}
+		_, err = newPolicyFromFile(f.Name())
+		assert.Error(t, err)
+		os.Remove(f.Name())
+	}
+}
+
+func TestPolicyMatches(t *testing.T) {
+	cs := []struct {
+		Spec       policySpec
+		Attributes authorizer.AttributesRecord
+		Expected   bool
+	}{
+		{
+			Spec:       policySpec{User: "admin", Namespace: "*", Resource: "*", APIGroup: "*"},
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "admin"}, Verb: "create", ResourceRequest: true, Resource: "pods"},
+			Expected:   true,
+		},
+		{
+			Spec:       policySpec{User: "admin", Namespace: "*", Resource: "*", APIGroup: "*"},
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "other"}, Verb: "get", ResourceRequest: true, Resource: "pods"},
+		},
+		{
+			Spec:       policySpec{User: "admin", Namespace: "*", Resource: "*", APIGroup: "*", Readonly: true},
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "admin"}, Verb: "delete", ResourceRequest: true, Resource: "pods"},
+		},
+		{
+			Spec:       policySpec{User: "*", NonResourcePath: "/api*"},
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "admin"}, Verb: "get", Path: "/api/v1"},
+			Expected:   true,
+		},
+		{
+			Spec:       policySpec{Group: "*", Namespace: "*", Resource: "*", APIGroup: "*", Extra: map[string]string{"team": "platform"}},
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "admin"}, Verb: "get", ResourceRequest: true, Resource: "pods"},
+		},
+		{
+			Spec: policySpec{Group: "*", Namespace: "*", Resource: "*", APIGroup: "*", Extra: map[string]string{"team": "platform"}},
+			Attributes: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{Name: "admin", Extra: map[string][]string{"team": {"dev", "platform"}}},
+				Verb:            "get",
+				ResourceRequest: true,
+				Resource:        "pods",
+			},
+			Expected: true,
+		},
+		{
+			Spec: policySpec{Group: "*", Namespace: "*", Resource: "*", APIGroup: "*", Extra: map[string]string{"scopes": "*"}},
+			Attributes: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{Name: "admin", Extra: map[string][]string{"team": {"platform"}}},
+				Verb:            "get",
+				ResourceRequest: true,
+				Resource:        "pods",
+			},
+		},
+		{
+			Spec:       policySpec{Extra: map[string]string{"team": "platform"}, Namespace: "*", Resource: "*", APIGroup: "*"},
+			Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "admin", Extra: map[string][]string{"team": {"platform"}}}, Verb: "get", ResourceRequest: true},
+		},
+	}
+	for i, x := range cs {
+		p := &policy{Spec: x.Spec}
+		assert.Equal(t, x.Expected, p.matches(x.Attributes, time.Now()), "case %d", i)
+	}
+}
+
+func TestNewPolicyFromYAMLFile(t *testing.T) {
+	f, err := ioutil.TempFile("/tmp", "kube-auth.XXXXXXXX.yaml")
+	if err != nil {
+		t.Fatalf("failed to create the policy file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`# comments only
+---
+apiVersion: abac.authorization.kubernetes.io/v1beta1
+kind: Policy
+spec:
+  user: admin
+  namespace: '*'
+  resource: '*'
+---
+- user: readonly
+  readonly: true
+- apiVersion: abac.authorization.kubernetes.io/v1beta1
+  kind: Policy
+  spec:
+    group: dev
+    namespace: dev
+    resource: '*'
+`)
+	f.Close()
+
+	list, err := newPolicyFromYAMLFile(f.Name())
+	assert.NoError(t, err)
+	if !assert.Len(t, list, 3) {
+		t.FailNow()
+	}
+	assert.Equal(t, "admin", list[0].Spec.User)
+	assert.Equal(t, "readonly", list[1].Spec.User)
+	assert.True(t, list[1].Spec.Readonly)
+	assert.Equal(t, "dev", list[2].Spec.Group)
+	assert.Equal(t, []int{3, 10, 12}, []int{list[0].line, list[1].line, list[2].line})
+	assert.Equal(t, f.Name(), list[0].file)
+}
+
+func TestNewPolicyFromYAMLFileBad(t *testing.T) {
+	f, err := writeTestFile("- user: admin\n---\n- apiVersion: not_known\n  kind: Policy\n")
+	if err != nil {
+		t.Fatalf("failed to write the policy file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = newPolicyFromYAMLFile(f.Name())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), f.Name()+", line 3")
+	}
+}
+
+func TestNewPolicyFromDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "kube-auth.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("failed to create the policy directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestPolicyDirectory(t, dir, map[string]string{
+		"b-team.jsonl":       `{"user":"b-user"}`,
+		"a-team.yaml":        "- user: a-user\n",
+		"README.md":          "not a policy",
+		".hidden.jsonl":      `{"user":"hidden"}`,
+		"nested/c-team.yaml": "- user: c-user\n",
+	})
+
+	list, err := newPolicyFromDirectory(dir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-user", "b-user"}, policyUsers(list))
+
+	list, err = newPolicyFromDirectory(dir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-user", "b-user", "c-user"}, policyUsers(list))
+
+	// step: a broken fragment rejects the whole directory
+	writeTestPolicyDirectory(t, dir, map[string]string{"d-team.jsonl": "{\"user\":\"d-user\"}\n{\"user\":\n"})
+	_, err = newPolicyFromDirectory(dir, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), filepath.Join(dir, "d-team.jsonl")+", line 2")
+	}
+
+	_, err = newPolicyFromDirectory("/does_not_exist", false)
+	assert.Error(t, err)
+}
+
+func TestPolicyDirectoryChange(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "kube-auth.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("failed to create the policy directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestPolicyDirectory(t, dir, map[string]string{"a-team.jsonl": `{"user":"a-user"}`})
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{authDir: dir})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	allowed := func(username string) bool {
+		status, err := makeTestAuthzRequest(s.URL(), v1beta1.SubjectAccessReview{
+			Spec: v1beta1.SubjectAccessReviewSpec{
+				User:               username,
+				ResourceAttributes: &v1beta1.ResourceAttributes{Resource: "pods", Namespace: "default", Verb: "get"},
+			},
+		})
+		assert.NoError(t, err)
+		return status.Status.Allowed
+	}
+	assert.True(t, allowed("a-user"))
+	assert.False(t, allowed("b-user"))
+
+	// step: add a fragment
+	writeTestPolicyDirectory(t, dir, map[string]string{"b-team.yaml": "- user: b-user\n"})
+	time.Sleep(800 * time.Millisecond)
+	assert.True(t, allowed("b-user"))
+
+	// step: a broken fragment should leave the current policy in place
+	writeTestPolicyDirectory(t, dir, map[string]string{"c-team.jsonl": "{\"user\":\n"})
+	time.Sleep(800 * time.Millisecond)
+	assert.True(t, allowed("a-user"))
+	assert.True(t, allowed("b-user"))
+
+	// step: remove the fragments
+	os.Remove(filepath.Join(dir, "c-team.jsonl"))
+	os.Remove(filepath.Join(dir, "a-team.jsonl"))
+	time.Sleep(800 * time.Millisecond)
+	assert.False(t, allowed("a-user"))
+	assert.True(t, allowed("b-user"))
+}
+
+func writeTestPolicyDirectory(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create the directory, error: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write the file, error: %s", err)
+		}
+	}
+}
+
+func policyUsers(list policyList) []string {
+	var users []string
+	for _, x := range list {
+		users = append(users, x.Spec.User)
+	}
+
+	return users
+}
+
+func TestPolicyExplain(t *testing.T) {
+	f, err := writeTestFile(`# team rules
+{"user":"jdoe","namespace":"dev","resource":"pods","readonly":true}
+{"user":"jdoe","namespace":"qa","resource":"*"}
+{"group":"ops","namespace":"*","resource":"*"}
+`)
+	if err != nil {
+		t.Fatalf("failed to write the policy file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	list, err := newPolicyFromFile(f.Name())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cs := []struct {
+		Attributes authorizer.AttributesRecord
+		Decision   decision
+		Expected   string
+	}{
+		{
+			Attributes: authorizer.AttributesRecord{
+				User: &user.DefaultInfo{Name: "jdoe"}, Verb: "get", Namespace: "dev", Resource: "pods", ResourceRequest: true,
+			},
+			Decision: decisionAllow,
+			Expected: "allowed by " + f.Name() + ":2",
+		},
+		{
+			Attributes: authorizer.AttributesRecord{
+				User: &user.DefaultInfo{Name: "jdoe"}, Verb: "delete", Namespace: "dev", Resource: "pods", ResourceRequest: true,
+			},
+			Expected: "no rule matched, closest: " + f.Name() + ":2 (readonly); " + f.Name() + ":3 (namespace); " +
+				f.Name() + ":4 (subject)",
+		},
+		{
+			Attributes: authorizer.AttributesRecord{
+				User: &user.DefaultInfo{Name: "other"}, Verb: "get", Path: "/healthz",
+			},
+			Expected: "no rule matched, closest: " + f.Name() + ":2 (subject, path); " + f.Name() + ":3 (subject, path); " +
+				f.Name() + ":4 (subject, path)",
+		},
+	}
+	for i, x := range cs {
+		d, explanation, err := list.explain(x.Attributes, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, x.Decision, d, "case %d", i)
+		assert.Equal(t, x.Expected, explanation, "case %d", i)
+	}
+
+	_, explanation, _ := policyList{}.explain(authorizer.AttributesRecord{}, time.Now())
+	assert.Equal(t, "no rule matched", explanation)
+}
+
+func TestPolicyVerbsAndSubresources(t *testing.T) {
+	f, err := writeTestFile(`
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"dev","namespace":"dev","resource":"*","apiGroup":"*","verbs":["get","list","watch","create"]}}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"support","namespace":"*","resource":"pods","apiGroup":"","subresources":["pods/log"]}}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"oncall","namespace":"prod","apiGroup":"","subresources":["pods/exec"],"verbs":["create"]}}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"deployer","namespace":"*","resource":"serviceaccounts","apiGroup":"","verbs":["impersonate"]}}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	list, err := newPolicyFromFile(f.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	request := func(subject *user.DefaultInfo, verb, namespace, resource, subresource string) authorizer.AttributesRecord {
+		return authorizer.AttributesRecord{
+			User:            subject,
+			Verb:            verb,
+			Namespace:       namespace,
+			Resource:        resource,
+			Subresource:     subresource,
+			ResourceRequest: true,
+		}
+	}
+	dev := &user.DefaultInfo{Name: "jane", Groups: []string{"dev"}}
+	support := &user.DefaultInfo{Name: "bob", Groups: []string{"support"}}
+	oncall := &user.DefaultInfo{Name: "sam", Groups: []string{"oncall"}}
+
+	cs := []struct {
+		Attributes authorizer.AttributesRecord
+		Expected   bool
+	}{
+		{Attributes: request(dev, "create", "dev", "deployments", ""), Expected: true},
+		{Attributes: request(dev, "delete", "dev", "deployments", "")},
+		{Attributes: request(dev, "create", "dev", "pods", "exec"), Expected: true},
+		{Attributes: request(support, "get", "prod", "pods", ""), Expected: true},
+		{Attributes: request(support, "get", "prod", "pods", "log"), Expected: true},
+		{Attributes: request(support, "create", "prod", "pods", "exec")},
+		{Attributes: request(oncall, "create", "prod", "pods", "exec"), Expected: true},
+		{Attributes: request(oncall, "get", "prod", "pods", "")},
+		{Attributes: request(&user.DefaultInfo{Name: "deployer"}, "impersonate", "ci", "serviceaccounts", ""), Expected: true},
+		{Attributes: request(&user.DefaultInfo{Name: "deployer"}, "delete", "ci", "serviceaccounts", "")},
+	}
+	for i, c := range cs {
+		allowed, _, err := list.authorize(c.Attributes, time.Now())
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Expected, allowed, "case %d", i)
+	}
+
+	_, explanation, _ := list.explain(&cs[5].Attributes, time.Now())
+	assert.Contains(t, explanation, f.Name()+":3 (subresource)")
+	_, explanation, _ = list.explain(&cs[1].Attributes, time.Now())
+	assert.Contains(t, explanation, f.Name()+":2 (verb)")
+
+	assert.Equal(t, []string{"verbs=get,list,watch,create namespace=dev apiGroup=* resource=*"}, list[0].grants())
+	assert.Equal(t, []string{"verbs=create namespace=prod apiGroup= resource= subresources=pods/exec"}, list[2].grants())
+}
+
+func TestPolicySubresourcesBad(t *testing.T) {
+	_, err := decodePolicy([]byte(`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"a","subresources":["exec"]}}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "must be in the format resource/subresource")
+	}
+}
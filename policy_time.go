@@ -0,0 +1,216 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays are the days of the week in the order of time.Weekday
+var weekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// policyWindow is a recurring window of time in which a rule applies
+type policyWindow struct {
+	// Days are the days of the week, a range and or list i.e. Mon-Thu or Sat,Sun, empty is every day
+	Days string `json:"days,omitempty"`
+	// Hours is the time of day range i.e. 09:00-17:00, the end is exclusive and a range
+	// can wrap past midnight, empty is all day
+	Hours string `json:"hours,omitempty"`
+	// TimeZone is the location the window is evaluated in, defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// days are the parsed days of the week
+	days [7]bool
+	// start and end are the minutes of the day
+	start, end int
+	// location is the parsed time zone
+	location *time.Location
+}
+
+// UnmarshalJSON decodes and parses the window so errors are reported when the policy is loaded
+func (w *policyWindow) UnmarshalJSON(content []byte) error {
+	type plain policyWindow
+	var decoded plain
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return err
+	}
+	*w = policyWindow(decoded)
+
+	return w.parse()
+}
+
+// parse validates the window and parses the days, hours and time zone
+func (w *policyWindow) parse() error {
+	var err error
+	if w.location, err = time.LoadLocation(w.TimeZone); err != nil {
+		return fmt.Errorf("invalid schedule time zone: %s", w.TimeZone)
+	}
+
+	// step: parse the days
+	if w.Days == "" {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, x := range strings.Split(w.Days, ",") {
+		if w.Days == "" {
+			break
+		}
+		items := strings.SplitN(strings.TrimSpace(x), "-", 2)
+		first, last := weekday(items[0]), weekday(items[len(items)-1])
+		if first < 0 || last < 0 {
+			return fmt.Errorf("invalid schedule days: %s, must be days of the week i.e. Mon-Fri or Sat,Sun", w.Days)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	// step: parse the hours
+	w.start, w.end = 0, 24*60
+	if w.Hours != "" {
+		items := strings.SplitN(w.Hours, "-", 2)
+		if len(items) != 2 {
+			return fmt.Errorf("invalid schedule hours: %s, must be a range i.e. 09:00-17:00", w.Hours)
+		}
+		if w.start, err = minuteOfDay(items[0]); err != nil {
+			return fmt.Errorf("invalid schedule hours: %s, %s", w.Hours, err)
+		}
+		if w.end, err = minuteOfDay(items[1]); err != nil {
+			return fmt.Errorf("invalid schedule hours: %s, %s", w.Hours, err)
+		}
+		if w.start == w.end {
+			return fmt.Errorf("invalid schedule hours: %s, the range is empty", w.Hours)
+		}
+	}
+
+	return nil
+}
+
+// contains checks if the time falls within the window; for a range past midnight the day
+// is the day the range started
+func (w *policyWindow) contains(now time.Time) bool {
+	local := now.In(w.location)
+	minute := local.Hour()*60 + local.Minute()
+	day := int(local.Weekday())
+	switch {
+	case w.start < w.end:
+		return w.days[day] && minute >= w.start && minute < w.end
+	case minute >= w.start:
+		return w.days[day]
+	case minute < w.end:
+		return w.days[(day+6)%7]
+	}
+
+	return false
+}
+
+// String returns a description of the window
+func (w *policyWindow) String() string {
+	var items []string
+	if w.Days != "" {
+		items = append(items, w.Days)
+	}
+	if w.Hours != "" {
+		items = append(items, w.Hours)
+	}
+	if len(items) == 0 {
+		items = append(items, "all day")
+	}
+	zone := w.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+
+	return strings.Join(append(items, zone), " ")
+}
+
+// weekday returns the index of the day of the week, given in full or abbreviated, or -1
+func weekday(name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, x := range weekdays {
+		if name == x || (len(name) == 3 && strings.HasPrefix(x, name)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// minuteOfDay parses a HH:MM time, 24:00 is the end of the day
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		if strings.TrimSpace(value) == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("%q is not a time of day, must be HH:MM", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// hasTimeConditions checks if the rule is restricted in time
+func (p *policy) hasTimeConditions() bool {
+	return p.Spec.NotBefore != nil || p.Spec.NotAfter != nil || len(p.Spec.Schedule) > 0
+}
+
+// activeAt checks the time conditions of the rule, the rule applies if within the validity
+// period and any of the schedule windows
+func (p *policy) activeAt(now time.Time) bool {
+	if p.Spec.NotBefore != nil && now.Before(*p.Spec.NotBefore) {
+		return false
+	}
+	if p.Spec.NotAfter != nil && !now.Before(*p.Spec.NotAfter) {
+		return false
+	}
+	if len(p.Spec.Schedule) == 0 {
+		return true
+	}
+	for _, x := range p.Spec.Schedule {
+		if x.contains(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conditions returns a description of the time conditions of the rule
+func (p *policy) conditions() string {
+	var items []string
+	if p.Spec.NotBefore != nil {
+		items = append(items, "from "+p.Spec.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if p.Spec.NotAfter != nil {
+		items = append(items, "until "+p.Spec.NotAfter.UTC().Format(time.RFC3339))
+	}
+	var windows []string
+	for _, x := range p.Spec.Schedule {
+		windows = append(windows, x.String())
+	}
+	if len(windows) > 0 {
+		items = append(items, "during "+strings.Join(windows, " or "))
+	}
+
+	return strings.Join(items, " ")
+}
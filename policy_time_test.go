@@ -0,0 +1,159 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+func TestPolicyWindowParse(t *testing.T) {
+	cs := []struct {
+		Window policyWindow
+		Error  string
+	}{
+		{Window: policyWindow{}},
+		{Window: policyWindow{Days: "Mon-Thu", Hours: "09:00-17:00", TimeZone: "Europe/London"}},
+		{Window: policyWindow{Days: "saturday, Sun", Hours: "22:00-06:00"}},
+		{Window: policyWindow{Days: "Mon-Funday"}, Error: "invalid schedule days"},
+		{Window: policyWindow{Hours: "09:00"}, Error: "invalid schedule hours"},
+		{Window: policyWindow{Hours: "9am-5pm"}, Error: "invalid schedule hours"},
+		{Window: policyWindow{Hours: "09:00-09:00"}, Error: "the range is empty"},
+		{Window: policyWindow{TimeZone: "Mars/Olympus"}, Error: "invalid schedule time zone"},
+	}
+	for i, c := range cs {
+		err := c.Window.parse()
+		if c.Error == "" {
+			assert.NoError(t, err, "case %d", i)
+			continue
+		}
+		if assert.Error(t, err, "case %d", i) {
+			assert.Contains(t, err.Error(), c.Error, "case %d", i)
+		}
+	}
+}
+
+func TestPolicyWindowContains(t *testing.T) {
+	cs := []struct {
+		Window   policyWindow
+		Time     string
+		Expected bool
+	}{
+		{Window: policyWindow{}, Time: "2017-03-05T03:00:00Z", Expected: true},
+		{Window: policyWindow{Days: "Mon-Thu", Hours: "09:00-17:00"}, Time: "2017-03-06T09:00:00Z", Expected: true},
+		{Window: policyWindow{Days: "Mon-Thu", Hours: "09:00-17:00"}, Time: "2017-03-06T17:00:00Z"},
+		{Window: policyWindow{Days: "Mon-Thu", Hours: "09:00-17:00"}, Time: "2017-03-10T10:00:00Z"},
+		// @note: 08:30 UTC is 09:30 in Paris
+		{Window: policyWindow{Days: "Mon-Thu", Hours: "09:00-17:00", TimeZone: "Europe/Paris"}, Time: "2017-03-06T08:30:00Z", Expected: true},
+		{Window: policyWindow{Days: "Fri-Mon"}, Time: "2017-03-05T12:00:00Z", Expected: true},
+		{Window: policyWindow{Days: "Fri-Mon"}, Time: "2017-03-07T12:00:00Z"},
+		// @note: the overnight window belongs to the day it started
+		{Window: policyWindow{Days: "Fri", Hours: "22:00-06:00"}, Time: "2017-03-11T05:00:00Z", Expected: true},
+		{Window: policyWindow{Days: "Fri", Hours: "22:00-06:00"}, Time: "2017-03-10T05:00:00Z"},
+		{Window: policyWindow{Hours: "18:00-24:00"}, Time: "2017-03-10T23:59:00Z", Expected: true},
+	}
+	for i, c := range cs {
+		if !assert.NoError(t, c.Window.parse(), "case %d", i) {
+			continue
+		}
+		now, _ := time.Parse(time.RFC3339, c.Time)
+		assert.Equal(t, c.Expected, c.Window.contains(now), "case %d", i)
+	}
+}
+
+func TestPolicyTimeConditions(t *testing.T) {
+	f, err := writeTestFile(`
+- apiVersion: abac.authorization.kubernetes.io/v1beta1
+  kind: Policy
+  spec:
+    group: on-call
+    namespace: prod
+    resource: pods
+    apiGroup: "*"
+    notBefore: 2017-03-01T00:00:00Z
+    notAfter: 2017-04-01T00:00:00Z
+    schedule:
+    - days: Mon-Thu
+      hours: 09:00-17:00
+    - days: Sat
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	file := f.Name()
+	list, err := newPolicyFromYAMLFile(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var now time.Time
+	authz := newPolicyAuthorizer(list, func() time.Time { return now })
+	attrs := authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: "jane", Groups: []string{"on-call"}},
+		Verb:            "create",
+		Namespace:       "prod",
+		Resource:        "pods",
+		ResourceRequest: true,
+	}
+
+	cs := []struct {
+		Time     string
+		Expected bool
+	}{
+		{Time: "2017-03-06T10:00:00Z", Expected: true},
+		{Time: "2017-03-06T18:00:00Z"},
+		{Time: "2017-03-11T18:00:00Z", Expected: true},
+		{Time: "2017-02-25T10:00:00Z"},
+		{Time: "2017-04-01T10:00:00Z"},
+	}
+	for i, c := range cs {
+		now, _ = time.Parse(time.RFC3339, c.Time)
+		allowed, _, err := authz.Authorize(attrs)
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Expected, allowed, "case %d", i)
+	}
+
+	_, explanation, _ := authz.Explain(attrs)
+	assert.Equal(t, "no rule matched, closest: "+file+":2 (time)", explanation)
+	assert.Equal(t, []string{"all namespace=prod apiGroup=* resource=pods from 2017-03-01T00:00:00Z " +
+		"until 2017-04-01T00:00:00Z during Mon-Thu 09:00-17:00 UTC or Sat UTC"}, list[0].grants())
+}
+
+func TestPolicyTimeConditionsBad(t *testing.T) {
+	f, err := writeTestFile(`
+apiVersion: abac.authorization.kubernetes.io/v1beta1
+kind: Policy
+spec:
+  user: bot
+  schedule:
+  - hours: 17:00
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = newPolicyFromYAMLFile(f.Name())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid schedule hours")
+	}
+}
@@ -0,0 +1,485 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// rbacAPIGroup is the api group of the rbac objects
+	rbacAPIGroup = "rbac.authorization.k8s.io"
+	// rbacAPIVersion is the api version of the rbac objects
+	rbacAPIVersion = rbacAPIGroup + "/v1"
+	// serviceAccountPrefix is the username prefix of a service account
+	serviceAccountPrefix = "system:serviceaccount:"
+	// authenticatedGroup is the group of all authenticated users
+	authenticatedGroup = "system:authenticated"
+	// unauthenticatedGroup is the group of anonymous requests
+	unauthenticatedGroup = "system:unauthenticated"
+)
+
+// rbacObject is a kubernetes Role, ClusterRole, RoleBinding or ClusterRoleBinding; the api types
+// aren't in the vendored kubernetes, so only the fields used in authorization are modelled
+type rbacObject struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   rbacMetadata `json:"metadata"`
+	// Rules are the rules of a role
+	Rules []*rbacRule `json:"rules,omitempty"`
+	// Subjects are the users, groups and service accounts of a binding
+	Subjects []*rbacSubject `json:"subjects,omitempty"`
+	// RoleRef is the role of a binding
+	RoleRef *rbacRoleRef `json:"roleRef,omitempty"`
+	// AggregationRule selects the cluster roles whose rules make up an aggregated cluster role
+	AggregationRule *rbacAggregationRule `json:"aggregationRule,omitempty"`
+}
+
+// rbacList is a List, or a RoleList, ClusterRoleList etc, of objects
+type rbacList struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Items      []json.RawMessage `json:"items"`
+}
+
+// rbacMetadata is the object metadata
+type rbacMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// rbacRule is a policy rule of a role
+type rbacRule struct {
+	Verbs           []string `json:"verbs"`
+	APIGroups       []string `json:"apiGroups,omitempty"`
+	Resources       []string `json:"resources,omitempty"`
+	ResourceNames   []string `json:"resourceNames,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+}
+
+// rbacSubject is a subject of a binding
+type rbacSubject struct {
+	Kind      string `json:"kind"`
+	APIGroup  string `json:"apiGroup,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// rbacRoleRef is the role a binding refers to
+type rbacRoleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+// rbacAggregationRule is the aggregation rule of a cluster role
+type rbacAggregationRule struct {
+	ClusterRoleSelectors []*labelSelector `json:"clusterRoleSelectors,omitempty"`
+}
+
+// labelSelector is a kubernetes label selector, all the labels and expressions must match
+type labelSelector struct {
+	MatchLabels      map[string]string           `json:"matchLabels,omitempty"`
+	MatchExpressions []*labelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// labelSelectorRequirement is an expression of a label selector
+type labelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// rbacAuthorizer evaluates requests using the kubernetes rbac semantics
+type rbacAuthorizer struct {
+	// roles are the Roles and ClusterRoles keyed by kind/namespace/name
+	roles map[string]*rbacObject
+	// bindings are the RoleBindings and ClusterRoleBindings
+	bindings []*rbacObject
+}
+
+// newRBACAuthorizer creates an authorizer from the objects
+func newRBACAuthorizer(objects []*rbacObject) (*rbacAuthorizer, error) {
+	r := &rbacAuthorizer{roles: make(map[string]*rbacObject, 0)}
+	for _, x := range objects {
+		// @note: a namespaced object without a namespace can't be placed, unlike kubectl apply there's no default
+		if (x.Kind == "Role" || x.Kind == "RoleBinding") && x.Metadata.Namespace == "" {
+			return nil, fmt.Errorf("%s %s has no namespace", x.Kind, x.Metadata.Name)
+		}
+		switch x.Kind {
+		case "Role", "ClusterRole":
+			key := rbacRoleKey(x.Kind, x.Metadata.Namespace, x.Metadata.Name)
+			if _, found := r.roles[key]; found {
+				return nil, fmt.Errorf("%s %s is defined more than once", x.Kind, x.Metadata.Name)
+			}
+			r.roles[key] = x
+		case "RoleBinding", "ClusterRoleBinding":
+			if x.RoleRef == nil {
+				return nil, fmt.Errorf("%s %s has no roleRef", x.Kind, x.Metadata.Name)
+			}
+			if x.Kind == "ClusterRoleBinding" && x.RoleRef.Kind != "ClusterRole" {
+				return nil, fmt.Errorf("%s %s must refer to a ClusterRole", x.Kind, x.Metadata.Name)
+			}
+			r.bindings = append(r.bindings, x)
+		default:
+			return nil, fmt.Errorf("unsupported kind: %s", x.Kind)
+		}
+	}
+
+	// step: resolve the rules of the aggregated cluster roles
+	aggregated := make(map[string][]*rbacRule, 0)
+	for key, x := range r.roles {
+		if x.Kind == "ClusterRole" && x.AggregationRule != nil {
+			aggregated[key] = r.aggregateRules(x, make(map[string]bool, 0))
+		}
+	}
+	for key, rules := range aggregated {
+		role := *r.roles[key]
+		role.Rules = rules
+		r.roles[key] = &role
+	}
+
+	return r, nil
+}
+
+// newRBACAuthorizerFromPath reads the rbac manifests from a file, or all the manifests in a
+// directory and its subdirectories; if any of the files is invalid the whole directory is rejected
+func newRBACAuthorizerFromPath(path string) (*rbacAuthorizer, error) {
+	files := []string{path}
+	if isDirectory(path) {
+		var err error
+		if files, err = listFiles(path, true, isManifestFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var objects []*rbacObject
+	for _, x := range files {
+		list, err := readRBACManifests(x)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, list...)
+	}
+	r, err := newRBACAuthorizer(objects)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rbac manifests %s: %s", path, err)
+	}
+
+	return r, nil
+}
+
+// readRBACManifests reads the rbac objects from a file of yaml documents separated by --- or a
+// json object; lists are expanded and objects outside the rbac api group are ignored
+func readRBACManifests(filename string) ([]*rbacObject, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*rbacObject
+	var document []string
+	start := 1
+	flush := func() error {
+		defer func() { document = nil }()
+		if isEmptyYAML(document) {
+			return nil
+		}
+		encoded, err := yaml.YAMLToJSON([]byte(strings.Join(document, "\n")))
+		if err != nil {
+			return err
+		}
+		list, err := decodeRBACObjects(encoded)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, list...)
+
+		return nil
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "---" {
+			if err := flush(); err != nil {
+				return nil, fmt.Errorf("error reading rbac manifest %s, line %d: %s", filename, start, err)
+			}
+			start = i + 2
+			continue
+		}
+		document = append(document, line)
+	}
+	if err := flush(); err != nil {
+		return nil, fmt.Errorf("error reading rbac manifest %s, line %d: %s", filename, start, err)
+	}
+
+	return objects, nil
+}
+
+// decodeRBACObjects decodes an object or list of objects
+func decodeRBACObjects(content []byte) ([]*rbacObject, error) {
+	trimmed := strings.TrimSpace(string(content))
+	if trimmed == "" || trimmed == "null" {
+		return nil, nil
+	}
+	list := new(rbacList)
+	if err := json.Unmarshal(content, list); err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(list.Kind, "List") {
+		var objects []*rbacObject
+		for i, x := range list.Items {
+			items, err := decodeRBACObjects(x)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %s", i, err)
+			}
+			objects = append(objects, items...)
+		}
+		return objects, nil
+	}
+	// @note: the manifests may well contain other resources, i.e. service accounts
+	if !strings.HasPrefix(list.APIVersion, rbacAPIGroup+"/") {
+		return nil, nil
+	}
+	object := new(rbacObject)
+	if err := json.Unmarshal(content, object); err != nil {
+		return nil, err
+	}
+	if object.Metadata.Name == "" {
+		return nil, fmt.Errorf("%s has no name", object.Kind)
+	}
+
+	return []*rbacObject{object}, nil
+}
+
+// isManifestFile checks if the file should be read from a manifests directory
+func isManifestFile(filename string) bool {
+	if strings.HasPrefix(filepath.Base(filename), ".") {
+		return false
+	}
+	switch filepath.Ext(filename) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+
+	return false
+}
+
+// aggregateRules returns the rules of the cluster roles selected by the aggregation rule of the
+// role, which may themselves be aggregated; as with the kubernetes controller the rules of the
+// aggregated role itself are replaced
+func (r *rbacAuthorizer) aggregateRules(role *rbacObject, visiting map[string]bool) []*rbacRule {
+	if role.AggregationRule == nil {
+		return role.Rules
+	}
+	visiting[role.Metadata.Name] = true
+	defer delete(visiting, role.Metadata.Name)
+
+	var keys []string
+	for key, x := range r.roles {
+		if x.Kind == "ClusterRole" && !visiting[x.Metadata.Name] && role.AggregationRule.selects(x.Metadata.Labels) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var rules []*rbacRule
+	seen := make(map[string]bool, 0)
+	for _, key := range keys {
+		for _, x := range r.aggregateRules(r.roles[key], visiting) {
+			if k := rbacKey(x); !seen[k] {
+				seen[k] = true
+				rules = append(rules, x)
+			}
+		}
+	}
+
+	return rules
+}
+
+// selects checks if any of the selectors match the labels
+func (a *rbacAggregationRule) selects(labels map[string]string) bool {
+	for _, x := range a.ClusterRoleSelectors {
+		if x != nil && x.matches(labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches checks the labels; an empty selector matches everything
+func (l *labelSelector) matches(labels map[string]string) bool {
+	for k, v := range l.MatchLabels {
+		if value, found := labels[k]; !found || value != v {
+			return false
+		}
+	}
+	for _, x := range l.MatchExpressions {
+		value, found := labels[x.Key]
+		switch x.Operator {
+		case "In":
+			if !found || !containedIn(value, x.Values) {
+				return false
+			}
+		case "NotIn":
+			if found && containedIn(value, x.Values) {
+				return false
+			}
+		case "Exists":
+			if !found {
+				return false
+			}
+		case "DoesNotExist":
+			if found {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// rbacRoleKey returns the key of a role, cluster roles have no namespace
+func rbacRoleKey(kind, namespace, name string) string {
+	if kind == "ClusterRole" {
+		namespace = ""
+	}
+
+	return kind + "/" + namespace + "/" + name
+}
+
+// Authorize checks the attributes against the bindings; a binding which refers to a missing
+// role is ignored, as it is by kubernetes
+func (r *rbacAuthorizer) Authorize(a authorizer.Attributes) (bool, string, error) {
+	for _, binding := range r.bindings {
+		// @note: a role binding only grants access within its namespace
+		if binding.Kind == "RoleBinding" && binding.Metadata.Namespace != a.GetNamespace() {
+			continue
+		}
+		subject := rbacSubjectFor(binding, a.GetUser())
+		if subject == nil {
+			continue
+		}
+		role, found := r.roles[rbacRoleKey(binding.RoleRef.Kind, binding.Metadata.Namespace, binding.RoleRef.Name)]
+		if !found {
+			continue
+		}
+		for _, rule := range role.Rules {
+			if rule.matches(a) {
+				return true, fmt.Sprintf("allowed by %s %q of %s %q to %s %q",
+					binding.Kind, binding.Metadata.Name, role.Kind, role.Metadata.Name, subject.Kind, subject.Name), nil
+			}
+		}
+	}
+
+	return false, "no rbac binding matched", nil
+}
+
+// rbacSubjectFor returns the subject of the binding which matches the user, if any
+func rbacSubjectFor(binding *rbacObject, u user.Info) *rbacSubject {
+	if u == nil {
+		return nil
+	}
+	for _, x := range binding.Subjects {
+		switch x.Kind {
+		case "User":
+			if x.Name == u.GetName() {
+				return x
+			}
+		case "Group":
+			if containedIn(x.Name, u.GetGroups()) {
+				return x
+			}
+		case "ServiceAccount":
+			namespace := x.Namespace
+			if namespace == "" {
+				namespace = binding.Metadata.Namespace
+			}
+			if u.GetName() == serviceAccountPrefix+namespace+":"+x.Name {
+				return x
+			}
+		}
+	}
+
+	return nil
+}
+
+// matches checks the rule permits the request
+func (r *rbacRule) matches(a authorizer.Attributes) bool {
+	if !containedIn("*", r.Verbs) && !containedIn(a.GetVerb(), r.Verbs) {
+		return false
+	}
+	if !a.IsResourceRequest() {
+		return r.nonResourceURLMatches(a.GetPath())
+	}
+	if !containedIn("*", r.APIGroups) && !containedIn(a.GetAPIGroup(), r.APIGroups) {
+		return false
+	}
+	if !r.resourceMatches(a.GetResource(), a.GetSubresource()) {
+		return false
+	}
+
+	return len(r.ResourceNames) == 0 || containedIn(a.GetName(), r.ResourceNames)
+}
+
+// resourceMatches checks the resource and subresource; a rule resource is *, resource,
+// resource/subresource or */subresource
+func (r *rbacRule) resourceMatches(resource, subresource string) bool {
+	combined := resource
+	if subresource != "" {
+		combined += "/" + subresource
+	}
+	for _, x := range r.Resources {
+		switch {
+		case x == "*", x == combined:
+			return true
+		case subresource != "" && x == "*/"+subresource:
+			return true
+		}
+	}
+
+	return false
+}
+
+// nonResourceURLMatches checks the path, a trailing * is a prefix match
+func (r *rbacRule) nonResourceURLMatches(path string) bool {
+	for _, x := range r.NonResourceURLs {
+		switch {
+		case x == "*", x == path:
+			return true
+		case strings.HasSuffix(x, "*") && strings.HasPrefix(path, strings.TrimSuffix(x, "*")):
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,498 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// rbacExportPrefix is the default prefix of the generated role names
+	rbacExportPrefix = "kube-auth"
+	// rbacSampleName is the value used in the samples for values not named by the policy
+	rbacSampleName = "kube-auth-sample"
+	// maxRBACDifferences is the number of differences printed by the export
+	maxRBACDifferences = 20
+)
+
+// rbacExport is an abac policy converted into rbac objects
+type rbacExport struct {
+	// Objects are the generated roles and bindings
+	Objects []*rbacObject
+	// Problems are the abac semantics which could not be expressed in rbac
+	Problems []string
+}
+
+// rbacGrant is a rule given to the subjects of an abac rule, an empty namespace is cluster wide
+type rbacGrant struct {
+	namespace string
+	rule      *rbacRule
+}
+
+// exportRBAC converts the rules into rbac; the rules of each subject are collected per namespace,
+// the subjects with identical rules in a namespace share a binding and identical rules are
+// a single role. A role only used in one namespace is a Role, otherwise a ClusterRole.
+func exportRBAC(rules policyList, prefix string) *rbacExport {
+	export := new(rbacExport)
+
+	// step: collect the rules of each subject in each namespace
+	scopes := make(map[string]map[string]map[string]*rbacRule, 0)
+	subjects := make(map[string]*rbacSubject, 0)
+	for _, p := range rules {
+		subs, grants, problems := p.toRBAC()
+		for _, x := range problems {
+			export.Problems = append(export.Problems, p.location()+": "+x)
+		}
+		for _, subject := range subs {
+			sk := rbacKey(subject)
+			subjects[sk] = subject
+			for _, x := range grants {
+				if _, found := scopes[x.namespace]; !found {
+					scopes[x.namespace] = make(map[string]map[string]*rbacRule, 0)
+				}
+				if _, found := scopes[x.namespace][sk]; !found {
+					scopes[x.namespace][sk] = make(map[string]*rbacRule, 0)
+				}
+				scopes[x.namespace][sk][rbacKey(x.rule)] = x.rule
+			}
+		}
+	}
+
+	// step: group the subjects with the same rules in a namespace
+	type binding struct {
+		namespace, set string
+		subjects       []*rbacSubject
+	}
+	sets := make(map[string][]*rbacRule, 0)
+	usage := make(map[string]map[string]bool, 0)
+	bindings := make(map[string]*binding, 0)
+	for namespace, bySubject := range scopes {
+		for sk, byRule := range bySubject {
+			var keys []string
+			for k := range byRule {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			set := strings.Join(keys, "\n")
+			if _, found := sets[set]; !found {
+				for _, k := range keys {
+					sets[set] = append(sets[set], byRule[k])
+				}
+				usage[set] = make(map[string]bool, 0)
+			}
+			usage[set][namespace] = true
+			b, found := bindings[namespace+"\x00"+set]
+			if !found {
+				b = &binding{namespace: namespace, set: set}
+				bindings[namespace+"\x00"+set] = b
+			}
+			b.subjects = append(b.subjects, subjects[sk])
+		}
+	}
+
+	// step: generate the roles, named by a hash of their rules so the names are stable
+	refs := make(map[string]*rbacRoleRef, 0)
+	for set, list := range sets {
+		name := fmt.Sprintf("%s-%x", prefix, md5.Sum([]byte(set)))[:len(prefix)+9]
+		role := &rbacObject{
+			APIVersion: rbacAPIVersion,
+			Kind:       "ClusterRole",
+			Metadata:   rbacMetadata{Name: name},
+			Rules:      list,
+		}
+		if len(usage[set]) == 1 && !usage[set][""] {
+			for namespace := range usage[set] {
+				role.Kind, role.Metadata.Namespace = "Role", namespace
+			}
+		}
+		refs[set] = &rbacRoleRef{APIGroup: rbacAPIGroup, Kind: role.Kind, Name: name}
+		export.Objects = append(export.Objects, role)
+	}
+	for _, b := range bindings {
+		sort.Stable(rbacSubjects(b.subjects))
+		object := &rbacObject{
+			APIVersion: rbacAPIVersion,
+			Kind:       "ClusterRoleBinding",
+			Metadata:   rbacMetadata{Name: refs[b.set].Name},
+			Subjects:   b.subjects,
+			RoleRef:    refs[b.set],
+		}
+		if b.namespace != "" {
+			object.Kind, object.Metadata.Namespace = "RoleBinding", b.namespace
+		}
+		export.Objects = append(export.Objects, object)
+	}
+	sort.Stable(rbacObjects(export.Objects))
+
+	return export
+}
+
+// toRBAC converts the rule into the subjects and rules; the problems are the semantics of the
+// rule which rbac can't express. A rule which would be broadened by the conversion is skipped.
+func (p *policy) toRBAC() ([]*rbacSubject, []*rbacGrant, []string) {
+	var problems []string
+	skip := func(reason string) ([]*rbacSubject, []*rbacGrant, []string) {
+		return nil, nil, append(problems, reason+", the rule is skipped")
+	}
+	if p.hasTimeConditions() {
+		return skip("time conditions can't be expressed")
+	}
+	if len(p.Spec.Extra) > 0 {
+		return skip("extra attributes can't be expressed")
+	}
+
+	// step: work out the subjects
+	var subjects []*rbacSubject
+	username, group := p.Spec.User, p.Spec.Group
+	switch {
+	case username == "" && group == "":
+		return nil, nil, nil
+	case username != "" && username != "*" && group != "" && group != "*":
+		return skip("a rule for both a user and a group can't be expressed")
+	case username != "" && username != "*":
+		subjects = []*rbacSubject{{Kind: "User", APIGroup: rbacAPIGroup, Name: username}}
+	case group != "" && group != "*":
+		subjects = []*rbacSubject{{Kind: "Group", APIGroup: rbacAPIGroup, Name: group}}
+	default:
+		subjects = []*rbacSubject{
+			{Kind: "Group", APIGroup: rbacAPIGroup, Name: authenticatedGroup},
+			{Kind: "Group", APIGroup: rbacAPIGroup, Name: unauthenticatedGroup},
+		}
+		problems = append(problems, "* can't be expressed, it is bound to the "+authenticatedGroup+" and "+unauthenticatedGroup+" groups")
+	}
+
+	// step: work out the verbs
+	verbs := []string{"*"}
+	if len(p.Spec.Verbs) > 0 && !containedIn("*", p.Spec.Verbs) {
+		verbs = append([]string{}, p.Spec.Verbs...)
+		sort.Strings(verbs)
+	}
+	if p.Spec.Readonly {
+		if containedIn("*", verbs) {
+			verbs = readVerbs
+		} else {
+			var filtered []string
+			for _, x := range verbs {
+				if containedIn(x, readVerbs) {
+					filtered = append(filtered, x)
+				}
+			}
+			if len(filtered) == 0 {
+				return skip("readonly excludes all the verbs")
+			}
+			verbs = filtered
+		}
+	}
+
+	var grants []*rbacGrant
+	if p.Spec.Resource != "" || len(p.Spec.Subresources) > 0 {
+		namespace := p.Spec.Namespace
+		switch {
+		case namespace == "*":
+			namespace = ""
+		case namespace == "":
+			return skip("limiting a rule to cluster scoped resources can't be expressed")
+		case isPattern(namespace):
+			return skip(fmt.Sprintf("the namespace pattern %q can't be expressed", namespace))
+		}
+		for _, x := range []struct{ name, rule string }{
+			{name: "apiGroup", rule: p.Spec.APIGroup},
+			{name: "resource", rule: p.Spec.Resource},
+			{name: "name", rule: p.Spec.Name},
+		} {
+			if x.rule != "*" && isPattern(x.rule) {
+				return skip(fmt.Sprintf("the %s pattern %q can't be expressed", x.name, x.rule))
+			}
+		}
+		if p.Spec.Name == "*" {
+			return skip("the name * can't be expressed")
+		}
+
+		rule := &rbacRule{Verbs: verbs, APIGroups: []string{p.Spec.APIGroup}}
+		switch {
+		case p.Spec.Resource == "*" && len(p.Spec.Subresources) > 0:
+			return skip("limiting the subresources of all resources can't be expressed")
+		case p.Spec.Resource != "":
+			rule.Resources = append(rule.Resources, p.Spec.Resource)
+			if p.Spec.Resource != "*" && len(p.Spec.Subresources) == 0 {
+				problems = append(problems, fmt.Sprintf("the subresources of %s can't be expressed, list them in subresources", p.Spec.Resource))
+			}
+		}
+		for _, x := range p.Spec.Subresources {
+			if isPattern(x) && !(strings.HasPrefix(x, "*/") && !isPattern(x[2:])) {
+				return skip(fmt.Sprintf("the subresource pattern %q can't be expressed", x))
+			}
+			rule.Resources = append(rule.Resources, x)
+		}
+		if p.Spec.Name != "" {
+			rule.ResourceNames = []string{p.Spec.Name}
+		}
+		grants = append(grants, &rbacGrant{namespace: namespace, rule: rule})
+	}
+	if path := p.Spec.NonResourcePath; path != "" {
+		if isRegexPattern(path) || strings.Contains(path, "?") || strings.Contains(strings.TrimSuffix(path, "*"), "*") {
+			return skip(fmt.Sprintf("the path pattern %q can't be expressed, only a trailing * is supported", path))
+		}
+		grants = append(grants, &rbacGrant{rule: &rbacRule{Verbs: verbs, NonResourceURLs: []string{path}}})
+	}
+
+	return subjects, grants, problems
+}
+
+// YAML returns the objects as a multi document yaml stream
+func (e *rbacExport) YAML() ([]byte, error) {
+	b := new(bytes.Buffer)
+	for i, x := range e.Objects {
+		encoded, err := yaml.Marshal(x)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.Write(encoded)
+	}
+
+	return b.Bytes(), nil
+}
+
+// rbacDifference is a request on which the abac policy and rbac objects disagree
+type rbacDifference struct {
+	attributes authorizer.Attributes
+	// abac is the decision of the abac policy
+	abac bool
+}
+
+// String returns a description of the difference
+func (d *rbacDifference) String() string {
+	decisions := "abac allowed, rbac denied"
+	if !d.abac {
+		decisions = "abac denied, rbac allowed"
+	}
+
+	return describeAttributes(d.attributes) + ": " + decisions
+}
+
+// verifyRBACExport evaluates a sample of requests against both the rules and the objects,
+// returning the number of requests checked and those with different decisions
+func verifyRBACExport(rules policyList, objects []*rbacObject, max int) (int, []*rbacDifference, error) {
+	rbac, err := newRBACAuthorizer(objects)
+	if err != nil {
+		return 0, nil, err
+	}
+	samples := rbacSamples(rules, max)
+
+	var differences []*rbacDifference
+	now := time.Now()
+	for _, x := range samples {
+		abac, _, _ := rules.authorize(x, now)
+		allowed, _, _ := rbac.Authorize(x)
+		if abac != allowed {
+			differences = append(differences, &rbacDifference{attributes: x, abac: abac})
+		}
+	}
+
+	return len(samples), differences, nil
+}
+
+// rbacSamples generates requests from the values named by the rules plus values named by
+// none of them; if there are more than max combinations an even spread of them is taken
+func rbacSamples(rules policyList, max int) []authorizer.Attributes {
+	users := []user.Info{&user.DefaultInfo{Name: rbacSampleName, Groups: []string{authenticatedGroup}}}
+	namespaces := newSampleSet(rbacSampleName, "")
+	apiGroups := newSampleSet(rbacSampleName, "")
+	resources := newSampleSet(rbacSampleName)
+	names := newSampleSet("")
+	verbs := newSampleSet("get", "list", "watch", "create", "update", "patch", "delete")
+	paths := newSampleSet("/" + rbacSampleName)
+
+	seen := make(map[string]bool, 0)
+	for _, p := range rules {
+		if x := p.Spec.User; x != "" && x != "*" && !seen["user:"+x] {
+			seen["user:"+x] = true
+			users = append(users, &user.DefaultInfo{Name: x, Groups: []string{authenticatedGroup}})
+		}
+		if x := p.Spec.Group; x != "" && x != "*" && !seen["group:"+x] {
+			seen["group:"+x] = true
+			users = append(users, &user.DefaultInfo{Name: rbacSampleName + ":" + x, Groups: []string{x, authenticatedGroup}})
+		}
+		namespaces.add(p.Spec.Namespace)
+		apiGroups.add(p.Spec.APIGroup)
+		if p.Spec.Resource != "" {
+			resources.add(p.Spec.Resource)
+		}
+		resources.add(p.Spec.Subresources...)
+		names.add(p.Spec.Name)
+		verbs.add(p.Spec.Verbs...)
+		if path := p.Spec.NonResourcePath; path != "" && path != "*" {
+			paths.add(strings.TrimSuffix(path, "*"))
+			if strings.HasSuffix(path, "*") {
+				paths.add(strings.TrimSuffix(path, "*") + rbacSampleName)
+			}
+		}
+	}
+
+	var samples []authorizer.Attributes
+	dimensions := []int{len(users), len(verbs.items), len(namespaces.items), len(apiGroups.items), len(resources.items), len(names.items)}
+	for _, i := range sampleIndexes(dimensions, max) {
+		resource := resources.items[i[4]]
+		var subresource string
+		if parts := strings.SplitN(resource, "/", 2); len(parts) == 2 {
+			resource, subresource = parts[0], parts[1]
+		}
+		samples = append(samples, &authorizer.AttributesRecord{
+			User:            users[i[0]],
+			Verb:            verbs.items[i[1]],
+			Namespace:       namespaces.items[i[2]],
+			APIGroup:        apiGroups.items[i[3]],
+			Resource:        resource,
+			Subresource:     subresource,
+			Name:            names.items[i[5]],
+			ResourceRequest: true,
+		})
+	}
+	for _, i := range sampleIndexes([]int{len(users), len(verbs.items), len(paths.items)}, max) {
+		samples = append(samples, &authorizer.AttributesRecord{
+			User: users[i[0]],
+			Verb: verbs.items[i[1]],
+			Path: paths.items[i[2]],
+		})
+	}
+
+	return samples
+}
+
+// sampleSet is an ordered set of the exact values named by the rules
+type sampleSet struct {
+	items []string
+	seen  map[string]bool
+}
+
+// newSampleSet creates a set with the values
+func newSampleSet(values ...string) *sampleSet {
+	s := &sampleSet{seen: make(map[string]bool, 0)}
+	s.add(values...)
+
+	return s
+}
+
+// add adds the values, ignoring the wildcards and patterns
+func (s *sampleSet) add(values ...string) {
+	for _, x := range values {
+		if s.seen[x] || (x != "" && isPattern(x)) {
+			continue
+		}
+		s.seen[x] = true
+		s.items = append(s.items, x)
+	}
+}
+
+// sampleIndexes returns the indexes into each dimension of up to max combinations, spread
+// evenly across all the combinations
+func sampleIndexes(dimensions []int, max int) [][]int {
+	total := 1
+	for _, x := range dimensions {
+		total *= x
+	}
+	stride := 1
+	if max > 0 && total > max {
+		stride = (total + max - 1) / max
+	}
+
+	var list [][]int
+	for n := 0; n < total; n += stride {
+		indexes := make([]int, len(dimensions))
+		remainder := n
+		for i := len(dimensions) - 1; i >= 0; i-- {
+			indexes[i] = remainder % dimensions[i]
+			remainder /= dimensions[i]
+		}
+		list = append(list, indexes)
+	}
+
+	return list
+}
+
+// describeAttributes returns a single line description of the request
+func describeAttributes(a authorizer.Attributes) string {
+	var username string
+	var groups []string
+	if u := a.GetUser(); u != nil {
+		username, groups = u.GetName(), u.GetGroups()
+	}
+	subject := fmt.Sprintf("user=%s groups=%s verb=%s", username, strings.Join(groups, ","), a.GetVerb())
+	if !a.IsResourceRequest() {
+		return subject + " path=" + a.GetPath()
+	}
+	resource := a.GetResource()
+	if a.GetSubresource() != "" {
+		resource += "/" + a.GetSubresource()
+	}
+
+	return fmt.Sprintf("%s namespace=%s apiGroup=%s resource=%s name=%s", subject, a.GetNamespace(), a.GetAPIGroup(), resource, a.GetName())
+}
+
+// rbacKey returns a key which is the same for identical subjects or rules
+func rbacKey(v interface{}) string {
+	encoded, _ := json.Marshal(v)
+
+	return string(encoded)
+}
+
+// rbacSubjects sorts the subjects by kind and name
+type rbacSubjects []*rbacSubject
+
+func (s rbacSubjects) Len() int      { return len(s) }
+func (s rbacSubjects) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s rbacSubjects) Less(i, j int) bool {
+	if s[i].Kind != s[j].Kind {
+		return s[i].Kind < s[j].Kind
+	}
+
+	return s[i].Name < s[j].Name
+}
+
+// rbacObjects sorts the objects roles first, then by namespace and name
+type rbacObjects []*rbacObject
+
+// rbacKindOrder is the order of the kinds in the output
+var rbacKindOrder = map[string]int{"ClusterRole": 0, "Role": 1, "ClusterRoleBinding": 2, "RoleBinding": 3}
+
+func (o rbacObjects) Len() int      { return len(o) }
+func (o rbacObjects) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o rbacObjects) Less(i, j int) bool {
+	if o[i].Kind != o[j].Kind {
+		return rbacKindOrder[o[i].Kind] < rbacKindOrder[o[j].Kind]
+	}
+	if o[i].Metadata.Namespace != o[j].Metadata.Namespace {
+		return o[i].Metadata.Namespace < o[j].Metadata.Namespace
+	}
+
+	return o[i].Metadata.Name < o[j].Metadata.Name
+}
@@ -0,0 +1,176 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeTestPolicies decodes the json rules
+func decodeTestPolicies(t *testing.T, lines ...string) policyList {
+	var list policyList
+	for i, x := range lines {
+		p, err := decodePolicy([]byte(x))
+		if !assert.NoError(t, err, "rule %d", i) {
+			continue
+		}
+		p.file, p.line = "policy.jsonl", i+1
+		list = append(list, p)
+	}
+
+	return list
+}
+
+func TestExportRBAC(t *testing.T) {
+	rules := decodeTestPolicies(t,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"admin","namespace":"*","resource":"*","apiGroup":"*","nonResourcePath":"*"}}`,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"dev","namespace":"dev","resource":"pods","apiGroup":"","subresources":["pods/log"],"verbs":["get","list"]}}`,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"qa","namespace":"dev","resource":"pods","apiGroup":"","subresources":["pods/log"],"verbs":["list","get"]}}`,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"group":"qa","namespace":"qa","resource":"pods","apiGroup":"","subresources":["pods/log"],"verbs":["get","list"]}}`,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"prod","resource":"secrets","apiGroup":"","name":"db","readonly":true,"verbs":["get","delete"],"subresources":["secrets/status"]}}`,
+	)
+	export := exportRBAC(rules, "test")
+	assert.Empty(t, export.Problems)
+
+	var kinds []string
+	for _, x := range export.Objects {
+		kinds = append(kinds, x.Kind)
+	}
+	assert.Equal(t, []string{"ClusterRole", "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "RoleBinding", "RoleBinding"}, kinds)
+
+	// @check the dev and qa groups share a binding in dev and the role is shared with qa
+	for _, x := range export.Objects {
+		if x.Kind == "RoleBinding" && x.Metadata.Namespace == "dev" {
+			assert.Len(t, x.Subjects, 2)
+			assert.Equal(t, "ClusterRole", x.RoleRef.Kind)
+		}
+		if x.Kind == "Role" {
+			assert.Equal(t, "prod", x.Metadata.Namespace)
+			assert.Equal(t, []*rbacRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets", "secrets/status"}, ResourceNames: []string{"db"}}}, x.Rules)
+		}
+		assert.True(t, strings.HasPrefix(x.Metadata.Name, "test-"))
+		assert.Len(t, x.Metadata.Name, 13)
+	}
+
+	checked, differences, err := verifyRBACExport(rules, export.Objects, 0)
+	assert.NoError(t, err)
+	assert.NotZero(t, checked)
+	assert.Empty(t, differences)
+
+	encoded, err := export.YAML()
+	assert.NoError(t, err)
+	assert.Equal(t, len(export.Objects)-1, strings.Count(string(encoded), "---\n"))
+
+	// @check the manifests can be read back as an rbac source
+	f, err := writeTestFile(string(encoded))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	objects, err := readRBACManifests(f.Name())
+	assert.NoError(t, err)
+	_, differences, err = verifyRBACExport(rules, objects, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, differences)
+}
+
+func TestExportRBACProblems(t *testing.T) {
+	cs := []struct {
+		Rule    string
+		Problem string
+		Skipped bool
+	}{
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"*","readonly":true,"nonResourcePath":"/version"}}`,
+			Problem: "* can't be expressed",
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"dev","resource":"pods","apiGroup":""}}`,
+			Problem: "the subresources of pods can't be expressed",
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"te-*","resource":"*","apiGroup":"*"}}`,
+			Problem: `the namespace pattern "te-*"`,
+			Skipped: true,
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","readonly":true,"nonResourcePath":"/api*/v1"}}`,
+			Problem: "only a trailing * is supported",
+			Skipped: true,
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","group":"dev","namespace":"*","resource":"*","apiGroup":"*"}}`,
+			Problem: "both a user and a group",
+			Skipped: true,
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"*","resource":"*","apiGroup":"*","subresources":["pods/log"]}}`,
+			Problem: "limiting the subresources of all resources",
+			Skipped: true,
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"","resource":"nodes","apiGroup":""}}`,
+			Problem: "cluster scoped resources",
+			Skipped: true,
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"*","resource":"*","apiGroup":"*","extra":{"scopes":"admin"}}}`,
+			Problem: "extra attributes",
+			Skipped: true,
+		},
+		{
+			Rule:    `{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"*","resource":"*","apiGroup":"*","readonly":true,"verbs":["delete"]}}`,
+			Problem: "readonly excludes all the verbs",
+			Skipped: true,
+		},
+	}
+	for i, c := range cs {
+		rules := decodeTestPolicies(t, c.Rule)
+		export := exportRBAC(rules, rbacExportPrefix)
+		if !assert.Len(t, export.Problems, 1, "case %d", i) {
+			continue
+		}
+		assert.Contains(t, export.Problems[0], c.Problem, "case %d", i)
+		assert.True(t, strings.HasPrefix(export.Problems[0], "policy.jsonl:1: "), "case %d", i)
+		assert.Equal(t, c.Skipped, len(export.Objects) == 0, "case %d", i)
+	}
+}
+
+func TestVerifyRBACExportDifferences(t *testing.T) {
+	rules := decodeTestPolicies(t,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"jane","namespace":"prod","resource":"pods","apiGroup":"","readonly":true}}`,
+		`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"bob","namespace":"prod","resource":"pods","apiGroup":"","subresources":["pods/log"]}}`,
+	)
+	export := exportRBAC(rules, rbacExportPrefix)
+	_, differences, err := verifyRBACExport(rules, export.Objects, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, differences, 3) {
+		assert.Equal(t, "user=jane groups=system:authenticated verb=get namespace=prod apiGroup= resource=pods/log name=: abac allowed, rbac denied", differences[0].String())
+	}
+}
+
+func TestSampleIndexes(t *testing.T) {
+	assert.Len(t, sampleIndexes([]int{2, 3, 4}, 0), 24)
+	assert.Equal(t, []int{1, 2, 3}, sampleIndexes([]int{2, 3, 4}, 0)[23])
+	assert.Len(t, sampleIndexes([]int{10, 10, 10}, 100), 100)
+	assert.Len(t, sampleIndexes([]int{10, 10, 10}, 300), 250)
+}
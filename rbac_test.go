@@ -0,0 +1,348 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRBACAuthorizer(t *testing.T) {
+	objects := []*rbacObject{
+		{
+			Kind:     "ClusterRole",
+			Metadata: rbacMetadata{Name: "view"},
+			Rules: []*rbacRule{
+				{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods", "*/status"}},
+				{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz", "/apis/*"}},
+			},
+		},
+		{
+			Kind:     "Role",
+			Metadata: rbacMetadata{Name: "config", Namespace: "dev"},
+			Rules: []*rbacRule{
+				{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"configmaps"}, ResourceNames: []string{"app"}},
+			},
+		},
+		{
+			Kind:     "ClusterRoleBinding",
+			Metadata: rbacMetadata{Name: "view"},
+			Subjects: []*rbacSubject{{Kind: "Group", Name: "viewers"}},
+			RoleRef:  &rbacRoleRef{Kind: "ClusterRole", Name: "view"},
+		},
+		{
+			Kind:     "RoleBinding",
+			Metadata: rbacMetadata{Name: "view", Namespace: "prod"},
+			Subjects: []*rbacSubject{{Kind: "User", Name: "jane"}},
+			RoleRef:  &rbacRoleRef{Kind: "ClusterRole", Name: "view"},
+		},
+		{
+			Kind:     "RoleBinding",
+			Metadata: rbacMetadata{Name: "config", Namespace: "dev"},
+			Subjects: []*rbacSubject{{Kind: "ServiceAccount", Name: "deployer"}},
+			RoleRef:  &rbacRoleRef{Kind: "Role", Name: "config"},
+		},
+	}
+	r, err := newRBACAuthorizer(objects)
+	if !assert.NoError(t, err) {
+		return
+	}
+	viewer := &user.DefaultInfo{Name: "bob", Groups: []string{"viewers"}}
+	jane := &user.DefaultInfo{Name: "jane"}
+	deployer := &user.DefaultInfo{Name: "system:serviceaccount:dev:deployer"}
+	cs := []struct {
+		Attributes authorizer.AttributesRecord
+		Expected   bool
+	}{
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Namespace: "dev", Resource: "pods", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Resource: "pods", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "delete", Namespace: "dev", Resource: "pods", ResourceRequest: true}},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Namespace: "dev", Resource: "pods", Subresource: "log", ResourceRequest: true}},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Namespace: "dev", Resource: "services", Subresource: "status", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Namespace: "dev", APIGroup: "apps", Resource: "pods", ResourceRequest: true}},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Path: "/healthz"}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Path: "/apis/apps"}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: viewer, Verb: "get", Path: "/api"}},
+		{Attributes: authorizer.AttributesRecord{User: jane, Verb: "list", Namespace: "prod", Resource: "pods", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: jane, Verb: "list", Namespace: "dev", Resource: "pods", ResourceRequest: true}},
+		{Attributes: authorizer.AttributesRecord{User: jane, Verb: "get", Path: "/healthz"}},
+		{Attributes: authorizer.AttributesRecord{User: deployer, Verb: "update", Namespace: "dev", APIGroup: "", Resource: "configmaps", Name: "app", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: deployer, Verb: "update", Namespace: "dev", APIGroup: "", Resource: "configmaps", Name: "other", ResourceRequest: true}},
+		{Attributes: authorizer.AttributesRecord{User: deployer, Verb: "update", Namespace: "prod", APIGroup: "", Resource: "configmaps", Name: "app", ResourceRequest: true}},
+	}
+	for i, c := range cs {
+		allowed, _, err := r.Authorize(c.Attributes)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Expected, allowed, "case %d", i)
+	}
+	_, reason, _ := r.Authorize(cs[9].Attributes)
+	assert.Equal(t, `allowed by RoleBinding "view" of ClusterRole "view" to User "jane"`, reason)
+}
+
+func TestRBACAuthorizerBad(t *testing.T) {
+	cs := [][]*rbacObject{
+		{{Kind: "ServiceAccount", Metadata: rbacMetadata{Name: "test"}}},
+		{{Kind: "RoleBinding", Metadata: rbacMetadata{Name: "test", Namespace: "dev"}}},
+		{{Kind: "ClusterRoleBinding", Metadata: rbacMetadata{Name: "test"}, RoleRef: &rbacRoleRef{Kind: "Role", Name: "test"}}},
+	}
+	for i, c := range cs {
+		_, err := newRBACAuthorizer(c)
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+const testRBACManifests = `# a mixed manifest
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: deployer
+  namespace: dev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: monitoring
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.example.com/aggregate-to-monitoring: "true"
+rules: []
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRole
+  metadata:
+    name: metrics
+    labels:
+      rbac.example.com/aggregate-to-monitoring: "true"
+  rules:
+  - nonResourceURLs: ["/metrics*"]
+    verbs: ["get"]
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRole
+  metadata:
+    name: pod-reader
+    labels:
+      rbac.example.com/aggregate-to-monitoring: "true"
+  rules:
+  - apiGroups: [""]
+    resources: ["pods", "pods/log"]
+    verbs: ["get", "list", "watch"]
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRoleBinding
+  metadata:
+    name: monitoring
+  subjects:
+  - kind: Group
+    name: monitoring
+    apiGroup: rbac.authorization.k8s.io
+  roleRef:
+    kind: ClusterRole
+    name: monitoring
+    apiGroup: rbac.authorization.k8s.io
+`
+
+func TestReadRBACManifests(t *testing.T) {
+	f, err := writeTestFile(testRBACManifests)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+
+	objects, err := readRBACManifests(f.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+	var names []string
+	for _, x := range objects {
+		names = append(names, x.Kind+"/"+x.Metadata.Name)
+	}
+	assert.Equal(t, []string{"ClusterRole/monitoring", "ClusterRole/metrics", "ClusterRole/pod-reader", "ClusterRoleBinding/monitoring"}, names)
+
+	r, err := newRBACAuthorizerFromPath(f.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+	monitor := &user.DefaultInfo{Name: "prometheus", Groups: []string{"monitoring"}}
+	cs := []struct {
+		Attributes authorizer.AttributesRecord
+		Expected   bool
+	}{
+		{Attributes: authorizer.AttributesRecord{User: monitor, Verb: "get", Path: "/metrics"}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: monitor, Verb: "get", Path: "/metrics/cadvisor"}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: monitor, Verb: "get", Path: "/logs"}},
+		{Attributes: authorizer.AttributesRecord{User: monitor, Verb: "watch", Namespace: "dev", Resource: "pods", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: monitor, Verb: "get", Namespace: "dev", Resource: "pods", Subresource: "log", ResourceRequest: true}, Expected: true},
+		{Attributes: authorizer.AttributesRecord{User: monitor, Verb: "get", Namespace: "dev", Resource: "pods", Subresource: "exec", ResourceRequest: true}},
+		{Attributes: authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "jane"}, Verb: "get", Path: "/metrics"}},
+	}
+	for i, c := range cs {
+		allowed, _, err := r.Authorize(c.Attributes)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Expected, allowed, "case %d", i)
+	}
+}
+
+func TestReadRBACManifestsBad(t *testing.T) {
+	cs := []string{
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nrules: []\n",
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: test\nrules: {}\n",
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: test\n---\napiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: test\n",
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: Role\nmetadata:\n  name: test\n",
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: Unknown\nmetadata:\n  name: test\n",
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata: [\n",
+		"kind: List\nitems:\n- apiVersion: rbac.authorization.k8s.io/v1\n  kind: RoleBinding\n  metadata:\n    name: test\n    namespace: dev\n",
+	}
+	for i, c := range cs {
+		f, err := writeTestFile(c)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		_, err = newRBACAuthorizerFromPath(f.Name())
+		assert.Error(t, err, "case %d", i)
+		os.Remove(f.Name())
+	}
+}
+
+func TestRBACAggregation(t *testing.T) {
+	objects := []*rbacObject{
+		{
+			Kind:     "ClusterRole",
+			Metadata: rbacMetadata{Name: "admin"},
+			AggregationRule: &rbacAggregationRule{ClusterRoleSelectors: []*labelSelector{
+				{MatchLabels: map[string]string{"aggregate-to-admin": "true"}},
+			}},
+			Rules: []*rbacRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+		},
+		{
+			Kind:     "ClusterRole",
+			Metadata: rbacMetadata{Name: "edit", Labels: map[string]string{"aggregate-to-admin": "true"}},
+			AggregationRule: &rbacAggregationRule{ClusterRoleSelectors: []*labelSelector{
+				{MatchExpressions: []*labelSelectorRequirement{{Key: "aggregate-to", Operator: "In", Values: []string{"edit", "admin"}}}},
+			}},
+		},
+		{
+			Kind:     "ClusterRole",
+			Metadata: rbacMetadata{Name: "deployments", Labels: map[string]string{"aggregate-to": "edit"}},
+			Rules:    []*rbacRule{{Verbs: []string{"update"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}}},
+		},
+		{
+			Kind:     "RoleBinding",
+			Metadata: rbacMetadata{Name: "admin", Namespace: "dev"},
+			Subjects: []*rbacSubject{{Kind: "User", Name: "jane"}},
+			RoleRef:  &rbacRoleRef{Kind: "ClusterRole", Name: "admin"},
+		},
+	}
+	r, err := newRBACAuthorizer(objects)
+	if !assert.NoError(t, err) {
+		return
+	}
+	jane := &user.DefaultInfo{Name: "jane"}
+	allowed, _, _ := r.Authorize(authorizer.AttributesRecord{User: jane, Verb: "update", Namespace: "dev", APIGroup: "apps", Resource: "deployments", ResourceRequest: true})
+	assert.True(t, allowed)
+	// @check the rules of an aggregated role are replaced by the aggregated rules
+	allowed, _, _ = r.Authorize(authorizer.AttributesRecord{User: jane, Verb: "delete", Namespace: "dev", Resource: "pods", ResourceRequest: true})
+	assert.False(t, allowed)
+	assert.Len(t, objects[0].Rules, 1, "the objects should not be altered")
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend"}
+	cs := []struct {
+		Selector labelSelector
+		Expected bool
+	}{
+		{Selector: labelSelector{}, Expected: true},
+		{Selector: labelSelector{MatchLabels: map[string]string{"app": "web"}}, Expected: true},
+		{Selector: labelSelector{MatchLabels: map[string]string{"app": "web", "tier": "backend"}}},
+		{Selector: labelSelector{MatchExpressions: []*labelSelectorRequirement{{Key: "app", Operator: "In", Values: []string{"api", "web"}}}}, Expected: true},
+		{Selector: labelSelector{MatchExpressions: []*labelSelectorRequirement{{Key: "app", Operator: "NotIn", Values: []string{"web"}}}}},
+		{Selector: labelSelector{MatchExpressions: []*labelSelectorRequirement{{Key: "env", Operator: "NotIn", Values: []string{"prod"}}}}, Expected: true},
+		{Selector: labelSelector{MatchExpressions: []*labelSelectorRequirement{{Key: "tier", Operator: "Exists"}}}, Expected: true},
+		{Selector: labelSelector{MatchExpressions: []*labelSelectorRequirement{{Key: "tier", Operator: "DoesNotExist"}}}},
+		{Selector: labelSelector{MatchExpressions: []*labelSelectorRequirement{{Key: "tier", Operator: "Bad"}}}},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, c.Selector.matches(labels), "case %d", i)
+	}
+}
+
+func TestRBACDirectoryChange(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "kube-auth.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("failed to create the manifests directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	binding := func(username string) string {
+		return "apiVersion: rbac.authorization.k8s.io/v1\nkind: RoleBinding\nmetadata:\n  name: " + username +
+			"\n  namespace: default\nsubjects:\n- kind: User\n  name: " + username +
+			"\nroleRef:\n  kind: ClusterRole\n  name: view\n  apiGroup: rbac.authorization.k8s.io\n"
+	}
+	writeTestPolicyDirectory(t, dir, map[string]string{
+		"roles/view.yaml":   "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: view\nrules:\n- apiGroups: ['']\n  resources: [pods]\n  verbs: [get]\n",
+		"teams/a-team.yaml": binding("a-user"),
+	})
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{authorizers: []string{"rbac:" + dir}})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	allowed := func(username string) bool {
+		status, err := makeTestAuthzRequest(s.URL(), v1beta1.SubjectAccessReview{
+			Spec: v1beta1.SubjectAccessReviewSpec{
+				User:               username,
+				ResourceAttributes: &v1beta1.ResourceAttributes{Resource: "pods", Namespace: "default", Verb: "get"},
+			},
+		})
+		assert.NoError(t, err)
+		return status.Status.Allowed
+	}
+	assert.True(t, allowed("a-user"))
+	assert.False(t, allowed("b-user"))
+
+	// step: add a binding
+	writeTestPolicyDirectory(t, dir, map[string]string{"teams/b-team.yaml": binding("b-user")})
+	time.Sleep(800 * time.Millisecond)
+	assert.True(t, allowed("b-user"))
+
+	// step: a broken manifest should leave the current bindings in place
+	writeTestPolicyDirectory(t, dir, map[string]string{"teams/c-team.yaml": "kind: [\n"})
+	time.Sleep(800 * time.Millisecond)
+	assert.True(t, allowed("a-user"))
+
+	// step: remove the manifests
+	os.Remove(filepath.Join(dir, "teams", "c-team.yaml"))
+	os.Remove(filepath.Join(dir, "teams", "a-team.yaml"))
+	time.Sleep(800 * time.Millisecond)
+	assert.False(t, allowed("a-user"))
+	assert.True(t, allowed("b-user"))
+}
@@ -21,14 +21,16 @@ import (
 	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
+	_ "net/http/pprof"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
-
-	"k8s.io/kubernetes/pkg/auth/authorizer/abac"
-	"k8s.io/kubernetes/plugin/pkg/auth/authenticator/token/tokenfile"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
@@ -38,11 +40,31 @@ import (
 // is the service wrapper
 type service struct {
 	sync.RWMutex
-	cfg    *options
-	engine *gin.Engine
-	tokens authentication
-	authz  authorization
-	files  map[string][16]byte
+	cfg        *options
+	engine     *gin.Engine
+	admin      *gin.Engine
+	tokens     *tokenFileSet
+	upstream   authentication
+	jwt        authentication
+	authz      *unionAuthorizer
+	audit      *auditor
+	capture    *auditor
+	shadow     *unionAuthorizer
+	breakGlass *breakGlassStore
+	delegation *delegationStore
+	metrics    *serviceMetrics
+	diffs      *policyDiffHistory
+	mapping    *identityMapping
+	files      map[string][16]byte
+	watcher    *fsnotify.Watcher
+	sockets    []*systemdSocket
+	// shadowQueue holds the requests waiting to be evaluated against the shadow policy
+	shadowQueue chan *shadowRequest
+	// serving is the certificate held by the webhook listener
+	serving *x509.Certificate
+	// reloadErrors are the failures of the last reload of each file or directory
+	reloadErrors map[string]error
+	draining     int32
 }
 
 // newService is responsible for creating the service
@@ -62,8 +84,18 @@ func newService(o options) (*service, error) {
 	}
 
 	s := &service{
-		cfg:   &o,
-		files: make(map[string][16]byte, 0),
+		cfg:          &o,
+		files:        make(map[string][16]byte, 0),
+		reloadErrors: make(map[string]error, 0),
+		metrics:      newServiceMetrics(),
+		diffs:        newPolicyDiffHistory(o.policyDiffHistory),
+	}
+
+	// step: generate the certificates on first start if required
+	if s.cfg.tlsAutoGenerate {
+		if err := s.ensureCertificates(); err != nil {
+			return nil, err
+		}
 	}
 
 	// step: create the endpoints
@@ -71,48 +103,142 @@ func newService(o options) (*service, error) {
 		return nil, err
 	}
 
-	// step: create the watcher
-	if err := s.createWatcher(); err != nil {
+	// step: parse the authorization sources
+	sources, err := newAuthorizerSources(s.cfg)
+	if err != nil {
 		return nil, err
 	}
 
-	// step: load the tokens file
-	t, err := loadTokensFile(s.cfg.tokenFile)
-	if err != nil {
+	// step: create the watcher before loading, so no change made while loading is missed
+	if err := s.createWatcher(sources); err != nil {
+		return nil, err
+	}
+
+	// step: load the tokens, policies and mapping
+	if err := s.loadSources(sources); err != nil {
 		return nil, err
 	}
-	s.tokens = t
 
-	// step: load the abac file if required
-	if s.cfg.authFile != "" {
-		t, err := loadAuthorizationFile(s.cfg.authFile)
+	// step: process the file events, any queued while loading are handled now
+	go s.watchEvents()
+
+	// step: start the shadow policy workers if required
+	if s.cfg.shadowPolicy != "" {
+		s.startShadowWorkers(shadowWorkers, shadowQueueSize)
+	}
+
+	// step: open the audit log if required
+	if s.cfg.auditLog != "" {
+		a, err := newAuditor(s.cfg.auditLog)
+		if err != nil {
+			return nil, err
+		}
+		s.audit = a
+	}
+
+	// step: open the capture file if required
+	if s.cfg.captureFile != "" {
+		c, err := newAuditor(s.cfg.captureFile)
 		if err != nil {
 			return nil, err
 		}
-		s.authz = t
+		s.capture = c
 	}
 
 	return s, nil
 }
 
+// loadSources is responsible for loading the tokens, authorization sources and identity mapping
+func (s *service) loadSources(sources []*authorizerSource) error {
+	var err error
+
+	// step: load the tokens files if required
+	if files := s.tokenFiles(); len(files) > 0 {
+		if s.tokens, err = newTokenFileSet(files); err != nil {
+			return err
+		}
+	}
+
+	// step: create the json web token authenticator if required
+	if s.cfg.jwtPublicKeys != "" {
+		j, err := newJWTAuthenticator(s.cfg, time.Now)
+		if err != nil {
+			return err
+		}
+		s.jwt = j
+	}
+
+	// step: create the upstream token webhook if required
+	if s.cfg.tokenWebhookConfig != "" {
+		w, err := newWebhookAuthenticator(s.cfg.tokenWebhookConfig, s.webhookOptions(),
+			s.cfg.tokenWebhookCacheTTL, s.cfg.tokenWebhookNegativeTTL)
+		if err != nil {
+			return err
+		}
+		s.upstream = w
+	}
+
+	// step: load the authorization sources
+	for _, x := range sources {
+		if x.authz, err = s.loadAuthorizer(x); err != nil {
+			return err
+		}
+		switch v := x.authz.(type) {
+		case *breakGlassStore:
+			s.breakGlass = v
+		case *delegationStore:
+			s.delegation = v
+		}
+	}
+	s.authz = newUnionAuthorizer(sources)
+
+	// step: load the shadow policy if required
+	if s.cfg.shadowPolicy != "" {
+		if s.shadow, err = newShadowAuthorizer(s.cfg.shadowPolicy, time.Now); err != nil {
+			return err
+		}
+	}
+
+	// step: load the identity mapping if required
+	if s.cfg.mappingFile != "" {
+		m, err := newIdentityMappingFromFile(s.cfg.mappingFile)
+		if err != nil {
+			return err
+		}
+		s.mapping = m
+	}
+
+	return nil
+}
+
 // createWatcher is responsible for watching for changes in the token and auth files
-func (s *service) createWatcher() error {
+func (s *service) createWatcher(sources []*authorizerSource) error {
 	// step: create the watcher
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
 	}
+	s.watcher = watcher
 
 	// step: add the directories to be watched
 	watching := make(map[string]bool, 0)
-	for _, x := range []string{s.cfg.tokenFile, s.cfg.authFile} {
+	files := []string{s.cfg.mappingFile, s.cfg.shadowPolicy, s.cfg.configFile, s.cfg.delegationPolicy, s.cfg.delegationGrants}
+	for _, x := range s.tokenFiles() {
+		files = append(files, x.path)
+	}
+	for _, x := range sources {
+		if !x.directory && (x.kind == "abac" || x.kind == "rbac") {
+			files = append(files, x.path)
+		}
+	}
+	for _, x := range files {
 		if x == "" {
 			continue
 		}
 		s.files[x] = [16]byte{}
 
 		dir := path.Dir(x)
-		if _, found := watching[x]; found {
+		if _, found := watching[dir]; found {
 			continue
 		}
 		watching[dir] = true
@@ -122,34 +248,55 @@ func (s *service) createWatcher() error {
 		}
 	}
 
-	// step: create the event watcher
-	go func() {
-		for e := range watcher.Events {
-			logrus.WithFields(logrus.Fields{
-				"filename": e.Name,
-				"event":    e.String(),
-			}).Debug("recieved a file notification event")
-
-			if e.Op&fsnotify.Write == fsnotify.Write || e.Op&fsnotify.Create == fsnotify.Create {
-				if err := s.processFileEvent(e.Name); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"filename": e.Name,
-						"error":    err.Error(),
-					}).Errorf("unable to process file change")
-				}
-			}
+	// step: add the policy directories to be watched
+	for _, x := range sources {
+		if !x.directory {
+			continue
 		}
-		// @note: we should NEVER get here
-		panic("we have exited the watcher rountine")
-	}()
+		s.files[x.path] = [16]byte{}
+		if err := s.watchDirectory(x.path, x.recursive); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// watchEvents processes the file notification events from the watcher
+func (s *service) watchEvents() {
+	for e := range s.watcher.Events {
+		logrus.WithFields(logrus.Fields{
+			"filename": e.Name,
+			"event":    e.String(),
+		}).Debug("recieved a file notification event")
+
+		var err error
+		name := e.Name
+		if source := s.findDirectorySource(e.Name); source != nil {
+			name = source.path
+			err = s.processDirectoryEvent(source, e)
+		} else if e.Op&fsnotify.Write == fsnotify.Write || e.Op&fsnotify.Create == fsnotify.Create {
+			err = s.processFileEvent(e.Name)
+		}
+		s.recordReload(name, err)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"filename": e.Name,
+				"error":    err.Error(),
+			}).Errorf("unable to process file change")
+		}
+	}
+	// @note: we should NEVER get here
+	panic("we have exited the watcher rountine")
+}
+
 // processFileEvent is responsible for handling the file changes
 func (s *service) processFileEvent(filename string) error {
 	// step: we only care about events related to tokens and auth file
+	s.RLock()
 	sum, found := s.files[filename]
+	tokens, sources := s.tokens, s.authz.sources
+	s.RUnlock()
 	if !found {
 		return nil
 	}
@@ -163,9 +310,10 @@ func (s *service) processFileEvent(filename string) error {
 		return nil
 	}
 	// step: reload the file
-	switch filename {
-	case s.cfg.tokenFile:
-		t, err := loadTokensFile(filename)
+	switch {
+	case tokens != nil && tokens.contains(filename):
+		// @note: if the file now conflicts with another we keep the current tokens
+		t, err := tokens.reload(filename)
 		if err != nil {
 			return err
 		}
@@ -173,17 +321,60 @@ func (s *service) processFileEvent(filename string) error {
 		s.files[filename] = nsum
 		s.tokens = t
 		s.Unlock()
-	case s.cfg.authFile:
-		t, err := loadAuthorizationFile(filename)
+	case filename == s.cfg.mappingFile:
+		m, err := newIdentityMappingFromFile(filename)
 		if err != nil {
 			return err
 		}
 		s.Lock()
 		s.files[filename] = nsum
-		s.authz = t
+		s.mapping = m
 		s.Unlock()
+	case filename == s.cfg.configFile:
+		if err := s.reloadConfig(); err != nil {
+			return err
+		}
+		s.Lock()
+		s.files[filename] = nsum
+		s.Unlock()
+	case s.delegation != nil && (filename == s.cfg.delegationPolicy || filename == s.cfg.delegationGrants):
+		// @note: the store is reloaded in place, as it's shared by the union and the admin endpoints
+		if err := s.delegation.reload(); err != nil {
+			return err
+		}
+		s.Lock()
+		s.files[filename] = nsum
+		s.Unlock()
+	case filename == s.cfg.shadowPolicy:
+		shadow, err := newShadowAuthorizer(filename, time.Now)
+		if err != nil {
+			return err
+		}
+		s.Lock()
+		s.files[filename] = nsum
+		s.shadow = shadow
+		s.Unlock()
+	default:
+		// step: reload any policy sources using the file
+		for _, x := range sources {
+			if x.directory || x.path != filename || (x.kind != "abac" && x.kind != "rbac") {
+				continue
+			}
+			t, err := s.loadAuthorizer(x)
+			if err != nil {
+				return err
+			}
+			s.Lock()
+			s.files[filename] = nsum
+			s.authz = s.authz.replace(x.name, t)
+			s.Unlock()
+			s.recordPolicyDiff(x, t)
+		}
 	}
 
+	// step: drop the compiled patterns of the replaced rules
+	resetPatternCache()
+
 	logrus.WithFields(logrus.Fields{
 		"filename": filename,
 	}).Infof("reloaded the contents of the file")
@@ -191,15 +382,161 @@ func (s *service) processFileEvent(filename string) error {
 	return nil
 }
 
+// recordReload keeps the failure of the last reload of the file, clearing it on success
+func (s *service) recordReload(filename string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err != nil {
+		s.reloadErrors[filename] = err
+		return
+	}
+	delete(s.reloadErrors, filename)
+}
+
+// watchDirectory adds the directory, and if recursive any subdirectories, to the watcher
+func (s *service) watchDirectory(dir string, recursive bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && (!recursive || strings.HasPrefix(info.Name(), ".")) {
+			return filepath.SkipDir
+		}
+
+		return s.watcher.Add(path)
+	})
+}
+
+// findDirectorySource returns the policy directory source the file is within, if any
+func (s *service) findDirectorySource(filename string) *authorizerSource {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, x := range s.authz.sources {
+		dir := filepath.Clean(x.path)
+		if !x.directory || !strings.HasPrefix(filename, dir+string(os.PathSeparator)) {
+			continue
+		}
+		if x.recursive || filepath.Dir(filename) == dir {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// processDirectoryEvent is responsible for reloading a policy directory when any of
+// the policy files are added, modified or removed
+func (s *service) processDirectoryEvent(source *authorizerSource, e fsnotify.Event) error {
+	// step: ignore changes to files which are not policy files
+	info, err := os.Stat(e.Name)
+	if err == nil && !info.IsDir() && !source.readsFile(e.Name) {
+		return nil
+	}
+	// step: watch any new subdirectories
+	if err == nil && info.IsDir() && source.recursive && e.Op&fsnotify.Create == fsnotify.Create {
+		if err := s.watchDirectory(e.Name, true); err != nil {
+			return err
+		}
+	}
+	// step: check if the contents have changed
+	nsum, err := computeDirectorySum(source.path, source.recursive, source.readsFile)
+	if err != nil {
+		return err
+	}
+	s.RLock()
+	sum := s.files[source.path]
+	s.RUnlock()
+	if sum == nsum {
+		return nil
+	}
+	// step: reload the directory, any broken file leaves the current policy in place
+	t, err := s.loadAuthorizer(source)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.files[source.path] = nsum
+	s.authz = s.authz.replace(source.name, t)
+	s.Unlock()
+	s.recordPolicyDiff(source, t)
+	resetPatternCache()
+
+	logrus.WithFields(logrus.Fields{
+		"directory": source.path,
+		"source":    source.name,
+	}).Infof("reloaded the policy directory")
+
+	return nil
+}
+
+// recordPolicyDiff logs and records the differences between the rules of the source and the reloaded rules
+func (s *service) recordPolicyDiff(source *authorizerSource, updated authorization) {
+	old, ok := source.authz.(*policyAuthorizer)
+	if !ok {
+		return
+	}
+	rules, ok := updated.(*policyAuthorizer)
+	if !ok {
+		return
+	}
+	diff := diffPolicies(old.rules, rules.rules)
+	diff.Source = source.name
+	s.diffs.add(diff)
+
+	gained := make([]string, 0, len(diff.Gained))
+	for _, x := range diff.Gained {
+		gained = append(gained, x.Subject+" "+x.Access)
+	}
+	lost := make([]string, 0, len(diff.Lost))
+	for _, x := range diff.Lost {
+		lost = append(lost, x.Subject+" "+x.Access)
+	}
+	logrus.WithFields(logrus.Fields{
+		"source":  source.name,
+		"added":   len(diff.Added),
+		"removed": len(diff.Removed),
+		"changed": len(diff.Changed),
+		"gained":  gained,
+		"lost":    lost,
+	}).Info("policy changes on reload")
+}
+
 // run is responsible for starting the service
 func (s *service) run() error {
+	// step: start the webhook listener
+	serving, err := s.serve(s.cfg.listen, s.engine, s.cfg.tlsCert, s.cfg.tlsKey, s.cfg.tlsCA)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.serving = serving
+	s.Unlock()
+
+	// step: start the admin listener if required
+	if s.cfg.adminListen != "" {
+		if _, err := s.serve(s.cfg.adminListen, s.admin, s.cfg.adminTLSCert, s.cfg.adminTLSKey, s.cfg.adminTLSCA); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serve is responsible for starting a listener, using tls if a certificate is given and
+// requiring client certificates if a ca is given; it returns the certificate being served, if any
+func (s *service) serve(address string, handler http.Handler, tlsCert, tlsKey, tlsCA string) (*x509.Certificate, error) {
 	tlsConfig := &tls.Config{}
 
 	// step: are run using client auth?
-	if s.cfg.tlsCA != "" {
-		caCert, err := ioutil.ReadFile(s.cfg.tlsCA)
+	if tlsCA != "" {
+		caCert, err := ioutil.ReadFile(tlsCA)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		caCertPool := x509.NewCertPool()
@@ -209,24 +546,39 @@ func (s *service) run() error {
 	}
 
 	server := &http.Server{
-		Addr:    s.cfg.listen,
-		Handler: s.engine,
+		Addr:    address,
+		Handler: handler,
 	}
 
 	// step: create the listener
-	listener, err := net.Listen("tcp", s.cfg.listen)
+	mode, err := parseSocketMode(s.cfg.socketMode)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if strings.HasPrefix(address, systemdScheme) && s.sockets == nil {
+		if s.sockets, err = takeSystemdSockets(); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := createListener(address, mode, s.sockets)
+	if err != nil {
+		return nil, err
 	}
 
 	// step: configure tls
-	if s.cfg.tlsCert != "" && s.cfg.tlsKey != "" {
+	var serving *x509.Certificate
+	if tlsCert != "" && tlsKey != "" {
 		server.TLSConfig = tlsConfig
 
 		// step: load the certificate
-		certs, err := tls.LoadX509KeyPair(s.cfg.tlsCert, s.cfg.tlsKey)
+		certs, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
 		if err != nil {
-			return err
+			listener.Close()
+			return nil, err
+		}
+		if serving, err = x509.ParseCertificate(certs.Certificate[0]); err != nil {
+			listener.Close()
+			return nil, err
 		}
 		tlsConfig.Certificates = append(tlsConfig.Certificates, certs)
 
@@ -234,14 +586,15 @@ func (s *service) run() error {
 	}
 
 	go func() {
-		if err = server.Serve(listener); err != nil {
+		if err := server.Serve(listener); err != nil {
 			logrus.WithFields(logrus.Fields{
-				"error": err.Error(),
+				"listen": address,
+				"error":  err.Error(),
 			}).Fatalf("failed to start the service")
 		}
 	}()
 
-	return nil
+	return serving, nil
 }
 
 func (s *service) createEndpoints() error {
@@ -252,17 +605,91 @@ func (s *service) createEndpoints() error {
 
 	s.engine = gin.New()
 	s.engine.Use(gin.Recovery(), s.loggingMiddleware())
-	s.engine.POST("/authorize/:kind", s.authorizeHandler)
-	s.engine.GET("/version", s.versionHandler)
-	s.engine.GET("/health", s.healthHandler)
+	s.engine.POST(authorizePath+":kind", s.authorizeHandler)
+
+	// step: the health and version endpoints are served on the webhook listener unless an admin
+	// listener is configured; the remaining admin endpoints are never published on the webhook listener
+	probes := s.engine
+	if s.cfg.adminListen != "" {
+		s.admin = gin.New()
+		s.admin.Use(gin.Recovery(), s.loggingMiddleware())
+		probes = s.admin
+	}
+	probes.GET("/version", s.versionHandler)
+	probes.GET("/health", s.healthHandler)
+	probes.GET("/healthz", s.checkHandler("healthz", s.livenessChecks))
+	probes.GET("/readyz", s.checkHandler("readyz", s.readinessChecks))
+	if s.admin == nil {
+		return nil
+	}
+	s.admin.GET("/debug/pprof/*name", gin.WrapH(http.DefaultServeMux))
+	s.admin.GET("/metrics", s.metricsHandler)
+	s.admin.GET("/admin/policy/diffs", s.policyDiffsHandler)
+	if s.cfg.breakGlass {
+		s.admin.GET(breakGlassPath, s.breakGlassListHandler)
+		s.admin.POST(breakGlassPath, s.breakGlassGrantHandler)
+		s.admin.DELETE(breakGlassPath+"/:id", s.breakGlassRevokeHandler)
+	}
+	if s.cfg.delegationPolicy != "" {
+		s.admin.GET(delegationPath, s.delegationListHandler)
+		s.admin.POST(delegationPath, s.delegationGrantHandler)
+		s.admin.DELETE(delegationPath+"/:id", s.delegationRevokeHandler)
+	}
 
 	return nil
 }
 
+// webhookOptions returns the client options for an upstream webhook
+func (s *service) webhookOptions() webhookOptions {
+	return webhookOptions{
+		timeout: s.cfg.webhookTimeout,
+		retries: s.cfg.webhookRetries,
+	}
+}
+
+// loadAuthorizer is responsible for creating the authorizer for a source
+func (s *service) loadAuthorizer(source *authorizerSource) (authorization, error) {
+	switch source.kind {
+	case "abac":
+		return loadAuthorizationFile(source.path, time.Now)
+	case "abac-dir":
+		rules, err := newPolicyFromDirectory(source.path, source.recursive)
+		if err != nil {
+			return nil, err
+		}
+		return newPolicyAuthorizer(rules, time.Now), nil
+	case "rbac":
+		return newRBACAuthorizerFromPath(source.path)
+	case "break-glass":
+		return newBreakGlassStore(source.path, s.cfg.breakGlassMaxTTL, time.Now)
+	case "delegation":
+		return newDelegationStore(s.cfg.delegationPolicy, source.path, time.Now)
+	case "webhook":
+		return newWebhookAuthorizer(source.path, s.webhookOptions(), s.cfg.authzWebhookAllowTTL, s.cfg.authzWebhookDenyTTL)
+	}
+
+	return nil, fmt.Errorf("unknown authorizer kind: %s", source.kind)
+}
+
+// tokenFiles returns the token files from the options, the --token-file is always first
+func (s *service) tokenFiles() []*tokenFile {
+	var files []*tokenFile
+	if s.cfg.tokenFile != "" {
+		files = append(files, &tokenFile{path: s.cfg.tokenFile})
+	}
+	for _, x := range s.cfg.tokenFiles {
+		// @note: the options have already been validated
+		f, _ := parseTokenFile(x)
+		files = append(files, f)
+	}
+
+	return files
+}
+
 // loadTokensFile is responsible for loading the tokens file
 func loadTokensFile(filename string) (authentication, error) {
 	// step: attempt to load the file
-	t, err := tokenfile.NewCSV(filename)
+	t, err := newTokenAuthenticator(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -270,15 +697,16 @@ func loadTokensFile(filename string) (authentication, error) {
 	return t, nil
 }
 
-// loadAuthorizationFile is responsible for loading the authorization file
-func loadAuthorizationFile(filename string) (authorization, error) {
+// loadAuthorizationFile is responsible for loading the authorization file, the time conditions
+// of the rules are evaluated against the clock
+func loadAuthorizationFile(filename string, now func() time.Time) (authorization, error) {
 	// step: attempt to load the file
-	t, err := abac.NewFromFile(filename)
+	t, err := newPolicyFromFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return t, nil
+	return newPolicyAuthorizer(t, now), nil
 }
 
 // computeSum gets the md5 sum of a file
@@ -22,6 +22,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty"
 	"github.com/stretchr/testify/assert"
@@ -43,6 +44,7 @@ token3,user3,uuid3,group3
 {"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{ "user":"user3", "namespace": "te-preprod", "resource": "*" }}
 {"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{ "user":"user2", "namespace": "sip-demo", "resource": "*" }}
 {"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{ "group":"group3", "namespace": "sip-demo", "resource": "*" }}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{ "group":"*", "namespace": "platform", "resource": "*", "extra": { "team": "platform" } }}
 {"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{ "user":"*", "nonResourcePath": "*", "readonly": true }}
 `
 )
@@ -79,11 +81,13 @@ func newTestService(t *testing.T) *testService {
 }
 
 func newTestingService(tokens, auth string) (*testService, error) {
-	opts := options{
-		listen:  "127.0.0.1:8080",
-		tlsCert: "does_not_exist",
-		tlsKey:  "does_not_exist",
-	}
+	return newTestingServiceWithOptions(tokens, auth, options{})
+}
+
+func newTestingServiceWithOptions(tokens, auth string, opts options) (*testService, error) {
+	opts.listen = "127.0.0.1:8080"
+	opts.tlsCert = "does_not_exist"
+	opts.tlsKey = "does_not_exist"
 
 	// step: write the test tokens file
 	t, err := writeTestFile(tokens)
@@ -135,11 +139,11 @@ func TestLoadAuthorizationFile(t *testing.T) {
 	}
 	defer os.Remove(f.Name())
 
-	x, err := loadAuthorizationFile(f.Name())
+	x, err := loadAuthorizationFile(f.Name(), time.Now)
 	assert.NotNil(t, x)
 	assert.NoError(t, err)
 
-	x, err = loadAuthorizationFile("should_not_exist_file")
+	x, err = loadAuthorizationFile("should_not_exist_file", time.Now)
 	assert.Nil(t, x)
 	assert.Error(t, err)
 }
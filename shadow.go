@@ -0,0 +1,123 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"time"
+
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// shadowAgree indicates the shadow policy made the same decision as the active
+	shadowAgree = "agree"
+	// shadowDenied indicates the active sources allowed the request, but the shadow policy did not
+	shadowDenied = "active_allowed_shadow_denied"
+	// shadowAllowed indicates the active sources did not allow the request, but the shadow policy did
+	shadowAllowed = "active_denied_shadow_allowed"
+	// shadowDropped indicates the comparison was skipped as the shadow queue was full
+	shadowDropped = "dropped"
+)
+
+const (
+	// shadowWorkers is the number of workers evaluating the shadow policy
+	shadowWorkers = 4
+	// shadowQueueSize is the number of comparisons which can wait for a worker
+	shadowQueueSize = 1000
+)
+
+// shadowRequest is a request waiting to be evaluated against the shadow policy
+type shadowRequest struct {
+	// shadow is the shadow policy at the time of the request
+	shadow *unionAuthorizer
+	// attributes are the attributes of the request
+	attributes authorizer.Attributes
+	// active is the decision given by the active sources
+	active *authorizationResult
+}
+
+// newShadowAuthorizer creates a union containing just the shadow policy
+func newShadowAuthorizer(filename string, now func() time.Time) (*unionAuthorizer, error) {
+	p, err := loadAuthorizationFile(filename, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUnionAuthorizer([]*authorizerSource{{name: "shadow", kind: "abac", path: filename, authz: p}}), nil
+}
+
+// startShadowWorkers creates the shadow queue and the workers which drain it
+func (s *service) startShadowWorkers(workers, size int) {
+	s.shadowQueue = make(chan *shadowRequest, size)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for x := range s.shadowQueue {
+				s.evaluateShadow(x.shadow, x.attributes, x.active)
+			}
+		}()
+	}
+}
+
+// queueShadow hands the request to the shadow workers; the comparison is dropped rather than
+// holding up the response when the queue is full
+func (s *service) queueShadow(shadow *unionAuthorizer, a authorizer.Attributes, active *authorizationResult) {
+	select {
+	case s.shadowQueue <- &shadowRequest{shadow: shadow, attributes: a, active: active}:
+	default:
+		s.metrics.shadowEvaluations.inc(shadowDropped)
+	}
+}
+
+// evaluateShadow evaluates the request against the shadow policy and records any disagreement
+// with the active result; the shadow policy never affects the answer
+func (s *service) evaluateShadow(shadow *unionAuthorizer, a authorizer.Attributes, active *authorizationResult) string {
+	result := shadow.evaluate(a, false)
+
+	outcome := shadowAgree
+	switch {
+	case active.allowed() && !result.allowed():
+		outcome = shadowDenied
+	case !active.allowed() && result.allowed():
+		outcome = shadowAllowed
+	}
+	s.metrics.shadowEvaluations.inc(outcome)
+
+	if outcome != shadowAgree {
+		event := newAuditEvent(a, active)
+		logrus.WithFields(logrus.Fields{
+			"user":          event.User,
+			"groups":        event.Groups,
+			"verb":          event.Verb,
+			"namespace":     event.Namespace,
+			"api_group":     event.APIGroup,
+			"resource":      event.Resource,
+			"subresource":   event.Subresource,
+			"name":          event.Name,
+			"path":          event.Path,
+			"outcome":       outcome,
+			"active":        active.decision.String(),
+			"active_reason": active.reason,
+			"shadow":        result.decision.String(),
+			"shadow_reason": result.reason,
+		}).Warn("shadow policy disagrees with the active decision")
+	}
+
+	return outcome
+}
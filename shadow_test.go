@@ -0,0 +1,119 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateShadow(t *testing.T) {
+	shadow, err := writeTestFile(`
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"admin","namespace":"*","resource":"*","apiGroup":"*"}}
+{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"user1","namespace":"shadow","resource":"*","apiGroup":"*"}}
+`)
+	if err != nil {
+		t.Fatalf("unable to write the shadow policy, error: %s", err)
+	}
+	defer os.Remove(shadow.Name())
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{shadowPolicy: shadow.Name()})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	cs := []struct {
+		User      string
+		Namespace string
+		Outcome   string
+	}{
+		{User: "admin", Namespace: "default", Outcome: shadowAgree},
+		{User: "nobody", Namespace: "default", Outcome: shadowAgree},
+		{User: "user1", Namespace: "adm", Outcome: shadowDenied},
+		{User: "user1", Namespace: "shadow", Outcome: shadowAllowed},
+	}
+	for _, x := range cs {
+		a := &authorizer.AttributesRecord{
+			User:            &user.DefaultInfo{Name: x.User},
+			Verb:            "get",
+			Namespace:       x.Namespace,
+			Resource:        "pods",
+			ResourceRequest: true,
+		}
+		outcome := s.s.evaluateShadow(s.s.shadow, a, s.s.authz.evaluate(a, false))
+		assert.Equal(t, x.Outcome, outcome, "user: %s, namespace: %s", x.User, x.Namespace)
+	}
+	assert.Equal(t, float64(2), s.s.metrics.shadowEvaluations.get(shadowAgree))
+	assert.Equal(t, float64(1), s.s.metrics.shadowEvaluations.get(shadowDenied))
+	assert.Equal(t, float64(1), s.s.metrics.shadowEvaluations.get(shadowAllowed))
+}
+
+func TestShadowQueueFull(t *testing.T) {
+	s := &service{metrics: newServiceMetrics()}
+	// @note: no workers, so the queue is never drained
+	s.startShadowWorkers(0, 1)
+
+	shadow := newUnionAuthorizer(nil)
+	a := &authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "user1"}}
+	s.queueShadow(shadow, a, &authorizationResult{})
+	s.queueShadow(shadow, a, &authorizationResult{})
+	assert.Len(t, s.shadowQueue, 1)
+	assert.Equal(t, float64(1), s.metrics.shadowEvaluations.get(shadowDropped))
+}
+
+func TestShadowPolicyNeverAffectsAnswer(t *testing.T) {
+	shadow, err := writeTestFile(`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"*","namespace":"*","resource":"*","apiGroup":"*"}}`)
+	if err != nil {
+		t.Fatalf("unable to write the shadow policy, error: %s", err)
+	}
+	defer os.Remove(shadow.Name())
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{adminListen: "127.0.0.1:8081", shadowPolicy: shadow.Name()})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+	admin := httptest.NewServer(s.s.admin)
+	defer admin.Close()
+
+	status, err := makeTestAuthzRequest(s.URL(), v1beta1.SubjectAccessReview{
+		Spec: v1beta1.SubjectAccessReviewSpec{
+			User:               "nobody",
+			ResourceAttributes: &v1beta1.ResourceAttributes{Resource: "pods", Namespace: "default", Verb: "get"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+
+	// step: the shadow evaluation is recorded in the background
+	time.Sleep(100 * time.Millisecond)
+	res, err := hc.R().Get(admin.URL + "/metrics")
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(res.Body()),
+		`kube_auth_shadow_evaluations_total{outcome="active_denied_shadow_allowed"} 1`), "body: %s", res.Body())
+}
@@ -0,0 +1,285 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// tokenAuthenticator is a csv token authenticator, the format is compatible with the
+// kubernetes token file, but any columns after the groups are taken as key=value extras
+type tokenAuthenticator struct {
+	tokens map[string]*user.DefaultInfo
+}
+
+// newTokenAuthenticator reads in the tokens from a csv file in the format
+// token,username,uid[,"group1,group2"][,key=value,...]
+func newTokenAuthenticator(filename string) (*tokenAuthenticator, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(map[string]*user.DefaultInfo, 0)
+	lines := make(map[string]int, 0)
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// @note: the line the record starts on, a quoted field can span several lines
+		line, _ := reader.FieldPos(0)
+		if len(record) < 3 {
+			return nil, fmt.Errorf("token file '%s' line %d must have at least 3 columns (token, user name, user uid), found %d",
+				filename, line, len(record))
+		}
+		// @note: as with the kubernetes token file the last entry wins, we never log the token itself
+		if previous, found := lines[record[0]]; found {
+			logrus.WithFields(logrus.Fields{
+				"file":     filename,
+				"line":     line,
+				"previous": previous,
+			}).Warn("the token file redefines a token, the later entry takes precedence")
+		}
+		lines[record[0]] = line
+		info := &user.DefaultInfo{
+			Name: record[1],
+			UID:  record[2],
+		}
+		if len(record) >= 4 && record[3] != "" {
+			info.Groups = strings.Split(record[3], ",")
+		}
+		// step: any remaining columns are the extra attributes
+		if len(record) > 4 {
+			info.Extra, err = parseExtraAttributes(record[4:])
+			if err != nil {
+				return nil, fmt.Errorf("token file '%s' line %d, %s", filename, line, err)
+			}
+		}
+		tokens[record[0]] = info
+	}
+
+	return &tokenAuthenticator{tokens: tokens}, nil
+}
+
+// AuthenticateToken checks the token exists in the file
+func (t *tokenAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	info, found := t.tokens[token]
+	if !found {
+		return nil, false, nil
+	}
+
+	return info, true, nil
+}
+
+// tokenFile is a token file and the defaults applied to each of its tokens
+type tokenFile struct {
+	// path is the location of the file
+	path string
+	// groups are added to every token in the file
+	groups []string
+	// prefix is prepended to every username in the file
+	prefix string
+}
+
+// parseTokenFile parses a token file option in the format path[?group=name&prefix=value]
+func parseTokenFile(spec string) (*tokenFile, error) {
+	f := &tokenFile{path: spec}
+	if i := strings.LastIndex(spec, "?"); i >= 0 {
+		f.path = spec[:i]
+		values, err := url.ParseQuery(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid token file: %s, %s", spec, err)
+		}
+		for k, v := range values {
+			switch k {
+			case "group":
+				for _, x := range v {
+					for _, name := range strings.Split(x, ",") {
+						if name != "" {
+							f.groups = append(f.groups, name)
+						}
+					}
+				}
+			case "prefix":
+				f.prefix = v[len(v)-1]
+			default:
+				return nil, fmt.Errorf("invalid token file: %s, unknown option: %s", spec, k)
+			}
+		}
+	}
+	if f.path == "" {
+		return nil, fmt.Errorf("invalid token file: %s, no path", spec)
+	}
+
+	return f, nil
+}
+
+// load reads in the token file and applies the defaults to each of the tokens
+func (f *tokenFile) load() (*tokenAuthenticator, error) {
+	t, err := newTokenAuthenticator(f.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range t.tokens {
+		info.Name = f.prefix + info.Name
+		for _, x := range f.groups {
+			if !containedIn(x, info.Groups) {
+				info.Groups = append(info.Groups, x)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// tokenFileSet merges several token files into a single authenticator; it's immutable,
+// reloading a file creates a new set
+type tokenFileSet struct {
+	// files are the token files in the order given
+	files []*tokenFile
+	// loaded are the tokens from each of the files, keyed on the path
+	loaded map[string]*tokenAuthenticator
+	// tokens are the merged tokens from all the files
+	tokens map[string]*user.DefaultInfo
+}
+
+// newTokenFileSet loads and merges the token files
+func newTokenFileSet(files []*tokenFile) (*tokenFileSet, error) {
+	loaded := make(map[string]*tokenAuthenticator, len(files))
+	for _, x := range files {
+		if _, found := loaded[x.path]; found {
+			return nil, fmt.Errorf("token file '%s' has been specified more than once", x.path)
+		}
+		t, err := x.load()
+		if err != nil {
+			return nil, err
+		}
+		loaded[x.path] = t
+	}
+
+	return mergeTokenFiles(files, loaded)
+}
+
+// mergeTokenFiles merges the loaded files; as within a file, a token appearing in more than one
+// file is taken from the later file with a warning
+func mergeTokenFiles(files []*tokenFile, loaded map[string]*tokenAuthenticator) (*tokenFileSet, error) {
+	set := &tokenFileSet{
+		files:  files,
+		loaded: loaded,
+		tokens: make(map[string]*user.DefaultInfo, 0),
+	}
+	owners := make(map[string]string, 0)
+	for _, x := range files {
+		for token, info := range loaded[x.path].tokens {
+			// @note: we never log the token itself
+			if owner, found := owners[token]; found {
+				logrus.WithFields(logrus.Fields{
+					"file":     x.path,
+					"user":     info.Name,
+					"previous": owner,
+				}).Warn("the token file redefines a token from another file, the later file takes precedence")
+			}
+			owners[token] = x.path
+			set.tokens[token] = info
+		}
+	}
+
+	return set, nil
+}
+
+// contains checks if the set was loaded from the file
+func (t *tokenFileSet) contains(filename string) bool {
+	_, found := t.loaded[filename]
+
+	return found
+}
+
+// reload returns a copy of the set with the file reloaded
+func (t *tokenFileSet) reload(filename string) (*tokenFileSet, error) {
+	loaded := make(map[string]*tokenAuthenticator, len(t.loaded))
+	for _, x := range t.files {
+		loaded[x.path] = t.loaded[x.path]
+		if x.path != filename {
+			continue
+		}
+		updated, err := x.load()
+		if err != nil {
+			return nil, err
+		}
+		loaded[x.path] = updated
+	}
+
+	return mergeTokenFiles(t.files, loaded)
+}
+
+// hashed returns a copy of the set with the tokens keyed on their hash, used when replaying captures
+func (t *tokenFileSet) hashed() *tokenFileSet {
+	tokens := make(map[string]*user.DefaultInfo, len(t.tokens))
+	for k, v := range t.tokens {
+		tokens[hashToken(k)] = v
+	}
+
+	return &tokenFileSet{files: t.files, loaded: t.loaded, tokens: tokens}
+}
+
+// AuthenticateToken checks the token exists in any of the files
+func (t *tokenFileSet) AuthenticateToken(token string) (user.Info, bool, error) {
+	info, found := t.tokens[token]
+	if !found {
+		return nil, false, nil
+	}
+
+	return info, true, nil
+}
+
+// parseExtraAttributes parses a series of key=value columns, repeated keys are appended
+func parseExtraAttributes(columns []string) (map[string][]string, error) {
+	extra := make(map[string][]string, 0)
+	for _, x := range columns {
+		if x == "" {
+			continue
+		}
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 || items[0] == "" {
+			return nil, fmt.Errorf("extra attribute '%s' must be in the format key=value", x)
+		}
+		// @note: the user.Info interface requires the keys are lowercase
+		key := strings.ToLower(strings.TrimSpace(items[0]))
+		extra[key] = append(extra[key], items[1])
+	}
+	if len(extra) == 0 {
+		return nil, nil
+	}
+
+	return extra, nil
+}
@@ -0,0 +1,222 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTokenAuthenticator(t *testing.T) {
+	f, err := writeTestFile(`
+token1,user1,uuid1
+token2,user2,uuid2,"group1,group2"
+token3,user3,uuid3,,Team=platform
+token4,user4,uuid4,group4,team=platform,scopes=read,scopes=write
+`)
+	if err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	a, err := newTokenAuthenticator(f.Name())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cs := []struct {
+		Token  string
+		Found  bool
+		Name   string
+		Groups []string
+		Extra  map[string][]string
+	}{
+		{Token: "not_there"},
+		{Token: "token1", Found: true, Name: "user1"},
+		{Token: "token2", Found: true, Name: "user2", Groups: []string{"group1", "group2"}},
+		{Token: "token3", Found: true, Name: "user3", Extra: map[string][]string{"team": {"platform"}}},
+		{
+			Token:  "token4",
+			Found:  true,
+			Name:   "user4",
+			Groups: []string{"group4"},
+			Extra:  map[string][]string{"team": {"platform"}, "scopes": {"read", "write"}},
+		},
+	}
+	for _, x := range cs {
+		info, found, err := a.AuthenticateToken(x.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, x.Found, found, "token: %s", x.Token)
+		if !x.Found {
+			continue
+		}
+		assert.Equal(t, x.Name, info.GetName())
+		assert.Equal(t, x.Groups, info.GetGroups())
+		assert.Equal(t, x.Extra, info.GetExtra())
+	}
+}
+
+func TestNewTokenAuthenticatorBad(t *testing.T) {
+	cs := []string{
+		"token1,user1",
+		"token1,user1,uuid1,group1,no_equals",
+		"token1,user1,uuid1,group1,=value",
+	}
+	for _, x := range cs {
+		f, err := writeTestFile(x)
+		if err != nil {
+			t.Fatalf("failed to write the tokens file, error: %s", err)
+		}
+		_, err = newTokenAuthenticator(f.Name())
+		assert.Error(t, err, "content: %s", x)
+		os.Remove(f.Name())
+	}
+}
+
+func TestNewTokenAuthenticatorDuplicates(t *testing.T) {
+	f, err := writeTestFile("token1,user1,uuid1\ntoken1,user2,uuid2\n")
+	if err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	a, err := newTokenAuthenticator(f.Name())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	info, found, _ := a.AuthenticateToken("token1")
+	assert.True(t, found)
+	assert.Equal(t, "user2", info.GetName())
+}
+
+func TestNewTokenAuthenticatorLineNumbers(t *testing.T) {
+	f, err := writeTestFile("token1,user1,uuid1,\"group1,\ngroup2\"\ntoken2,user2\n")
+	if err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = newTokenAuthenticator(f.Name())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 3")
+	}
+}
+
+func TestParseTokenFile(t *testing.T) {
+	cs := []struct {
+		Spec   string
+		Expect *tokenFile
+		Error  bool
+	}{
+		{Spec: "humans.csv", Expect: &tokenFile{path: "humans.csv"}},
+		{
+			Spec:   "/etc/robots.csv?group=robots&prefix=robot:",
+			Expect: &tokenFile{path: "/etc/robots.csv", groups: []string{"robots"}, prefix: "robot:"},
+		},
+		{
+			Spec:   "tenant.csv?group=a,b&group=c",
+			Expect: &tokenFile{path: "tenant.csv", groups: []string{"a", "b", "c"}},
+		},
+		{Spec: "?group=robots", Error: true},
+		{Spec: "robots.csv?owner=me", Error: true},
+		{Spec: "robots.csv?group=%zz", Error: true},
+	}
+	for _, x := range cs {
+		f, err := parseTokenFile(x.Spec)
+		if x.Error {
+			assert.Error(t, err, "spec: %s", x.Spec)
+			continue
+		}
+		assert.NoError(t, err, "spec: %s", x.Spec)
+		assert.Equal(t, x.Expect, f, "spec: %s", x.Spec)
+	}
+}
+
+func TestNewTokenFileSet(t *testing.T) {
+	humans, err := writeTestFile("token1,user1,uuid1,group1\n")
+	if err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	defer os.Remove(humans.Name())
+	robots, err := writeTestFile("token2,deployer,uuid2,\"robots,group2\"\n")
+	if err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	defer os.Remove(robots.Name())
+
+	set, err := newTokenFileSet([]*tokenFile{
+		{path: humans.Name()},
+		{path: robots.Name(), groups: []string{"robots", "ci"}, prefix: "robot:"},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, set.contains(humans.Name()))
+	assert.False(t, set.contains("not_there"))
+
+	info, found, err := set.AuthenticateToken("token1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "user1", info.GetName())
+	assert.Equal(t, []string{"group1"}, info.GetGroups())
+
+	info, found, err = set.AuthenticateToken("token2")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "robot:deployer", info.GetName())
+	assert.Equal(t, []string{"robots", "group2", "ci"}, info.GetGroups())
+
+	// step: the same token in two files is taken from the later file
+	updateTestFile(t, robots.Name(), "token1,deployer,uuid2\n")
+	redefined, err := set.reload(robots.Name())
+	if assert.NoError(t, err) {
+		info, found, _ = redefined.AuthenticateToken("token1")
+		assert.True(t, found)
+		assert.Equal(t, "robot:deployer", info.GetName())
+	}
+	redefined, err = newTokenFileSet([]*tokenFile{{path: robots.Name()}, {path: humans.Name()}})
+	if assert.NoError(t, err) {
+		info, _, _ = redefined.AuthenticateToken("token1")
+		assert.Equal(t, "user1", info.GetName())
+	}
+
+	// step: reloading a file leaves the others untouched
+	if err := ioutil.WriteFile(robots.Name(), []byte("token3,builder,uuid3\n"), 0644); err != nil {
+		t.Fatalf("failed to write the tokens file, error: %s", err)
+	}
+	updated, err := set.reload(robots.Name())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, found, _ = updated.AuthenticateToken("token1")
+	assert.True(t, found)
+	_, found, _ = updated.AuthenticateToken("token2")
+	assert.False(t, found)
+	info, found, _ = updated.AuthenticateToken("token3")
+	assert.True(t, found)
+	assert.Equal(t, "robot:builder", info.GetName())
+
+	// step: the original set is unchanged
+	_, found, _ = set.AuthenticateToken("token2")
+	assert.True(t, found)
+
+	_, err = newTokenFileSet([]*tokenFile{{path: humans.Name()}, {path: humans.Name()}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,315 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+)
+
+// decision is the answer from an authorization source
+type decision int
+
+const (
+	// decisionNoOpinion indicates the source has nothing to say about the request
+	decisionNoOpinion decision = iota
+	// decisionAllow indicates the source allows the request
+	decisionAllow
+	// decisionDeny indicates the source explicitly denies the request
+	decisionDeny
+)
+
+// noPolicyMatched is the reason given when no source has an opinion
+const noPolicyMatched = "No policy matched."
+
+// String returns a description of the decision
+func (d decision) String() string {
+	switch d {
+	case decisionAllow:
+		return "allowed"
+	case decisionDeny:
+		return "denied"
+	}
+
+	return "no opinion"
+}
+
+// decider is implemented by authorization sources which are able to explicitly deny
+type decider interface {
+	Decide(a authorizer.Attributes) (decision, string, error)
+}
+
+// explainer is implemented by authorization sources which are able to describe how they reached
+// the decision, e.g. the rule which matched
+type explainer interface {
+	Explain(a authorizer.Attributes) (decision, string, error)
+}
+
+// decide asks the authorizer for a decision; a plain authorizer either allows or has no opinion
+func decide(authz authorization, a authorizer.Attributes) (decision, string, error) {
+	if d, ok := authz.(decider); ok {
+		return d.Decide(a)
+	}
+	allowed, reason, err := authz.Authorize(a)
+	if err != nil {
+		return decisionNoOpinion, "", err
+	}
+	if allowed {
+		return decisionAllow, reason, nil
+	}
+
+	return decisionNoOpinion, reason, nil
+}
+
+// explain asks the authorizer for a decision along with an explanation if requested and it's able
+// to give one; the explanation is kept apart from the reason, which remains unchanged
+func explain(authz authorization, a authorizer.Attributes, enabled bool) (decision, string, string, error) {
+	// @note: working out the closest rule is costly, so we only do it when explanations are wanted
+	if e, ok := authz.(explainer); ok && enabled {
+		d, explanation, err := e.Explain(a)
+		return d, "", explanation, err
+	}
+	d, reason, err := decide(authz, a)
+
+	return d, reason, "", err
+}
+
+// authorizerSource is a named source of authorization decisions
+type authorizerSource struct {
+	// name is the name of the source used in the reason and audit log
+	name string
+	// kind is the type of source
+	kind string
+	// path is the file or directory the source was loaded from
+	path string
+	// recursive indicates subdirectories of a directory source are read
+	recursive bool
+	// directory indicates the source is read from the files in a directory
+	directory bool
+	// authz is the authorizer itself
+	authz authorization
+}
+
+// authorizationResult is the outcome of evaluating the sources
+type authorizationResult struct {
+	// decision is the final decision
+	decision decision
+	// source is the name of the deciding source, empty if none
+	source string
+	// reason is the reason given by the deciding source
+	reason string
+	// evaluationError is a description of any sources which errored
+	evaluationError string
+	// explanation describes how the sources reached the decision
+	explanation string
+}
+
+// allowed checks if the result permits the request
+func (r *authorizationResult) allowed() bool {
+	return r.decision == decisionAllow
+}
+
+// unionAuthorizer evaluates an ordered list of sources, stopping on the first allow or deny;
+// it's immutable, reloading a source creates a new union
+type unionAuthorizer struct {
+	sources []*authorizerSource
+}
+
+// newUnionAuthorizer creates a union from the sources
+func newUnionAuthorizer(sources []*authorizerSource) *unionAuthorizer {
+	return &unionAuthorizer{sources: sources}
+}
+
+// evaluate runs through the sources in order; sources which error are recorded in the
+// evaluation error but do not abort the decision. The sources are only asked to explain
+// their decision when explained is set
+func (u *unionAuthorizer) evaluate(a authorizer.Attributes, explained bool) *authorizationResult {
+	var errs, explanations []string
+	result := &authorizationResult{reason: noPolicyMatched}
+
+	for _, x := range u.sources {
+		d, reason, explanation, err := explain(x.authz, a, explained)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", x.name, err))
+			continue
+		}
+		if explanation != "" {
+			explanation = fmt.Sprintf("%s: %s", x.name, explanation)
+		}
+		if d == decisionNoOpinion {
+			if explanation != "" {
+				explanations = append(explanations, explanation)
+			}
+			continue
+		}
+		if reason == "" {
+			reason = d.String()
+		}
+		result.decision = d
+		result.source = x.name
+		result.reason = fmt.Sprintf("%s: %s", x.name, reason)
+		// @note: only the deciding source explains an allow or deny
+		explanations = nil
+		if explanation != "" {
+			explanations = []string{explanation}
+		}
+		break
+	}
+	if len(u.sources) == 0 {
+		result.reason = "no authorization sources configured"
+	}
+	result.evaluationError = strings.Join(errs, "; ")
+	result.explanation = strings.Join(explanations, "; ")
+
+	return result
+}
+
+// Authorize implements the authorization interface
+func (u *unionAuthorizer) Authorize(a authorizer.Attributes) (bool, string, error) {
+	result := u.evaluate(a, false)
+
+	return result.allowed(), result.reason, nil
+}
+
+// replace returns a copy of the union with the named source swapped
+func (u *unionAuthorizer) replace(name string, authz authorization) *unionAuthorizer {
+	sources := make([]*authorizerSource, len(u.sources))
+	for i, x := range u.sources {
+		sources[i] = x
+		if x.name == name {
+			updated := *x
+			updated.authz = authz
+			sources[i] = &updated
+		}
+	}
+
+	return newUnionAuthorizer(sources)
+}
+
+// builtinSources are the names of the sources configured by their own options, in their default
+// order; those before the --authorizer sources are leading, the rest trailing
+var builtinSources = []string{breakGlassSource, "policy", "policy-dir", delegationSource, "webhook"}
+
+// leadingBuiltinSources is the number of built-in sources which by default precede the --authorizer sources
+const leadingBuiltinSources = 3
+
+// newAuthorizerSources returns the ordered authorization sources from the options; by default
+// the break-glass grants are first, then the --auth-policy file and the --auth-policy-dir,
+// followed by the --authorizer sources, the delegated grants and lastly the
+// --authorization-webhook-config. Naming a built-in source in the --authorizer list places it
+// at that position instead
+func newAuthorizerSources(o *options) ([]*authorizerSource, error) {
+	builtins := make(map[string]*authorizerSource)
+	if o.breakGlass {
+		builtins[breakGlassSource] = &authorizerSource{name: breakGlassSource, kind: "break-glass", path: o.breakGlassJournal}
+	}
+	if o.authFile != "" {
+		builtins["policy"] = &authorizerSource{name: "policy", kind: "abac", path: o.authFile}
+	}
+	if o.authDir != "" {
+		builtins["policy-dir"] = &authorizerSource{name: "policy-dir", kind: "abac-dir", path: o.authDir}
+	}
+	if o.delegationPolicy != "" {
+		builtins[delegationSource] = &authorizerSource{name: delegationSource, kind: "delegation", path: o.delegationGrants}
+	}
+	if o.authzWebhookConfig != "" {
+		builtins["webhook"] = &authorizerSource{name: "webhook", kind: "webhook", path: o.authzWebhookConfig}
+	}
+
+	// step: place any built-in sources named in the list
+	var listed []*authorizerSource
+	placed := make(map[string]bool)
+	for _, x := range o.authorizers {
+		if isBuiltinSource(x) {
+			source, found := builtins[x]
+			if !found {
+				return nil, fmt.Errorf("invalid authorizer: %s, the source is not configured", x)
+			}
+			if placed[x] {
+				return nil, fmt.Errorf("invalid authorizer: %s, the source is listed more than once", x)
+			}
+			placed[x] = true
+			listed = append(listed, source)
+			continue
+		}
+		source, err := parseAuthorizerSpec(x)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, source)
+	}
+
+	// step: the built-in sources not listed keep their default positions
+	var sources []*authorizerSource
+	for i, x := range builtinSources {
+		if i == leadingBuiltinSources {
+			sources = append(sources, listed...)
+		}
+		if source, found := builtins[x]; found && !placed[x] {
+			sources = append(sources, source)
+		}
+	}
+	for _, x := range sources {
+		switch x.kind {
+		case "abac-dir":
+			x.recursive, x.directory = o.authDirRecursive, true
+		case "rbac":
+			// @note: manifests repositories are usually nested, so rbac directories are always recursive
+			x.recursive, x.directory = true, isDirectory(x.path)
+		}
+	}
+
+	return sources, nil
+}
+
+// isBuiltinSource checks if the name refers to a source configured by its own option
+func isBuiltinSource(name string) bool {
+	for _, x := range builtinSources {
+		if x == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readsFile checks if the file is read by a directory source
+func (x *authorizerSource) readsFile(filename string) bool {
+	if x.kind == "rbac" {
+		return isManifestFile(filename)
+	}
+
+	return isPolicyFile(filename)
+}
+
+// parseAuthorizerSpec parses an authorizer option in the format kind:path
+func parseAuthorizerSpec(spec string) (*authorizerSource, error) {
+	items := strings.SplitN(spec, ":", 2)
+	if len(items) != 2 || items[1] == "" {
+		return nil, fmt.Errorf("invalid authorizer: %s, must be in the format kind:path", spec)
+	}
+	switch items[0] {
+	case "abac", "abac-dir", "rbac", "webhook":
+	default:
+		return nil, fmt.Errorf("invalid authorizer: %s, unknown kind: %s", spec, items[0])
+	}
+
+	return &authorizerSource{name: spec, kind: items[0], path: items[1]}, nil
+}
@@ -0,0 +1,287 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// fakeDecider is a authorizer returning a fixed decision
+type fakeDecider struct {
+	decision decision
+	reason   string
+	err      error
+}
+
+func (f *fakeDecider) Authorize(a authorizer.Attributes) (bool, string, error) {
+	return f.decision == decisionAllow, f.reason, f.err
+}
+
+func (f *fakeDecider) Decide(a authorizer.Attributes) (decision, string, error) {
+	return f.decision, f.reason, f.err
+}
+
+func TestUnionAuthorizer(t *testing.T) {
+	allow := &fakeDecider{decision: decisionAllow}
+	deny := &fakeDecider{decision: decisionDeny, reason: "not permitted"}
+	none := &fakeDecider{decision: decisionNoOpinion}
+	broken := &fakeDecider{err: errors.New("unavailable")}
+
+	cs := []struct {
+		Sources  []authorization
+		Expected authorizationResult
+	}{
+		{
+			Expected: authorizationResult{reason: "no authorization sources configured"},
+		},
+		{
+			Sources:  []authorization{none, none},
+			Expected: authorizationResult{reason: noPolicyMatched},
+		},
+		{
+			Sources:  []authorization{none, allow, deny},
+			Expected: authorizationResult{decision: decisionAllow, source: "source1", reason: "source1: allowed"},
+		},
+		{
+			Sources:  []authorization{deny, allow},
+			Expected: authorizationResult{decision: decisionDeny, source: "source0", reason: "source0: not permitted"},
+		},
+		{
+			Sources: []authorization{broken, none, allow},
+			Expected: authorizationResult{
+				decision:        decisionAllow,
+				source:          "source2",
+				reason:          "source2: allowed",
+				evaluationError: "source0: unavailable",
+			},
+		},
+		{
+			Sources: []authorization{none, newPolicyAuthorizer(policyList{{Spec: policySpec{User: "test", NonResourcePath: "*"}}}, time.Now), deny},
+			Expected: authorizationResult{
+				decision:    decisionAllow,
+				source:      "source1",
+				reason:      "source1: allowed",
+				explanation: "source1: allowed by user:test rule",
+			},
+		},
+		{
+			Sources: []authorization{newPolicyAuthorizer(policyList{{Spec: policySpec{User: "other", NonResourcePath: "*"}}}, time.Now), deny},
+			Expected: authorizationResult{
+				decision: decisionDeny,
+				source:   "source1",
+				reason:   "source1: not permitted",
+			},
+		},
+		{
+			Sources: []authorization{newPolicyAuthorizer(policyList{{Spec: policySpec{User: "other", NonResourcePath: "*"}}}, time.Now), none},
+			Expected: authorizationResult{
+				reason:      noPolicyMatched,
+				explanation: "source0: no rule matched, closest: user:other rule (subject)",
+			},
+		},
+		{
+			Sources:  []authorization{broken, broken},
+			Expected: authorizationResult{reason: noPolicyMatched, evaluationError: "source0: unavailable; source1: unavailable"},
+		},
+	}
+	for i, x := range cs {
+		var sources []*authorizerSource
+		for j, authz := range x.Sources {
+			sources = append(sources, &authorizerSource{name: fmt.Sprintf("source%d", j), authz: authz})
+		}
+		result := newUnionAuthorizer(sources).evaluate(authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "test"}}, true)
+		assert.Equal(t, x.Expected, *result, "case %d", i)
+	}
+}
+
+func TestUnionAuthorizerExplainDisabled(t *testing.T) {
+	u := newUnionAuthorizer([]*authorizerSource{
+		{name: "policy", authz: newPolicyAuthorizer(policyList{{Spec: policySpec{User: "other", NonResourcePath: "*"}}}, time.Now)},
+	})
+	result := u.evaluate(authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "test"}}, false)
+	assert.Equal(t, authorizationResult{reason: noPolicyMatched}, *result)
+}
+
+func TestUnionAuthorizerReplace(t *testing.T) {
+	u := newUnionAuthorizer([]*authorizerSource{
+		{name: "first", authz: &fakeDecider{}},
+		{name: "second", authz: &fakeDecider{}},
+	})
+	updated := u.replace("second", &fakeDecider{decision: decisionAllow})
+	assert.Equal(t, decisionAllow, updated.evaluate(authorizer.AttributesRecord{}, false).decision)
+	// @note: the original must be left unchanged
+	assert.Equal(t, decisionNoOpinion, u.evaluate(authorizer.AttributesRecord{}, false).decision)
+}
+
+func TestNewAuthorizerSources(t *testing.T) {
+	sources, err := newAuthorizerSources(&options{
+		authFile:           "policy.jsonl",
+		authorizers:        []string{"abac:team.jsonl", "rbac:rbac.yaml", "webhook:central.yaml"},
+		authzWebhookConfig: "upstream.yaml",
+	})
+	assert.NoError(t, err)
+	var names []string
+	for _, x := range sources {
+		names = append(names, x.name+"="+x.kind)
+	}
+	assert.Equal(t, []string{"policy=abac", "abac:team.jsonl=abac", "rbac:rbac.yaml=rbac", "webhook:central.yaml=webhook", "webhook=webhook"}, names)
+
+	for _, x := range []string{"abac", "abac:", "unknown:file", "policy", "webhook"} {
+		_, err := newAuthorizerSources(&options{authorizers: []string{x}})
+		assert.Error(t, err, "spec: %s", x)
+	}
+}
+
+func TestNewAuthorizerSourcesOrder(t *testing.T) {
+	cs := []struct {
+		Authorizers []string
+		Expected    []string
+		Error       bool
+	}{
+		{
+			Authorizers: []string{"rbac:rbac.yaml"},
+			Expected:    []string{"policy", "policy-dir", "rbac:rbac.yaml", "webhook"},
+		},
+		{
+			Authorizers: []string{"webhook", "rbac:rbac.yaml"},
+			Expected:    []string{"policy", "policy-dir", "webhook", "rbac:rbac.yaml"},
+		},
+		{
+			Authorizers: []string{"rbac:rbac.yaml", "policy"},
+			Expected:    []string{"policy-dir", "rbac:rbac.yaml", "policy", "webhook"},
+		},
+		{
+			Authorizers: []string{"webhook", "policy-dir", "policy"},
+			Expected:    []string{"webhook", "policy-dir", "policy"},
+		},
+		{
+			Authorizers: []string{"policy", "policy"},
+			Error:       true,
+		},
+		{
+			Authorizers: []string{"delegation"},
+			Error:       true,
+		},
+	}
+	for i, x := range cs {
+		sources, err := newAuthorizerSources(&options{
+			authFile:           "policy.jsonl",
+			authDir:            "policies",
+			authorizers:        x.Authorizers,
+			authzWebhookConfig: "upstream.yaml",
+		})
+		if x.Error {
+			assert.Error(t, err, "case %d", i)
+			continue
+		}
+		if !assert.NoError(t, err, "case %d", i) {
+			continue
+		}
+		var names []string
+		for _, source := range sources {
+			names = append(names, source.name)
+		}
+		assert.Equal(t, x.Expected, names, "case %d", i)
+	}
+}
+
+func TestAuthorizationAuditLog(t *testing.T) {
+	audit, err := ioutil.TempFile("/tmp", "kube-auth.audit.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("unable to create the audit log, error: %s", err)
+	}
+	defer os.Remove(audit.Name())
+
+	team, err := writeTestFile(`{"apiVersion":"abac.authorization.kubernetes.io/v1beta1","kind":"Policy","spec":{"user":"team_user","namespace":"team","resource":"*","apiGroup":"*"}}`)
+	if err != nil {
+		t.Fatalf("unable to write the policy, error: %s", err)
+	}
+	defer os.Remove(team.Name())
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		auditLog:    audit.Name(),
+		authorizers: []string{"abac:" + team.Name()},
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	status, err := makeTestAuthzRequest(s.URL(), v1beta1.SubjectAccessReview{
+		Spec: v1beta1.SubjectAccessReviewSpec{
+			User:               "team_user",
+			ResourceAttributes: &v1beta1.ResourceAttributes{Resource: "pods", Namespace: "team", Verb: "delete"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+	assert.Equal(t, "abac:"+team.Name()+": allowed", status.Status.Reason)
+
+	content, err := ioutil.ReadFile(audit.Name())
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if !assert.Len(t, lines, 1) {
+		t.FailNow()
+	}
+	event := new(auditEvent)
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), event))
+	assert.Equal(t, "team_user", event.User)
+	assert.Equal(t, "delete", event.Verb)
+	assert.Equal(t, "team", event.Namespace)
+	assert.True(t, event.Allowed)
+	assert.Equal(t, "abac:"+team.Name(), event.Source)
+	// @note: the explanation is only worked out with --explain-decisions
+	assert.Empty(t, event.Explanation)
+}
+
+func TestAuthorizationExplainDecisions(t *testing.T) {
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{explainDecisions: true})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	review := v1beta1.SubjectAccessReview{
+		Spec: v1beta1.SubjectAccessReviewSpec{
+			User:               "user1",
+			ResourceAttributes: &v1beta1.ResourceAttributes{Resource: "pods", Namespace: "adm", Verb: "get"},
+		},
+	}
+	status, err := makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+	assert.Equal(t, "policy: allowed by "+s.s.cfg.authFile+":3", status.Status.Reason)
+
+	review.Spec.ResourceAttributes.Namespace = "prod"
+	status, err = makeTestAuthzRequest(s.URL(), review)
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+	assert.Contains(t, status.Status.Reason, "policy: no rule matched, closest: "+s.s.cfg.authFile+":3 (namespace)")
+}
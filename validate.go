@@ -0,0 +1,143 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// validateOptions checks the options along with the files they reference, i.e. the files exist
+// and parse and the certificates match the keys, returning all the problems found
+func validateOptions(o *options) []error {
+	list := o.problems()
+
+	// step: check the certificates
+	if !o.tlsAutoGenerate || fileExists(o.tlsCert) || fileExists(o.tlsKey) {
+		list = append(list, validateKeyPair("tls", o.tlsCert, o.tlsKey)...)
+	}
+	list = append(list, validateAuthority("tls-ca", o.tlsCA)...)
+	list = append(list, validateKeyPair("admin-tls", o.adminTLSCert, o.adminTLSKey)...)
+	list = append(list, validateAuthority("admin-tls-ca", o.adminTLSCA)...)
+
+	// step: check the token files individually, and only if they all load, together
+	var files []*tokenFile
+	if o.tokenFile != "" {
+		files = append(files, &tokenFile{path: o.tokenFile})
+	}
+	for _, x := range o.tokenFiles {
+		if f, err := parseTokenFile(x); err == nil {
+			files = append(files, f)
+		}
+	}
+	var failed bool
+	for _, x := range files {
+		if _, err := x.load(); err != nil {
+			list = append(list, fmt.Errorf("token file %s: %s", x.path, err))
+			failed = true
+		}
+	}
+	if !failed && len(files) > 1 {
+		if _, err := newTokenFileSet(files); err != nil {
+			list = append(list, err)
+		}
+	}
+
+	// step: check the json web token keys
+	if o.jwtPublicKeys != "" {
+		if _, err := newJWTAuthenticator(o, time.Now); err != nil {
+			list = append(list, err)
+		}
+	}
+
+	// step: check the upstream token webhook
+	s := &service{cfg: o}
+	if o.tokenWebhookConfig != "" {
+		if _, err := newWebhookAuthenticator(o.tokenWebhookConfig, s.webhookOptions(),
+			o.tokenWebhookCacheTTL, o.tokenWebhookNegativeTTL); err != nil {
+			list = append(list, fmt.Errorf("token webhook %s: %s", o.tokenWebhookConfig, err))
+		}
+	}
+
+	// step: check the authorization sources, the specs have already been checked above
+	if sources, err := newAuthorizerSources(o); err == nil {
+		for _, x := range sources {
+			if _, err := s.loadAuthorizer(x); err != nil {
+				list = append(list, fmt.Errorf("authorizer %s (%s:%s): %s", x.name, x.kind, x.path, err))
+			}
+		}
+	}
+	if o.shadowPolicy != "" {
+		if _, err := newShadowAuthorizer(o.shadowPolicy, time.Now); err != nil {
+			list = append(list, fmt.Errorf("shadow policy %s: %s", o.shadowPolicy, err))
+		}
+	}
+	if o.mappingFile != "" {
+		if _, err := newIdentityMappingFromFile(o.mappingFile); err != nil {
+			list = append(list, fmt.Errorf("identity mapping %s: %s", o.mappingFile, err))
+		}
+	}
+
+	return list
+}
+
+// validateKeyPair checks the certificate and key load and match
+func validateKeyPair(name, certFile, keyFile string) []error {
+	if certFile == "" || keyFile == "" {
+		return nil
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return []error{fmt.Errorf("%s certificate %s and key %s: %s", name, certFile, keyFile, err)}
+	}
+
+	return nil
+}
+
+// validateAuthority checks the certificate authority file contains certificates
+func validateAuthority(name, filename string) []error {
+	if filename == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %s", name, err)}
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(content) {
+		return []error{fmt.Errorf("%s: no certificates found in %s", name, filename)}
+	}
+
+	return nil
+}
+
+// fileExists checks if the file exists
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+
+	return err == nil
+}
+
+// isDirectory checks if the path is a directory
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir()
+}
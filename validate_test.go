@@ -0,0 +1,94 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOptions(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	tokens, err := writeTestFile(defaultTestTokens)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(tokens.Name())
+	policy, err := writeTestFile(defaultTestAuthPolicy)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(policy.Name())
+
+	valid := options{
+		listen:    "127.0.0.1:8443",
+		tlsCert:   certFile,
+		tlsKey:    keyFile,
+		tlsCA:     certFile,
+		tokenFile: tokens.Name(),
+		authFile:  policy.Name(),
+	}
+	assert.Empty(t, validateOptions(&valid))
+
+	// @check all the problems are reported together
+	invalid := valid
+	invalid.listen = ""
+	invalid.tlsKey = tokens.Name()
+	invalid.tlsCA = tokens.Name()
+	invalid.tokenFiles = []string{"/does/not/exist", tokens.Name() + "?bad=option"}
+	invalid.authFile = tokens.Name()
+	invalid.mappingFile = "/does/not/exist"
+	var problems []string
+	for _, x := range validateOptions(&invalid) {
+		problems = append(problems, x.Error())
+	}
+	expected := []string{
+		"no listen",
+		"unknown option: bad",
+		"tls certificate",
+		"tls-ca: no certificates found",
+		"token file /does/not/exist",
+		"authorizer policy (abac:" + tokens.Name() + ")",
+		"identity mapping /does/not/exist",
+	}
+	if assert.Equal(t, len(expected), len(problems), "problems: %s", strings.Join(problems, "\n")) {
+		for i, x := range expected {
+			assert.Contains(t, problems[i], x)
+		}
+	}
+
+	// @check missing certificates are fine if they will be generated
+	generated := valid
+	generated.tlsCert, generated.tlsKey = "/does/not/exist.pem", "/does/not/exist-key.pem"
+	generated.tlsAutoGenerate = true
+	assert.Empty(t, validateOptions(&generated))
+}
+
+func TestServerFlagsEnvVars(t *testing.T) {
+	for _, x := range newServerFlags(&options{}) {
+		env := reflect.ValueOf(x).FieldByName("EnvVar").String()
+		assert.Equal(t, envVarName(x.GetName()), env, "flag: %s", x.GetName())
+	}
+}
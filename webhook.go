@@ -0,0 +1,319 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// webhookKubeConfig is the subset of the kubeconfig format used to describe an upstream webhook
+type webhookKubeConfig struct {
+	Clusters       []kubeConfigCluster `json:"clusters"`
+	Users          []kubeConfigUser    `json:"users"`
+	Contexts       []kubeConfigContext `json:"contexts"`
+	CurrentContext string              `json:"current-context"`
+}
+
+// kubeConfigCluster is a named cluster in the kubeconfig
+type kubeConfigCluster struct {
+	Name    string `json:"name"`
+	Cluster struct {
+		Server                   string `json:"server"`
+		CertificateAuthority     string `json:"certificate-authority,omitempty"`
+		CertificateAuthorityData []byte `json:"certificate-authority-data,omitempty"`
+		InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify,omitempty"`
+	} `json:"cluster"`
+}
+
+// kubeConfigUser is a named user in the kubeconfig
+type kubeConfigUser struct {
+	Name string `json:"name"`
+	User struct {
+		ClientCertificate     string `json:"client-certificate,omitempty"`
+		ClientCertificateData []byte `json:"client-certificate-data,omitempty"`
+		ClientKey             string `json:"client-key,omitempty"`
+		ClientKeyData         []byte `json:"client-key-data,omitempty"`
+		Token                 string `json:"token,omitempty"`
+	} `json:"user"`
+}
+
+// kubeConfigContext is a named context in the kubeconfig
+type kubeConfigContext struct {
+	Name    string `json:"name"`
+	Context struct {
+		Cluster string `json:"cluster"`
+		User    string `json:"user"`
+	} `json:"context"`
+}
+
+// webhookOptions are the client settings for an upstream webhook
+type webhookOptions struct {
+	// timeout is the timeout per request
+	timeout time.Duration
+	// retries is the number of times to retry a failed request
+	retries int
+}
+
+// webhookClient is a client used to post reviews to an upstream webhook
+type webhookClient struct {
+	server  string
+	token   string
+	retries int
+	client  *http.Client
+}
+
+// newWebhookClient creates a client from a kubeconfig style file; the cluster and user are taken
+// from the current context, or the first of each when there isn't one
+func newWebhookClient(filename string, opts webhookOptions) (*webhookClient, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := new(webhookKubeConfig)
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("unable to decode webhook config %s, error: %s", filename, err)
+	}
+	if len(config.Clusters) == 0 {
+		return nil, fmt.Errorf("webhook config %s has no clusters", filename)
+	}
+
+	// step: find the cluster and user from the context
+	clusterName, userName := config.Clusters[0].Name, ""
+	if len(config.Users) > 0 {
+		userName = config.Users[0].Name
+	}
+	for _, x := range config.Contexts {
+		if x.Name == config.CurrentContext {
+			clusterName, userName = x.Context.Cluster, x.Context.User
+		}
+	}
+
+	// @note: relative paths in a kubeconfig are relative to the file itself
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(filepath.Dir(filename), path)
+	}
+
+	c := &webhookClient{retries: opts.retries}
+	tlsConfig := &tls.Config{}
+	found := false
+	for _, x := range config.Clusters {
+		if x.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = x.Cluster.Server
+		tlsConfig.InsecureSkipVerify = x.Cluster.InsecureSkipTLSVerify
+
+		caData := x.Cluster.CertificateAuthorityData
+		if x.Cluster.CertificateAuthority != "" {
+			if caData, err = ioutil.ReadFile(resolve(x.Cluster.CertificateAuthority)); err != nil {
+				return nil, err
+			}
+		}
+		if len(caData) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caData) {
+				return nil, fmt.Errorf("webhook config %s has an invalid certificate authority", filename)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("webhook config %s has no cluster named: %s", filename, clusterName)
+	}
+	if c.server == "" {
+		return nil, fmt.Errorf("webhook config %s has no server", filename)
+	}
+
+	for _, x := range config.Users {
+		if x.Name != userName {
+			continue
+		}
+		c.token = x.User.Token
+
+		certData, keyData := x.User.ClientCertificateData, x.User.ClientKeyData
+		if x.User.ClientCertificate != "" {
+			if certData, err = ioutil.ReadFile(resolve(x.User.ClientCertificate)); err != nil {
+				return nil, err
+			}
+		}
+		if x.User.ClientKey != "" {
+			if keyData, err = ioutil.ReadFile(resolve(x.User.ClientKey)); err != nil {
+				return nil, err
+			}
+		}
+		if len(certData) > 0 || len(keyData) > 0 {
+			cert, err := tls.X509KeyPair(certData, keyData)
+			if err != nil {
+				return nil, fmt.Errorf("webhook config %s has an invalid client certificate, error: %s", filename, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	c.client = &http.Client{
+		Timeout:   opts.timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	return c, nil
+}
+
+// post sends the review to the upstream and decodes the response, retrying on
+// connection errors and server side errors
+func (c *webhookClient) post(review, result interface{}) error {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := c.do(body, result)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.retries {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+	}
+}
+
+// do performs a single request to the upstream, indicating whether a failure is worth retrying
+func (c *webhookClient) do(body []byte, result interface{}) (bool, error) {
+	request, err := http.NewRequest("POST", c.server, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	// @note: transport errors and server side errors are retryable, client errors and
+	// malformed responses will not improve by asking again
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode >= 500, fmt.Errorf("upstream webhook returned status code: %d", resp.StatusCode)
+	}
+
+	return false, json.NewDecoder(resp.Body).Decode(result)
+}
+
+const (
+	// defaultCacheEntries is the maximum number of items held in the cache
+	defaultCacheEntries = 10000
+	// cacheSweepInterval is how often the expired items are cleared out
+	cacheSweepInterval = time.Minute
+)
+
+// ttlCache is a simple expiring cache
+type ttlCache struct {
+	sync.Mutex
+	items map[string]*cacheItem
+	// maxEntries is the upper limit on the number of items
+	maxEntries int
+	// swept is the last time the expired items were cleared
+	swept time.Time
+	now   func() time.Time
+}
+
+// cacheItem is an item in the cache
+type cacheItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+// errCacheMiss indicates the item is not in the cache or has expired
+var errCacheMiss = errors.New("cache miss")
+
+// newTTLCache creates a cache
+func newTTLCache() *ttlCache {
+	return &ttlCache{
+		items:      make(map[string]*cacheItem, 0),
+		maxEntries: defaultCacheEntries,
+		swept:      time.Now(),
+		now:        time.Now,
+	}
+}
+
+// get retrieves an item from the cache
+func (c *ttlCache) get(key string) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found {
+		return nil, errCacheMiss
+	}
+	if c.now().After(item.expires) {
+		delete(c.items, key)
+		return nil, errCacheMiss
+	}
+
+	return item.value, nil
+}
+
+// set adds an item to the cache, a zero ttl disables caching
+func (c *ttlCache) set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	// step: periodically, or when full, clear out any expired items
+	if now.Sub(c.swept) >= cacheSweepInterval || len(c.items) >= c.maxEntries {
+		for k, v := range c.items {
+			if now.After(v.expires) {
+				delete(c.items, k)
+			}
+		}
+		c.swept = now
+	}
+	// step: if we are still full, evict an arbitrary item to make room
+	if _, found := c.items[key]; !found && len(c.items) >= c.maxEntries {
+		for k := range c.items {
+			delete(c.items, k)
+			break
+		}
+	}
+	c.items[key] = &cacheItem{value: value, expires: now.Add(ttl)}
+}
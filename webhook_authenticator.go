@@ -0,0 +1,99 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// webhookAuthenticator delegates the token review to an upstream webhook
+type webhookAuthenticator struct {
+	client *webhookClient
+	cache  *ttlCache
+	// positiveTTL is the time to cache authenticated tokens
+	positiveTTL time.Duration
+	// negativeTTL is the time to cache unauthenticated tokens
+	negativeTTL time.Duration
+}
+
+// newWebhookAuthenticator creates an upstream token authenticator
+func newWebhookAuthenticator(filename string, opts webhookOptions, positiveTTL, negativeTTL time.Duration) (*webhookAuthenticator, error) {
+	client, err := newWebhookClient(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookAuthenticator{
+		client:      client,
+		cache:       newTTLCache(),
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+	}, nil
+}
+
+// AuthenticateToken posts a token review to the upstream
+func (w *webhookAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	// @note: we never keep the raw token in memory longer than required
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if cached, err := w.cache.get(key); err == nil {
+		if cached == nil {
+			return nil, false, nil
+		}
+		return cached.(user.Info), true, nil
+	}
+
+	review := &v1beta1.TokenReview{
+		TypeMeta: unversioned.TypeMeta{
+			APIVersion: "authentication.k8s.io/v1beta1",
+			Kind:       "TokenReview",
+		},
+		Spec: v1beta1.TokenReviewSpec{Token: token},
+	}
+	result := new(v1beta1.TokenReview)
+	if err := w.client.post(review, result); err != nil {
+		return nil, false, err
+	}
+
+	if !result.Status.Authenticated {
+		w.cache.set(key, nil, w.negativeTTL)
+		return nil, false, nil
+	}
+
+	info := &user.DefaultInfo{
+		Name:   result.Status.User.Username,
+		UID:    result.Status.User.UID,
+		Groups: result.Status.User.Groups,
+	}
+	if len(result.Status.User.Extra) > 0 {
+		info.Extra = make(map[string][]string, len(result.Status.User.Extra))
+		for k, v := range result.Status.User.Extra {
+			info.Extra[k] = []string(v)
+		}
+	}
+	w.cache.set(key, info, w.positiveTTL)
+
+	return info, true, nil
+}
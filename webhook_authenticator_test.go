@@ -0,0 +1,300 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/authentication/v1beta1"
+)
+
+// fakeTokenUpstream is a upstream token webhook
+type fakeTokenUpstream struct {
+	svc      *httptest.Server
+	requests int32
+	failures int32
+}
+
+func newFakeTokenUpstream() *fakeTokenUpstream {
+	f := &fakeTokenUpstream{}
+	f.svc = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.requests, 1)
+		if atomic.LoadInt32(&f.failures) > 0 {
+			atomic.AddInt32(&f.failures, -1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		review := new(v1beta1.TokenReview)
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if review.Spec.Token == "upstream_token" {
+			review.Status = v1beta1.TokenReviewStatus{
+				Authenticated: true,
+				User: v1beta1.UserInfo{
+					Username: "upstream_user",
+					UID:      "upstream_uid",
+					Groups:   []string{"upstream"},
+					Extra:    map[string]v1beta1.ExtraValue{"team": {"platform"}},
+				},
+			}
+		}
+		json.NewEncoder(w).Encode(review)
+	}))
+
+	return f
+}
+
+func (f *fakeTokenUpstream) count() int {
+	return int(atomic.LoadInt32(&f.requests))
+}
+
+// writeTestWebhookConfig writes a kubeconfig for the test server
+func writeTestWebhookConfig(t *testing.T, svc *httptest.Server, path string) string {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svc.TLS.Certificates[0].Certificate[0]})
+	f, err := writeTestFile(fmt.Sprintf(`
+clusters:
+- name: upstream
+  cluster:
+    certificate-authority-data: %s
+    server: %s%s
+users:
+- name: upstream
+current-context: upstream
+contexts:
+- context:
+    cluster: upstream
+    user: upstream
+  name: upstream
+`, base64.StdEncoding.EncodeToString(ca), svc.URL, path))
+	if err != nil {
+		t.Fatalf("unable to write the webhook config, error: %s", err)
+	}
+
+	return f.Name()
+}
+
+func TestNewWebhookClientBad(t *testing.T) {
+	cs := []string{
+		"",
+		"clusters: []",
+		"clusters:\n- name: test\n  cluster: {}\n",
+		"clusters:\n- name: test\n  cluster:\n    server: https://127.0.0.1\n    certificate-authority: /does_not_exist\n",
+		"clusters:\n- name: test\n  cluster:\n    server: https://127.0.0.1\ncurrent-context: test\ncontexts:\n- name: test\n  context:\n    cluster: other\n",
+	}
+	for i, x := range cs {
+		f, err := writeTestFile(x)
+		if err != nil {
+			t.Fatalf("unable to write the webhook config, error: %s", err)
+		}
+		_, err = newWebhookClient(f.Name(), webhookOptions{})
+		assert.Error(t, err, "case %d", i)
+		os.Remove(f.Name())
+	}
+}
+
+func TestWebhookAuthenticator(t *testing.T) {
+	upstream := newFakeTokenUpstream()
+	defer upstream.svc.Close()
+	config := writeTestWebhookConfig(t, upstream.svc, "/authenticate")
+	defer os.Remove(config)
+
+	a, err := newWebhookAuthenticator(config, webhookOptions{timeout: time.Second, retries: 1}, time.Minute, time.Minute)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	info, found, err := a.AuthenticateToken("upstream_token")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "upstream_user", info.GetName())
+	assert.Equal(t, []string{"upstream"}, info.GetGroups())
+	assert.Equal(t, map[string][]string{"team": {"platform"}}, info.GetExtra())
+	assert.Equal(t, 1, upstream.count())
+
+	_, found, err = a.AuthenticateToken("bad_token")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 2, upstream.count())
+
+	// step: both results should now be cached
+	_, found, _ = a.AuthenticateToken("upstream_token")
+	assert.True(t, found)
+	_, found, _ = a.AuthenticateToken("bad_token")
+	assert.False(t, found)
+	assert.Equal(t, 2, upstream.count())
+
+	// step: expire the cache and check we go upstream again
+	a.cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, found, _ = a.AuthenticateToken("upstream_token")
+	assert.True(t, found)
+	assert.Equal(t, 3, upstream.count())
+}
+
+func TestWebhookAuthenticatorRetries(t *testing.T) {
+	upstream := newFakeTokenUpstream()
+	defer upstream.svc.Close()
+	config := writeTestWebhookConfig(t, upstream.svc, "/authenticate")
+	defer os.Remove(config)
+
+	a, err := newWebhookAuthenticator(config, webhookOptions{timeout: time.Second, retries: 1}, 0, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	atomic.StoreInt32(&upstream.failures, 1)
+	_, found, err := a.AuthenticateToken("upstream_token")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 2, upstream.count())
+
+	atomic.StoreInt32(&upstream.failures, 2)
+	_, found, err = a.AuthenticateToken("upstream_token")
+	assert.Error(t, err)
+	assert.False(t, found)
+}
+
+func TestWebhookAuthenticatorNoRetry(t *testing.T) {
+	cs := []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) },
+		func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("not json")) },
+	}
+	for i, x := range cs {
+		var requests int32
+		svc := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			x(w, r)
+		}))
+		config := writeTestWebhookConfig(t, svc, "/authenticate")
+
+		a, err := newWebhookAuthenticator(config, webhookOptions{timeout: time.Second, retries: 2}, 0, 0)
+		if !assert.NoError(t, err, "case %d", i) {
+			t.FailNow()
+		}
+		_, _, err = a.AuthenticateToken("upstream_token")
+		assert.Error(t, err, "case %d", i)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "case %d", i)
+		svc.Close()
+		os.Remove(config)
+	}
+}
+
+func TestTTLCacheBounded(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache()
+	c.maxEntries = 3
+	c.now = func() time.Time { return now }
+
+	c.set("a", 1, time.Second)
+	c.set("b", 2, time.Minute)
+	c.set("c", 3, time.Minute)
+	assert.Equal(t, 3, len(c.items))
+
+	// step: the expired item should be swept to make room
+	now = now.Add(2 * time.Second)
+	c.set("d", 4, time.Minute)
+	assert.Equal(t, 3, len(c.items))
+	_, err := c.get("a")
+	assert.Equal(t, errCacheMiss, err)
+	v, err := c.get("d")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, v)
+
+	// step: with nothing expired an item is evicted
+	c.set("e", 5, time.Minute)
+	assert.Equal(t, 3, len(c.items))
+	v, err = c.get("e")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, v)
+
+	// step: updating an existing key should not evict anything
+	c.set("e", 6, time.Minute)
+	assert.Equal(t, 3, len(c.items))
+}
+
+func TestWebhookAuthenticatorTimeout(t *testing.T) {
+	svc := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer svc.Close()
+	config := writeTestWebhookConfig(t, svc, "/authenticate")
+	defer os.Remove(config)
+
+	a, err := newWebhookAuthenticator(config, webhookOptions{timeout: 50 * time.Millisecond}, 0, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = a.AuthenticateToken("upstream_token")
+	assert.Error(t, err)
+}
+
+func TestAuthenticationDelegation(t *testing.T) {
+	upstream := newFakeTokenUpstream()
+	defer upstream.svc.Close()
+	config := writeTestWebhookConfig(t, upstream.svc, "/authenticate")
+	defer os.Remove(config)
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		tokenWebhookConfig: config,
+		webhookTimeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	// step: a local token should not go upstream
+	status, err := makeTestAuthRequest(s.URL(), v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: "token1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", status.Status.User.Username)
+	assert.Equal(t, 0, upstream.count())
+
+	status, err = makeTestAuthRequest(s.URL(), v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: "upstream_token"}})
+	assert.NoError(t, err)
+	assert.Equal(t, v1beta1.TokenReview{
+		TypeMeta: unversioned.TypeMeta{
+			APIVersion: "authentication.k8s.io/v1beta1",
+			Kind:       "TokenReview",
+		},
+		Status: v1beta1.TokenReviewStatus{
+			Authenticated: true,
+			User: v1beta1.UserInfo{
+				Username: "upstream_user",
+				UID:      "upstream_uid",
+				Groups:   []string{"upstream"},
+				Extra:    map[string]v1beta1.ExtraValue{"team": {"platform"}},
+			},
+		},
+	}, status)
+
+	status, err = makeTestAuthRequest(s.URL(), v1beta1.TokenReview{Spec: v1beta1.TokenReviewSpec{Token: "bad_token"}})
+	assert.NoError(t, err)
+	assert.Equal(t, failedAuthRequest, status)
+	assert.Equal(t, 2, upstream.count())
+}
@@ -0,0 +1,150 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+)
+
+// webhookAuthorizer delegates the subject access review to an upstream webhook
+type webhookAuthorizer struct {
+	client *webhookClient
+	cache  *ttlCache
+	// allowTTL is the time to cache allowed decisions
+	allowTTL time.Duration
+	// denyTTL is the time to cache denied decisions
+	denyTTL time.Duration
+}
+
+// webhookDecision is a cached decision from the upstream
+type webhookDecision struct {
+	decision decision
+	reason   string
+}
+
+// webhookReviewResponse is the response from the upstream; it includes the denied field
+// from later api versions, which permits the upstream to explicitly deny a request
+type webhookReviewResponse struct {
+	Status struct {
+		Allowed bool   `json:"allowed"`
+		Denied  bool   `json:"denied,omitempty"`
+		Reason  string `json:"reason,omitempty"`
+	} `json:"status"`
+}
+
+// newWebhookAuthorizer creates an upstream authorizer
+func newWebhookAuthorizer(filename string, opts webhookOptions, allowTTL, denyTTL time.Duration) (*webhookAuthorizer, error) {
+	client, err := newWebhookClient(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookAuthorizer{
+		client:   client,
+		cache:    newTTLCache(),
+		allowTTL: allowTTL,
+		denyTTL:  denyTTL,
+	}, nil
+}
+
+// Authorize posts a subject access review to the upstream
+func (w *webhookAuthorizer) Authorize(a authorizer.Attributes) (bool, string, error) {
+	d, reason, err := w.Decide(a)
+
+	return d == decisionAllow, reason, err
+}
+
+// Decide posts a subject access review to the upstream; the upstream has no opinion
+// unless it either allows or explicitly denies the request
+func (w *webhookAuthorizer) Decide(a authorizer.Attributes) (decision, string, error) {
+	review := newSubjectAccessReview(a)
+
+	encoded, err := json.Marshal(review.Spec)
+	if err != nil {
+		return decisionNoOpinion, "", err
+	}
+	sum := sha256.Sum256(encoded)
+	key := hex.EncodeToString(sum[:])
+
+	if cached, err := w.cache.get(key); err == nil {
+		d := cached.(*webhookDecision)
+		return d.decision, d.reason, nil
+	}
+
+	result := new(webhookReviewResponse)
+	if err := w.client.post(review, result); err != nil {
+		return decisionNoOpinion, "", err
+	}
+
+	ttl := w.denyTTL
+	d := &webhookDecision{decision: decisionNoOpinion, reason: result.Status.Reason}
+	switch {
+	case result.Status.Allowed:
+		d.decision, ttl = decisionAllow, w.allowTTL
+	case result.Status.Denied:
+		d.decision = decisionDeny
+	}
+	w.cache.set(key, d, ttl)
+
+	return d.decision, d.reason, nil
+}
+
+// newSubjectAccessReview converts the attributes back into a review
+func newSubjectAccessReview(a authorizer.Attributes) *v1beta1.SubjectAccessReview {
+	review := &v1beta1.SubjectAccessReview{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "SubjectAccessReview",
+			APIVersion: "authorization.k8s.io/v1beta1",
+		},
+	}
+	if u := a.GetUser(); u != nil {
+		review.Spec.User = u.GetName()
+		review.Spec.Groups = u.GetGroups()
+		if extra := u.GetExtra(); len(extra) > 0 {
+			review.Spec.Extra = make(map[string]v1beta1.ExtraValue, len(extra))
+			for k, v := range extra {
+				review.Spec.Extra[k] = v1beta1.ExtraValue(v)
+			}
+		}
+	}
+	if a.IsResourceRequest() {
+		review.Spec.ResourceAttributes = &v1beta1.ResourceAttributes{
+			Namespace:   a.GetNamespace(),
+			Verb:        a.GetVerb(),
+			Group:       a.GetAPIGroup(),
+			Version:     a.GetAPIVersion(),
+			Resource:    a.GetResource(),
+			Subresource: a.GetSubresource(),
+			Name:        a.GetName(),
+		}
+	} else {
+		review.Spec.NonResourceAttributes = &v1beta1.NonResourceAttributes{
+			Path: a.GetPath(),
+			Verb: a.GetVerb(),
+		}
+	}
+
+	return review
+}
@@ -0,0 +1,181 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/authorization/v1beta1"
+	"k8s.io/kubernetes/pkg/auth/authorizer"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// fakeAuthzUpstream is an upstream authorization webhook which allows anything in the
+// upstream namespace, denies the denied namespace and has no opinion on the rest
+type fakeAuthzUpstream struct {
+	svc      *httptest.Server
+	requests int32
+}
+
+func newFakeAuthzUpstream() *fakeAuthzUpstream {
+	f := &fakeAuthzUpstream{}
+	f.svc = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.requests, 1)
+		review := new(v1beta1.SubjectAccessReview)
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status := map[string]interface{}{"allowed": false}
+		if review.Spec.ResourceAttributes != nil {
+			switch review.Spec.ResourceAttributes.Namespace {
+			case "upstream":
+				status = map[string]interface{}{"allowed": true, "reason": "allowed by upstream"}
+			case "denied":
+				status = map[string]interface{}{"allowed": false, "denied": true, "reason": "denied by upstream"}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": status})
+	}))
+
+	return f
+}
+
+func (f *fakeAuthzUpstream) count() int {
+	return int(atomic.LoadInt32(&f.requests))
+}
+
+func TestWebhookAuthorizer(t *testing.T) {
+	upstream := newFakeAuthzUpstream()
+	defer upstream.svc.Close()
+	config := writeTestWebhookConfig(t, upstream.svc, "/authorize")
+	defer os.Remove(config)
+
+	a, err := newWebhookAuthorizer(config, webhookOptions{timeout: time.Second}, time.Minute, time.Second)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	allowed := authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: "user1"},
+		Verb:            "get",
+		Namespace:       "upstream",
+		Resource:        "pods",
+		ResourceRequest: true,
+	}
+	denied := allowed
+	denied.Namespace = "denied"
+	other := allowed
+	other.Namespace = "default"
+
+	d, reason, err := a.Decide(allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, decisionAllow, d)
+	assert.Equal(t, "allowed by upstream", reason)
+
+	d, reason, err = a.Decide(denied)
+	assert.NoError(t, err)
+	assert.Equal(t, decisionDeny, d)
+	assert.Equal(t, "denied by upstream", reason)
+
+	d, _, err = a.Decide(other)
+	assert.NoError(t, err)
+	assert.Equal(t, decisionNoOpinion, d)
+	assert.Equal(t, 3, upstream.count())
+
+	// step: all are cached, the deny for a shorter period
+	a.Decide(allowed)
+	a.Decide(denied)
+	a.Decide(other)
+	assert.Equal(t, 3, upstream.count())
+
+	a.cache.now = func() time.Time { return time.Now().Add(10 * time.Second) }
+	ok, _, err := a.Authorize(allowed)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _, err = a.Authorize(denied)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 4, upstream.count())
+}
+
+func TestNewSubjectAccessReview(t *testing.T) {
+	review := newSubjectAccessReview(authorizer.AttributesRecord{
+		User: &user.DefaultInfo{Name: "user1", Groups: []string{"group1"}, Extra: map[string][]string{"team": {"platform"}}},
+		Verb: "get",
+		Path: "/version",
+	})
+	assert.Equal(t, v1beta1.SubjectAccessReviewSpec{
+		User:                  "user1",
+		Groups:                []string{"group1"},
+		Extra:                 map[string]v1beta1.ExtraValue{"team": {"platform"}},
+		NonResourceAttributes: &v1beta1.NonResourceAttributes{Path: "/version", Verb: "get"},
+	}, review.Spec)
+}
+
+func TestAuthorizationDelegation(t *testing.T) {
+	upstream := newFakeAuthzUpstream()
+	defer upstream.svc.Close()
+	config := writeTestWebhookConfig(t, upstream.svc, "/authorize")
+	defer os.Remove(config)
+
+	s, err := newTestingServiceWithOptions(defaultTestTokens, defaultTestAuthPolicy, options{
+		authzWebhookConfig: config,
+		webhookTimeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to create service, error: %s", err)
+	}
+	defer s.Close()
+
+	review := func(username, namespace string) v1beta1.SubjectAccessReview {
+		return v1beta1.SubjectAccessReview{
+			Spec: v1beta1.SubjectAccessReviewSpec{
+				User:               username,
+				ResourceAttributes: &v1beta1.ResourceAttributes{Resource: "pods", Namespace: namespace, Verb: "get"},
+			},
+		}
+	}
+
+	// step: allowed by the local policy, the upstream is never asked
+	status, err := makeTestAuthzRequest(s.URL(), review("admin", "default"))
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+	assert.Equal(t, 0, upstream.count())
+
+	status, err = makeTestAuthzRequest(s.URL(), review("user1", "upstream"))
+	assert.NoError(t, err)
+	assert.True(t, status.Status.Allowed)
+	assert.Equal(t, 1, upstream.count())
+
+	status, err = makeTestAuthzRequest(s.URL(), review("user1", "denied"))
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+	assert.Equal(t, "webhook: denied by upstream", status.Status.Reason)
+
+	status, err = makeTestAuthzRequest(s.URL(), review("user1", "default"))
+	assert.NoError(t, err)
+	assert.False(t, status.Status.Allowed)
+	assert.Equal(t, noPolicyMatched, status.Status.Reason)
+}
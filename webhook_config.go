@@ -0,0 +1,159 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// authenticationWebhookFile is the kubeconfig for --authentication-token-webhook-config-file
+	authenticationWebhookFile = "authentication-webhook.yaml"
+	// authorizationWebhookFile is the kubeconfig for --authorization-webhook-config-file
+	authorizationWebhookFile = "authorization-webhook.yaml"
+)
+
+// webhookConfigOptions are the settings used to generate the apiserver kubeconfigs
+type webhookConfigOptions struct {
+	// listen is the listen address of the service
+	listen string
+	// server is the url of the service, overriding the listen address
+	server string
+	// serverCA is the certificate authority of the serving certificate
+	serverCA string
+	// clientCert is the certificate the apiserver presents
+	clientCert string
+	// clientKey is the private key of the client certificate
+	clientKey string
+	// embed indicates the certificates are embedded rather than referenced
+	embed bool
+}
+
+// webhookServerURL returns the base url of the service from the options
+func webhookServerURL(o webhookConfigOptions) (string, error) {
+	if o.server != "" {
+		u, err := url.Parse(o.server)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "", fmt.Errorf("invalid server: %s, must be a url, e.g. https://127.0.0.1:8443", o.server)
+		}
+		return strings.TrimRight(o.server, "/"), nil
+	}
+	if strings.HasPrefix(o.listen, unixScheme) || strings.HasPrefix(o.listen, systemdScheme) {
+		return "", fmt.Errorf("the server url can't be derived from the listen address: %s, specify the server", o.listen)
+	}
+	host, port, err := net.SplitHostPort(o.listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address: %s, error: %s", o.listen, err)
+	}
+	// @note: the apiserver can't connect to a wildcard address
+	switch host {
+	case "", "0.0.0.0", "::":
+		host = "127.0.0.1"
+	}
+
+	return "https://" + net.JoinHostPort(host, port), nil
+}
+
+// newWebhookConfigs generates the authentication and authorization webhook kubeconfigs, keyed on the filename
+func newWebhookConfigs(o webhookConfigOptions) (map[string][]byte, error) {
+	server, err := webhookServerURL(o)
+	if err != nil {
+		return nil, err
+	}
+	if (o.clientCert == "") != (o.clientKey == "") {
+		return nil, fmt.Errorf("the client certificate and key must be specified together")
+	}
+
+	configs := make(map[string][]byte, 2)
+	for filename, kind := range map[string]string{
+		authenticationWebhookFile: tokenReviewKind,
+		authorizationWebhookFile:  accessReviewKind,
+	} {
+		config, err := newWebhookKubeConfig(server+authorizePath+kind, o)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := yaml.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		configs[filename] = encoded
+	}
+
+	return configs, nil
+}
+
+// newWebhookKubeConfig creates a kubeconfig for the apiserver to call the endpoint
+func newWebhookKubeConfig(endpoint string, o webhookConfigOptions) (*webhookKubeConfig, error) {
+	var err error
+	cluster := kubeConfigCluster{Name: "kube-auth"}
+	cluster.Cluster.Server = endpoint
+	user := kubeConfigUser{Name: "kube-auth"}
+	context := kubeConfigContext{Name: "kube-auth"}
+	context.Context.Cluster, context.Context.User = cluster.Name, user.Name
+
+	if o.embed {
+		if o.serverCA != "" {
+			if cluster.Cluster.CertificateAuthorityData, err = ioutil.ReadFile(o.serverCA); err != nil {
+				return nil, err
+			}
+		}
+		if o.clientCert != "" {
+			if user.User.ClientCertificateData, err = ioutil.ReadFile(o.clientCert); err != nil {
+				return nil, err
+			}
+			if user.User.ClientKeyData, err = ioutil.ReadFile(o.clientKey); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if cluster.Cluster.CertificateAuthority, err = absolutePath(o.serverCA); err != nil {
+			return nil, err
+		}
+		if user.User.ClientCertificate, err = absolutePath(o.clientCert); err != nil {
+			return nil, err
+		}
+		if user.User.ClientKey, err = absolutePath(o.clientKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return &webhookKubeConfig{
+		Clusters:       []kubeConfigCluster{cluster},
+		Users:          []kubeConfigUser{user},
+		Contexts:       []kubeConfigContext{context},
+		CurrentContext: context.Name,
+	}, nil
+}
+
+// absolutePath returns the absolute path of the file, as the apiserver resolves relative
+// paths against the kubeconfig rather than the working directory
+func absolutePath(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+
+	return filepath.Abs(filename)
+}
@@ -0,0 +1,145 @@
+/*
+
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestWebhookServerURL(t *testing.T) {
+	cs := []struct {
+		Options  webhookConfigOptions
+		Expected string
+		Error    bool
+	}{
+		{Options: webhookConfigOptions{listen: "127.0.0.1:8443"}, Expected: "https://127.0.0.1:8443"},
+		{Options: webhookConfigOptions{listen: "0.0.0.0:8443"}, Expected: "https://127.0.0.1:8443"},
+		{Options: webhookConfigOptions{listen: ":8443"}, Expected: "https://127.0.0.1:8443"},
+		{Options: webhookConfigOptions{listen: "[::1]:8443"}, Expected: "https://[::1]:8443"},
+		{Options: webhookConfigOptions{listen: ":8443", server: "https://kube-auth.local:443/"}, Expected: "https://kube-auth.local:443"},
+		{Options: webhookConfigOptions{listen: "unix:///var/run/kube-auth.sock"}, Error: true},
+		{Options: webhookConfigOptions{listen: "no_port"}, Error: true},
+		{Options: webhookConfigOptions{server: "kube-auth.local"}, Error: true},
+	}
+	for i, x := range cs {
+		server, err := webhookServerURL(x.Options)
+		if x.Error {
+			assert.Error(t, err, "case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, x.Expected, server, "case %d", i)
+	}
+}
+
+func TestNewWebhookConfigs(t *testing.T) {
+	cert, key := writeTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	defer os.Remove(cert)
+	defer os.Remove(key)
+
+	s := newTestService(t)
+	defer s.Close()
+
+	for _, embed := range []bool{false, true} {
+		configs, err := newWebhookConfigs(webhookConfigOptions{
+			listen:     "0.0.0.0:8443",
+			serverCA:   cert,
+			clientCert: cert,
+			clientKey:  key,
+			embed:      embed,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Len(t, configs, 2)
+
+		expected := map[string]string{
+			authenticationWebhookFile: "/authorize/token",
+			authorizationWebhookFile:  "/authorize/policy",
+		}
+		for name, path := range expected {
+			config := new(webhookKubeConfig)
+			assert.NoError(t, yaml.Unmarshal(configs[name], config))
+			if !assert.Len(t, config.Clusters, 1) || !assert.Len(t, config.Users, 1) {
+				continue
+			}
+			assert.Equal(t, "https://127.0.0.1:8443"+path, config.Clusters[0].Cluster.Server)
+			if embed {
+				assert.NotEmpty(t, config.Clusters[0].Cluster.CertificateAuthorityData)
+				assert.NotEmpty(t, config.Users[0].User.ClientKeyData)
+			} else {
+				assert.Equal(t, cert, config.Clusters[0].Cluster.CertificateAuthority)
+				assert.Equal(t, key, config.Users[0].User.ClientKey)
+			}
+
+			// step: the config must be usable as a webhook client
+			dir, err := ioutil.TempDir("/tmp", "kube-auth.XXXXXXXX")
+			if err != nil {
+				t.Fatalf("unable to create the directory, error: %s", err)
+			}
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, name)
+			assert.NoError(t, ioutil.WriteFile(filename, configs[name], 0600))
+			_, err = newWebhookClient(filename, webhookOptions{})
+			assert.NoError(t, err)
+
+			// step: the path must be served by the service
+			u, _ := url.Parse(config.Clusters[0].Cluster.Server)
+			res, err := hc.R().SetBody("{}").Post(s.URL() + u.Path)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode(), "path: %s", u.Path)
+		}
+	}
+
+	_, err := newWebhookConfigs(webhookConfigOptions{listen: "127.0.0.1:8443", clientCert: cert})
+	assert.Error(t, err)
+}
+
+func TestWebhookConfigCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "kube-auth.XXXXXXXX")
+	if err != nil {
+		t.Fatalf("unable to create the directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("KUBE_AUTH_LISTEN", "0.0.0.0:9443")
+	defer os.Unsetenv("KUBE_AUTH_LISTEN")
+
+	var opts options
+	app := cli.NewApp()
+	app.Flags = newServerFlags(&opts)
+	app.Commands = []cli.Command{newWebhookConfigCommand(&opts)}
+	assert.NoError(t, app.Run([]string{"kube-auth", "webhook-config", "--output-dir", dir}))
+
+	config := new(webhookKubeConfig)
+	content, err := ioutil.ReadFile(filepath.Join(dir, authorizationWebhookFile))
+	assert.NoError(t, err)
+	assert.NoError(t, yaml.Unmarshal(content, config))
+	if assert.Len(t, config.Clusters, 1) {
+		assert.Equal(t, "https://127.0.0.1:9443/authorize/policy", config.Clusters[0].Cluster.Server)
+	}
+}